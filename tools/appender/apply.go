@@ -0,0 +1,890 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// fileEdit collects every change a response makes to one file.
+type fileEdit struct {
+	path     string    // slash-separated, relative to the working directory
+	content  *string   // whole-file replacement
+	partial  inclusion // the content is an outline or a path, as exported, not the whole file
+	hunks    []diffHunk
+	replaces []replaceBlock
+	deleted  bool     // a diff removes the file
+	problems []string // parts of the response for this file that could not be read
+}
+
+// diffHunk is one hunk of a unified diff.
+type diffHunk struct {
+	header   string   // the "@@ -a,b +c,d @@" line
+	oldStart int      // first line of the hunk in the original file, 1-based
+	lines    []string // hunk body, each line prefixed with ' ', '-' or '+'
+}
+
+// replaceBlock is a SEARCH/REPLACE block.
+type replaceBlock struct {
+	search  string
+	replace string
+}
+
+// parsedResponse is what parseResponse found in a model's answer.
+type parsedResponse struct {
+	edits    []*fileEdit
+	problems []string // problems not tied to a file
+}
+
+// edit returns the edit for path, creating it in response order.
+func (r *parsedResponse) edit(path string) *fileEdit {
+	for _, edit := range r.edits {
+		if edit.path == path {
+			return edit
+		}
+	}
+	edit := &fileEdit{path: path}
+	r.edits = append(r.edits, edit)
+	return edit
+}
+
+var (
+	hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+	headingPathRegexp = regexp.MustCompile("^#{1,6}\\s+`([^`]+)`\\s*$")
+	fencePattern      = regexp.MustCompile("^(`{3,}|~{3,})\\s*([\\w+#.-]*)\\s*$")
+	sourcePattern     = regexp.MustCompile(`^<source>(.*)</source>$`)
+	inclusionPattern  = regexp.MustCompile(`^<inclusion>(.*)</inclusion>$`)
+)
+
+// parseResponse extracts file edits from a model response. It understands
+// unified diffs, whole files in the markdown, XML and JSON formats appender
+// writes, and SEARCH/REPLACE blocks preceded by the file path.
+func parseResponse(text string) *parsedResponse {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if resp, ok := parseJSONResponse(text); ok {
+		return resp
+	}
+
+	resp := &parsedResponse{}
+	lines := strings.Split(text, "\n")
+	lastPath := "" // most recent line that looked like a file path
+	source := ""   // most recent XML <source>
+	partial := ""  // <inclusion> of the XML document of source
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< SEARCH"):
+			end, block, ok := parseReplaceBlock(lines, i+1)
+			switch {
+			case lastPath == "":
+				resp.problems = append(resp.problems, fmt.Sprintf("line %d: SEARCH/REPLACE block without a file path", i+1))
+			case !ok:
+				edit := resp.edit(lastPath)
+				edit.problems = append(edit.problems, fmt.Sprintf("line %d: unterminated SEARCH/REPLACE block", i+1))
+			default:
+				edit := resp.edit(lastPath)
+				edit.replaces = append(edit.replaces, block)
+			}
+			i = end
+
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			i = parseFileDiff(resp, lines, i)
+
+		case headingPathRegexp.MatchString(line):
+			lastPath = headingPathRegexp.FindStringSubmatch(line)[1]
+			// A heading with a path followed by a fenced block is a whole
+			// file in appender's markdown format; status lines written for
+			// diff exports may sit in between.
+			j := i + 1
+			var label inclusion
+			for j < len(lines) && (strings.TrimSpace(lines[j]) == "" || isStatusLine(lines[j])) {
+				if isStatusLine(lines[j]) {
+					label = labelInclusion(lines[j])
+				}
+				j++
+			}
+			if j < len(lines) {
+				if match := fencePattern.FindStringSubmatch(lines[j]); match != nil && match[2] != "diff" {
+					end, content, ok := readFence(lines, j, match[1])
+					if !ok {
+						edit := resp.edit(lastPath)
+						edit.problems = append(edit.problems, fmt.Sprintf("line %d: unterminated code block", j+1))
+					} else if !strings.Contains(content, "<<<<<<< SEARCH") {
+						edit := resp.edit(lastPath)
+						edit.content, edit.partial = &content, label
+						i = end
+					}
+				}
+			}
+
+		case sourcePattern.MatchString(line):
+			source = html.UnescapeString(sourcePattern.FindStringSubmatch(line)[1])
+			lastPath = source
+			partial = ""
+
+		case inclusionPattern.MatchString(line):
+			partial = inclusionPattern.FindStringSubmatch(line)[1]
+
+		case line == "<document_content>":
+			end := i + 1
+			for end < len(lines) && lines[end] != "</document_content>" {
+				end++
+			}
+			switch {
+			case source == "":
+				resp.problems = append(resp.problems, fmt.Sprintf("line %d: document without a <source>", i+1))
+			case end == len(lines):
+				edit := resp.edit(source)
+				edit.problems = append(edit.problems, fmt.Sprintf("line %d: unterminated <document_content>", i+1))
+			default:
+				content := strings.Join(lines[i+1:end], "\n")
+				if end > i+1 {
+					content += "\n"
+				}
+				edit := resp.edit(source)
+				edit.content, edit.partial = &content, inclusion(partial)
+			}
+			i = end
+
+		default:
+			if path := pathCandidate(line); path != "" {
+				lastPath = path
+			}
+		}
+	}
+	return resp
+}
+
+// parseJSONResponse reads a response in appender's JSON or JSONL formats.
+func parseJSONResponse(text string) (*parsedResponse, bool) {
+	trimmed := strings.TrimSpace(text)
+	var files []bundleFile
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal([]byte(trimmed), &files); err != nil {
+			return nil, false
+		}
+	case strings.HasPrefix(trimmed, "{"):
+		for _, line := range strings.Split(trimmed, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var file bundleFile
+			if err := json.Unmarshal([]byte(line), &file); err != nil {
+				return nil, false
+			}
+			files = append(files, file)
+		}
+	default:
+		return nil, false
+	}
+
+	resp := &parsedResponse{}
+	for _, file := range files {
+		if file.Path == "" {
+			resp.problems = append(resp.problems, "JSON entry without a path")
+			continue
+		}
+		// Entries exported as a path, a diff, a status or a link carry no
+		// content to write back
+		if !file.hasContent() || file.Link != "" {
+			continue
+		}
+		content := file.Content
+		edit := resp.edit(file.Path)
+		edit.content, edit.partial = &content, inclusion(file.Inclusion)
+	}
+	return resp, true
+}
+
+// parseReplaceBlock reads a SEARCH/REPLACE block whose body starts at
+// lines[start]. It returns the index of the closing marker.
+func parseReplaceBlock(lines []string, start int) (int, replaceBlock, bool) {
+	divider := -1
+	for i := start; i < len(lines); i++ {
+		switch {
+		case divider < 0 && strings.HasPrefix(lines[i], "======="):
+			divider = i
+		case divider >= 0 && strings.HasPrefix(lines[i], ">>>>>>> REPLACE"):
+			return i, replaceBlock{
+				search:  joinLines(lines[start:divider]),
+				replace: joinLines(lines[divider+1 : i]),
+			}, true
+		}
+	}
+	return len(lines), replaceBlock{}, false
+}
+
+// joinLines joins lines into newline-terminated text.
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parseFileDiff reads the unified diff of one file whose "---" line is
+// lines[start] and returns the index of its last line.
+func parseFileDiff(resp *parsedResponse, lines []string, start int) int {
+	oldPath := diffPath(lines[start][4:])
+	newPath := diffPath(lines[start+1][4:])
+
+	target := newPath
+	if newPath == "" {
+		target = oldPath
+	}
+	if target == "" {
+		resp.problems = append(resp.problems, fmt.Sprintf("line %d: diff without a file path", start+1))
+		return start + 1
+	}
+	edit := resp.edit(target)
+	edit.deleted = newPath == ""
+
+	i := start + 2
+	for i < len(lines) {
+		match := hunkHeaderPattern.FindStringSubmatch(lines[i])
+		if match == nil {
+			break
+		}
+		hunk := diffHunk{header: lines[i]}
+		hunk.oldStart, _ = strconv.Atoi(match[1])
+		oldCount, newCount := hunkCount(match[2]), hunkCount(match[4])
+
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if line == "" {
+				// Blank context lines often lose their leading space
+				if oldCount <= 0 && newCount <= 0 {
+					break
+				}
+				line = " "
+			}
+			if prefix := line[0]; prefix == '\\' {
+				i++
+				continue
+			} else if prefix != ' ' && prefix != '-' && prefix != '+' {
+				break
+			}
+			if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+				break // the next file's diff
+			}
+			switch line[0] {
+			case ' ':
+				oldCount--
+				newCount--
+			case '-':
+				oldCount--
+			case '+':
+				newCount--
+			}
+			hunk.lines = append(hunk.lines, line)
+			i++
+		}
+		edit.hunks = append(edit.hunks, hunk)
+	}
+
+	if len(edit.hunks) == 0 && !edit.deleted {
+		edit.problems = append(edit.problems, fmt.Sprintf("line %d: diff without hunks", start+1))
+	}
+	return i - 1
+}
+
+// hunkCount parses the optional line count of a hunk header.
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// diffPath extracts the file path from a "---" or "+++" line, dropping the
+// a/ and b/ prefixes and any timestamp. It returns "" for /dev/null.
+func diffPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// readFence returns the content of the fenced block opened at lines[start]
+// and the index of its closing fence.
+func readFence(lines []string, start int, fence string) (int, string, bool) {
+	for i := start + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == fence {
+			return i, joinLines(lines[start+1 : i]), true
+		}
+	}
+	return len(lines), "", false
+}
+
+// isStatusLine matches the italic change status appender writes below
+// headings of diff exports.
+func isStatusLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) > 2 && strings.HasPrefix(line, "_") && strings.HasSuffix(line, "_")
+}
+
+// labelInclusion returns the inclusion named in the italic label appender
+// writes below headings of files not exported in full, if any.
+func labelInclusion(line string) inclusion {
+	for _, part := range strings.Split(strings.Trim(strings.TrimSpace(line), "_"), ", ") {
+		for _, i := range []inclusion{inclusionOutline, inclusionPath} {
+			if part == i.label() {
+				return i
+			}
+		}
+	}
+	return ""
+}
+
+// pathCandidate returns line as a file path when it looks like one, such as
+// the path models write above a SEARCH/REPLACE block. Surrounding markdown
+// is stripped.
+func pathCandidate(line string) string {
+	s := strings.TrimSpace(line)
+	s = strings.TrimLeft(s, "#")
+	s = strings.TrimSpace(s)
+	s = strings.Trim(s, "*`")
+	s = strings.TrimSuffix(s, ":")
+	s = strings.Trim(s, "*`")
+	if s == "" || strings.ContainsAny(s, " \t<>|\"'(){}[];=,") || fencePattern.MatchString(line) {
+		return ""
+	}
+	if !strings.Contains(s, ".") && !strings.Contains(s, "/") {
+		return ""
+	}
+	return s
+}
+
+// applyResult is the outcome of applying the edits for one file.
+type applyResult struct {
+	path      string // slash-separated, relative to the working directory
+	absPath   string
+	before    string // decoded to UTF-8 with LF line endings, like after
+	after     string
+	encoding  textEncoding // how the file is stored, kept when it is written back
+	exists    bool         // the file exists on disk
+	delete    bool         // the file is removed
+	applied   int          // hunks, blocks and whole files that applied
+	conflicts []string     // edits that could not be applied
+	accepted  bool         // write this file
+
+	// Set when the file changed on disk since the export recorded in the
+	// manifest. base is the exported version, if it was kept, and theirs
+	// the edits applied to it; merge combines them with the file on disk.
+	stale         bool
+	base          *string
+	theirs        string
+	theirsApplied int
+	problems      []string // parse problems, kept when a merge replaces the conflicts
+	merged        bool
+}
+
+// changed reports whether writing the result would modify the working tree.
+func (r *applyResult) changed() bool {
+	if r.delete {
+		return r.exists
+	}
+	return !r.exists || r.before != r.after
+}
+
+// planEdits applies edits in memory against the files below workDir. Nothing
+// is written; see writeResults. With a manifest, files that changed since
+// they were exported are flagged as stale and left unaccepted.
+func planEdits(workDir string, edits []*fileEdit, man *manifest) []*applyResult {
+	results := make([]*applyResult, 0, len(edits))
+	for _, edit := range edits {
+		result := &applyResult{path: edit.path, problems: edit.problems, conflicts: append([]string{}, edit.problems...)}
+		results = append(results, result)
+
+		absPath, err := resolveEditPath(workDir, edit.path)
+		if err != nil {
+			result.conflicts = append(result.conflicts, err.Error())
+			continue
+		}
+		result.absPath = absPath
+
+		data, err := os.ReadFile(absPath)
+		switch {
+		case err == nil:
+			result.exists = true
+			result.before, result.encoding = decodeForEdit(data)
+		case !errors.Is(err, fs.ErrNotExist):
+			result.conflicts = append(result.conflicts, err.Error())
+			continue
+		}
+
+		outcome := applyEdit(result.before, result.exists, edit)
+		result.after = outcome.content
+		result.applied = outcome.applied
+		result.delete = outcome.delete
+		result.conflicts = append(result.conflicts, outcome.conflicts...)
+		result.accepted = result.applied > 0 && result.changed()
+		if _, err := encodeText(result.after, result.encoding); err != nil && !result.delete {
+			// The edits use characters the original encoding lacks
+			result.conflicts = append(result.conflicts, err.Error())
+			result.accepted = false
+		}
+
+		if man != nil {
+			man.checkStale(result, edit)
+		}
+	}
+	return results
+}
+
+// editOutcome is the result of applying a fileEdit to one version of a
+// file.
+type editOutcome struct {
+	content   string
+	applied   int
+	conflicts []string
+	delete    bool
+}
+
+// applyEdit applies the whole-file content, hunks, SEARCH/REPLACE blocks
+// and deletion of edit, in that order, to content.
+func applyEdit(content string, exists bool, edit *fileEdit) editOutcome {
+	var out editOutcome
+	switch {
+	case edit.content != nil && edit.partial == inclusionOutline:
+		// Writing an outline back would drop every function body
+		out.conflicts = append(out.conflicts, "whole file is an outline: ask for a diff or SEARCH/REPLACE blocks instead")
+	case edit.content != nil && edit.partial == inclusionPath:
+		out.conflicts = append(out.conflicts, "whole file was exported as its path only: ask for a diff or SEARCH/REPLACE blocks instead")
+	case edit.content != nil && elisionMarker.MatchString(*edit.content):
+		// Writing the lines of a partial export back would lose the others
+		out.conflicts = append(out.conflicts, "whole file has omitted lines: ask for a diff or SEARCH/REPLACE blocks instead")
+	case edit.content != nil && truncationMarker.MatchString(*edit.content):
+		// So would writing back a file trimmed to the token budget
+		out.conflicts = append(out.conflicts, "whole file was truncated to fit the token budget: ask for a diff or SEARCH/REPLACE blocks instead")
+	case edit.content != nil:
+		content = *edit.content
+		out.applied++
+	}
+	for _, hunk := range edit.hunks {
+		updated, err := applyHunk(content, hunk, !exists && edit.content == nil)
+		if err != nil {
+			out.conflicts = append(out.conflicts, err.Error())
+			continue
+		}
+		content = updated
+		out.applied++
+	}
+	for i, block := range edit.replaces {
+		updated, err := applyReplace(content, block, exists || edit.content != nil)
+		if err != nil {
+			out.conflicts = append(out.conflicts, fmt.Sprintf("SEARCH/REPLACE block %d: %v", i+1, err))
+			continue
+		}
+		content = updated
+		out.applied++
+	}
+	if edit.deleted {
+		if exists {
+			out.delete = true
+			out.applied++
+		} else {
+			out.conflicts = append(out.conflicts, "cannot delete: file does not exist")
+		}
+	}
+	out.content = content
+	return out
+}
+
+// resolveEditPath turns a path from a response into an absolute path,
+// refusing anything outside workDir, including what symlinks in the tree
+// lead to.
+func resolveEditPath(workDir, relPath string) (string, error) {
+	errOutside := fmt.Errorf("refusing to write outside the working directory")
+	clean := path.Clean(filepath.ToSlash(relPath))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.IsAbs(relPath) {
+		return "", errOutside
+	}
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(absWorkDir, filepath.FromSlash(clean))
+
+	// Resolve the part of the path that exists; the rest is created below it
+	root, err := filepath.EvalSymlinks(absWorkDir)
+	if err != nil {
+		return "", err
+	}
+	existing, rest := target, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", relPath, err)
+	}
+	if resolved = filepath.Join(resolved, rest); resolved != root && !inDir(resolved, root) {
+		return "", errOutside
+	}
+	return target, nil
+}
+
+// applyHunk applies hunk to content. The hunk's context and removed lines
+// are located nearest to the line the header names, so hunks still apply
+// when earlier edits shifted the file. newFile allows a hunk without
+// context to create the file.
+func applyHunk(content string, hunk diffHunk, newFile bool) (string, error) {
+	var oldLines, newLines []string
+	for _, line := range hunk.lines {
+		switch line[0] {
+		case ' ':
+			oldLines = append(oldLines, line[1:])
+			newLines = append(newLines, line[1:])
+		case '-':
+			oldLines = append(oldLines, line[1:])
+		case '+':
+			newLines = append(newLines, line[1:])
+		}
+	}
+
+	lines, trailingNewline := splitContent(content)
+	if newFile && len(lines) == 0 {
+		trailingNewline = true
+	}
+
+	at := -1
+	if len(oldLines) == 0 {
+		at = min(max(hunk.oldStart, 0), len(lines))
+	} else {
+		at = findLines(lines, oldLines, hunk.oldStart-1, func(a, b string) bool { return a == b })
+		if at < 0 {
+			// Models often get trailing whitespace wrong
+			at = findLines(lines, oldLines, hunk.oldStart-1, func(a, b string) bool {
+				return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t")
+			})
+		}
+	}
+	if at < 0 {
+		return "", fmt.Errorf("hunk %s does not apply: its context was not found", hunk.header)
+	}
+
+	updated := make([]string, 0, len(lines)-len(oldLines)+len(newLines))
+	updated = append(updated, lines[:at]...)
+	updated = append(updated, newLines...)
+	updated = append(updated, lines[at+len(oldLines):]...)
+	return joinContent(updated, trailingNewline), nil
+}
+
+// findLines returns the index of the occurrence of want in lines closest to
+// near, or -1.
+func findLines(lines, want []string, near int, equal func(a, b string) bool) int {
+	best := -1
+	for i := 0; i+len(want) <= len(lines); i++ {
+		match := true
+		for j := range want {
+			if !equal(lines[i+j], want[j]) {
+				match = false
+				break
+			}
+		}
+		if match && (best < 0 || distance(i, near) < distance(best, near)) {
+			best = i
+		}
+	}
+	return best
+}
+
+func distance(a, b int) int {
+	if a < b {
+		return b - a
+	}
+	return a - b
+}
+
+// splitContent splits content into lines, reporting whether it ended with
+// a newline.
+func splitContent(content string) ([]string, bool) {
+	if content == "" {
+		return nil, false
+	}
+	trailing := strings.HasSuffix(content, "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), trailing
+}
+
+func joinContent(lines []string, trailingNewline bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	content := strings.Join(lines, "\n")
+	if trailingNewline {
+		content += "\n"
+	}
+	return content
+}
+
+// applyReplace applies a SEARCH/REPLACE block. The search text must occur
+// exactly once; an empty search creates a file that does not exist yet.
+func applyReplace(content string, block replaceBlock, exists bool) (string, error) {
+	if block.search == "" {
+		if exists && content != "" {
+			return "", fmt.Errorf("empty SEARCH section for an existing file")
+		}
+		return block.replace, nil
+	}
+	switch n := strings.Count(content, block.search); n {
+	case 0:
+		return "", fmt.Errorf("SEARCH text not found")
+	case 1:
+		return strings.Replace(content, block.search, block.replace, 1), nil
+	default:
+		return "", fmt.Errorf("SEARCH text matches %d places", n)
+	}
+}
+
+// writeResults writes the accepted results to disk, keeping the mode of
+// existing files.
+func writeResults(results []*applyResult) error {
+	var errs []error
+	for _, result := range results {
+		if !result.accepted || !result.changed() {
+			continue
+		}
+		if result.delete {
+			if err := os.Remove(result.absPath); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		mode := fs.FileMode(0o644)
+		if info, err := os.Stat(result.absPath); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := os.MkdirAll(filepath.Dir(result.absPath), 0o755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		data, err := encodeText(result.after, result.encoding)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %w", result.path, err))
+			continue
+		}
+		if err := os.WriteFile(result.absPath, data, mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// previewDiff renders the change a result makes as a unified diff, using
+// `git diff --no-index` on temporary copies of both versions.
+func previewDiff(result *applyResult, color bool) (string, error) {
+	if !result.changed() {
+		return "", nil
+	}
+	tmp, err := os.MkdirTemp("", "appender-apply-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	side := func(prefix, content string, present bool) (string, error) {
+		if !present {
+			return os.DevNull, nil
+		}
+		rel := filepath.Join(prefix, filepath.FromSlash(result.path))
+		full := filepath.Join(tmp, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			return "", err
+		}
+		return rel, os.WriteFile(full, []byte(content), 0o600)
+	}
+	before, err := side("a", result.before, result.exists)
+	if err != nil {
+		return "", err
+	}
+	after, err := side("b", result.after, !result.delete)
+	if err != nil {
+		return "", err
+	}
+
+	colorFlag := "--no-color"
+	if color {
+		colorFlag = "--color=always"
+	}
+	out, err := runGitAllowExit(tmp, 1, "diff", "--no-index", "--no-prefix", colorFlag, "--", before, after)
+	return string(out), err
+}
+
+// applyReport summarizes results, one line per file followed by its
+// conflicts, along with problems not tied to a file.
+func applyReport(results []*applyResult, problems []string) string {
+	var b strings.Builder
+	for _, result := range results {
+		status := "unchanged"
+		switch {
+		case result.delete:
+			status = "delete"
+		case !result.exists && result.changed():
+			status = "create"
+		case result.changed():
+			status = "modify"
+		}
+		fmt.Fprintf(&b, "%s: %s, %d applied", result.path, status, result.applied)
+		switch {
+		case result.merged:
+			b.WriteString(", changed since export and merged")
+		case result.stale:
+			b.WriteString(", changed since export")
+		}
+		if len(result.conflicts) > 0 {
+			fmt.Fprintf(&b, ", %d conflicts", len(result.conflicts))
+		}
+		b.WriteString("\n")
+		for _, conflict := range result.conflicts {
+			fmt.Fprintf(&b, "  ! %s\n", conflict)
+		}
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(&b, "! %s\n", problem)
+	}
+	return b.String()
+}
+
+// applyOptions holds the settings for `appender apply`.
+type applyOptions struct {
+	workDir   string
+	input     string // response file, "-" for stdin, "" for the clipboard
+	dryRun    bool
+	clipboard bool
+	manifest  string // manifest to check for stale files; defaultManifest when empty
+	stale     string // what to do with stale files: staleAbort or staleMerge
+}
+
+// Ways to handle files that changed since they were exported.
+const (
+	staleAbort = "abort" // write nothing
+	staleMerge = "merge" // three-way merge the edits into the current file
+)
+
+// addApplyFlags registers the flags of the apply command.
+func addApplyFlags(flags *pflag.FlagSet) {
+	flags.Bool("dry-run", false, "With apply, show the changes and conflicts without writing anything")
+	flags.Bool("clipboard", false, "With apply, read the response from the clipboard")
+	flags.String("stale", staleAbort, "With apply, what to do when files changed since the export: abort or merge")
+}
+
+// readResponse reads a response from opts.input, stdin or the clipboard.
+func readResponse(opts applyOptions, stdin io.Reader, clipboardRead func() (string, error)) (string, error) {
+	switch {
+	case opts.clipboard || opts.input == "":
+		return clipboardRead()
+	case opts.input == "-":
+		data, err := io.ReadAll(stdin)
+		return string(data), err
+	default:
+		data, err := os.ReadFile(opts.input)
+		return string(data), err
+	}
+}
+
+// errConflicts is returned by runApply when some edits did not apply.
+var errConflicts = errors.New("some edits could not be applied")
+
+// errStale is returned by runApply when files changed since the export and
+// the apply was aborted.
+var errStale = errors.New("files changed since the export: nothing was written (use --stale merge to three-way merge)")
+
+// loadApplyManifest reads the manifest named by path, or the default
+// manifest of workDir if there is one.
+func loadApplyManifest(workDir, path string) (*manifest, error) {
+	optional := path == "" || path == defaultManifest
+	if path == "" {
+		path = defaultManifest
+	}
+	resolved, err := resolveManifestPath(workDir, path)
+	if err != nil {
+		return nil, err
+	}
+	return readManifest(resolved, optional)
+}
+
+// runApply parses response, writes every edit that applies and reports the
+// rest to stderr. With dryRun the diffs are printed instead of written.
+// Files that changed since the export recorded in the manifest abort the
+// apply, or are three-way merged with opts.stale set to staleMerge.
+func runApply(opts applyOptions, response string, stdout, stderr io.Writer) error {
+	parsed := parseResponse(response)
+	if len(parsed.edits) == 0 {
+		fmt.Fprint(stderr, applyReport(nil, parsed.problems))
+		return fmt.Errorf("no edits found in the response")
+	}
+
+	man, err := loadApplyManifest(opts.workDir, opts.manifest)
+	if err != nil {
+		return err
+	}
+	results := planEdits(opts.workDir, parsed.edits, man)
+
+	var stale bool
+	for _, result := range results {
+		if !result.stale {
+			continue
+		}
+		stale = true
+		if opts.stale != staleMerge {
+			continue
+		}
+		if err := result.merge(); err != nil {
+			result.conflicts = append(result.conflicts, err.Error())
+		}
+	}
+	if stale && opts.stale != staleMerge {
+		fmt.Fprint(stderr, applyReport(results, parsed.problems))
+		return errStale
+	}
+
+	if opts.dryRun {
+		for _, result := range results {
+			diff, err := previewDiff(result, false)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(stdout, diff)
+		}
+	} else if err := writeResults(results); err != nil {
+		return err
+	}
+
+	fmt.Fprint(stderr, applyReport(results, parsed.problems))
+	for _, result := range results {
+		if len(result.conflicts) > 0 {
+			return errConflicts
+		}
+	}
+	if len(parsed.problems) > 0 {
+		return errConflicts
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expect   map[string]string // path to a summary of its edits
+		problems int
+	}{
+		{
+			name:     "unified diff in a fence",
+			response: "Here is the fix:\n\n```diff\n--- a/lib/a.go\n+++ b/lib/a.go\n@@ -1,2 +1,2 @@\n package lib\n-var A = 1\n+var A = 2\n```\n",
+			expect:   map[string]string{"lib/a.go": "hunks=1"},
+		},
+		{
+			name:     "new and deleted files",
+			response: "--- /dev/null\n+++ b/new.go\n@@ -0,0 +1 @@\n+package main\n--- a/old.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package main\n",
+			expect:   map[string]string{"new.go": "hunks=1", "old.go": "hunks=1 deleted"},
+		},
+		{
+			name:     "markdown whole file",
+			response: "## `cmd/main.go`\n\n```go\npackage main\n```\n",
+			expect:   map[string]string{"cmd/main.go": "content"},
+		},
+		{
+			name:     "xml whole file",
+			response: "<documents>\n<document index=\"1\">\n<source>a&amp;b.txt</source>\n<document_content>\nhi\n</document_content>\n</document>\n</documents>\n",
+			expect:   map[string]string{"a&b.txt": "content"},
+		},
+		{
+			name:     "json",
+			response: `[{"path":"x.txt","content":"x\n"}]`,
+			expect:   map[string]string{"x.txt": "content"},
+		},
+		{
+			name: "json entries without content",
+			response: `[{"path":"a.go","content":"","inclusion":"path"},` +
+				`{"path":"b.go","content":"","status":"modified","diff":"--- a/b.go\n"},` +
+				`{"path":"c.go","content":"","status":"unchanged"},` +
+				`{"path":"d.go","content":"","link":"c.go"},` +
+				`{"path":"e.go","content":""}]`,
+			expect: map[string]string{"e.go": "content"},
+		},
+		{
+			name:     "search replace",
+			response: "**lib/a.go**\n```go\n<<<<<<< SEARCH\nvar A = 1\n=======\nvar A = 2\n>>>>>>> REPLACE\n```\n",
+			expect:   map[string]string{"lib/a.go": "replaces=1"},
+		},
+		{
+			name:     "search replace without a path",
+			response: "<<<<<<< SEARCH\na\n=======\nb\n>>>>>>> REPLACE\n",
+			expect:   map[string]string{},
+			problems: 1,
+		},
+		{
+			name:     "unterminated block",
+			response: "lib/a.go\n<<<<<<< SEARCH\na\n=======\nb\n",
+			expect:   map[string]string{"lib/a.go": "problems=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := parseResponse(tt.response)
+			got := make(map[string]string, len(resp.edits))
+			for _, edit := range resp.edits {
+				var parts []string
+				if edit.content != nil {
+					parts = append(parts, "content")
+				}
+				if len(edit.hunks) > 0 {
+					parts = append(parts, fmt.Sprintf("hunks=%d", len(edit.hunks)))
+				}
+				if len(edit.replaces) > 0 {
+					parts = append(parts, fmt.Sprintf("replaces=%d", len(edit.replaces)))
+				}
+				if edit.deleted {
+					parts = append(parts, "deleted")
+				}
+				if len(edit.problems) > 0 {
+					parts = append(parts, fmt.Sprintf("problems=%d", len(edit.problems)))
+				}
+				got[edit.path] = strings.Join(parts, " ")
+			}
+			require.Equal(t, tt.expect, got)
+			require.Len(t, resp.problems, tt.problems)
+		})
+	}
+}
+
+func Test_runApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		response  string
+		dryRun    bool
+		expect    map[string]string // file contents afterwards, "" for absent
+		conflicts bool
+		report    string
+	}{
+		{
+			name:  "diff with shifted hunk",
+			files: map[string]string{"a.go": "package a\n\n// added above\n\nvar A = 1\nvar B = 2\n"},
+			response: "--- a/a.go\n+++ b/a.go\n@@ -3,2 +3,2 @@\n" +
+				"-var A = 1\n+var A = 10\n var B = 2\n",
+			expect: map[string]string{"a.go": "package a\n\n// added above\n\nvar A = 10\nvar B = 2\n"},
+			report: "a.go: modify, 1 applied\n",
+		},
+		{
+			name:     "search replace and new file",
+			files:    map[string]string{"a.go": "package a\n\nvar A = 1\n"},
+			response: "a.go\n<<<<<<< SEARCH\nvar A = 1\n=======\nvar A = 2\n>>>>>>> REPLACE\n\nb/new.go\n<<<<<<< SEARCH\n=======\npackage b\n>>>>>>> REPLACE\n",
+			expect:   map[string]string{"a.go": "package a\n\nvar A = 2\n", "b/new.go": "package b\n"},
+		},
+		{
+			name:      "conflicts are reported and the rest applies",
+			files:     map[string]string{"a.go": "package a\n\nvar A = 1\n", "b.go": "package b\n"},
+			response:  "a.go\n<<<<<<< SEARCH\nvar Missing = 1\n=======\nvar A = 2\n>>>>>>> REPLACE\n\n## `b.go`\n\n```go\npackage b\n\nvar B = 1\n```\n",
+			expect:    map[string]string{"a.go": "package a\n\nvar A = 1\n", "b.go": "package b\n\nvar B = 1\n"},
+			conflicts: true,
+			report:    "a.go: unchanged, 0 applied, 1 conflicts\n  ! SEARCH/REPLACE block 1: SEARCH text not found\nb.go: modify, 1 applied\n",
+		},
+		{
+			name:      "truncated files are refused",
+			files:     map[string]string{"a.go": "package a\n\nvar A = 1\nvar B = 2\n"},
+			response:  "## `a.go`\n\n```go\npackage a\n\n... 2 lines truncated to fit the token budget ...\n```\n",
+			expect:    map[string]string{"a.go": "package a\n\nvar A = 1\nvar B = 2\n"},
+			conflicts: true,
+			report:    "a.go: unchanged, 0 applied, 1 conflicts\n  ! whole file was truncated to fit the token budget: ask for a diff or SEARCH/REPLACE blocks instead\n",
+		},
+		{
+			name:     "dry run writes nothing",
+			files:    map[string]string{"a.go": "package a\n"},
+			response: "## `a.go`\n\n```go\npackage b\n```\n",
+			dryRun:   true,
+			expect:   map[string]string{"a.go": "package a\n"},
+		},
+		{
+			name:     "delete",
+			files:    map[string]string{"a.go": "package a\n"},
+			response: "--- a/a.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package a\n",
+			expect:   map[string]string{"a.go": ""},
+		},
+		{
+			name:      "paths outside the working directory are refused",
+			files:     map[string]string{},
+			response:  "## `../escape.txt`\n\n```\nx\n```\n",
+			expect:    map[string]string{},
+			conflicts: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			var stdout, stderr strings.Builder
+			err := runApply(applyOptions{workDir: dir, dryRun: tt.dryRun}, tt.response, &stdout, &stderr)
+			if tt.conflicts {
+				require.ErrorIs(t, err, errConflicts)
+			} else {
+				require.NoError(t, err, stderr.String())
+			}
+			if tt.report != "" {
+				require.Equal(t, tt.report, stderr.String())
+			}
+			if tt.dryRun {
+				require.Contains(t, stdout.String(), "+package b")
+			}
+
+			for path, content := range tt.expect {
+				data, err := os.ReadFile(filepath.Join(dir, path))
+				if content == "" {
+					require.True(t, os.IsNotExist(err), path)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, content, string(data), path)
+			}
+			_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt"))
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func Test_runApplySymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"in/a.go": "package in\n"})
+	writeFiles(t, outside, map[string]string{"x.go": "package x\n"})
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "x.go"), filepath.Join(dir, "x.go")))
+	require.NoError(t, os.Symlink("in", filepath.Join(dir, "inner")))
+
+	response := "## `link/x.go`\n\n```go\npackage changed\n```\n\n" +
+		"## `link/new/y.go`\n\n```go\npackage y\n```\n\n" +
+		"## `x.go`\n\n```go\npackage changed\n```\n\n" +
+		"## `inner/a.go`\n\n```go\npackage inner\n```\n"
+	var stdout, stderr strings.Builder
+	err := runApply(applyOptions{workDir: dir}, response, &stdout, &stderr)
+	require.ErrorIs(t, err, errConflicts)
+	require.Equal(t, 3, strings.Count(stderr.String(), "refusing to write outside the working directory"))
+
+	data, err := os.ReadFile(filepath.Join(outside, "x.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package x\n", string(data))
+	_, err = os.Stat(filepath.Join(outside, "new"))
+	require.True(t, os.IsNotExist(err))
+	// Links that stay in the tree are followed
+	data, err = os.ReadFile(filepath.Join(dir, "in", "a.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package inner\n", string(data))
+}
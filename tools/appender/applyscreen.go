@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// applyScreen previews the edits of a model response read from the
+// clipboard and lets the user pick which files to write.
+type applyScreen struct {
+	results  []*applyResult
+	problems []string // parts of the response not tied to a file
+	cursor   int
+	err      error // reading the response or writing the files failed
+}
+
+// applyKeyMap lists the keys of the apply screen for the help view.
+type applyKeyMap struct {
+	Up, Down, Toggle, All, Merge, MergeAll, Write, Cancel key.Binding
+}
+
+func (k applyKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Toggle, k.All, k.Merge, k.Write, k.Cancel}
+}
+
+func (k applyKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Up, k.Down}, {k.Toggle, k.All}, {k.Merge, k.MergeAll}, {k.Write, k.Cancel}}
+}
+
+var applyKeys = applyKeyMap{
+	Up:       key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "move up")),
+	Down:     key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "move down")),
+	Toggle:   key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "accept/reject file")),
+	All:      key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "accept all")),
+	Merge:    key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "merge stale file")),
+	MergeAll: key.NewBinding(key.WithKeys("M"), key.WithHelp("M", "merge all stale files")),
+	Write:    key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "write accepted")),
+	Cancel:   key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "cancel")),
+}
+
+// openApply reads a response from the clipboard and shows the apply screen.
+func (m *model) openApply() tea.Cmd {
+	screen := &applyScreen{}
+	m.apply = screen
+
+	response, err := clipboard.ReadAll()
+	if err != nil {
+		screen.err = fmt.Errorf("reading clipboard: %w", err)
+		return m.updateApplyPanes()
+	}
+	parsed := parseResponse(response)
+	screen.problems = parsed.problems
+	if len(parsed.edits) == 0 {
+		screen.err = fmt.Errorf("no edits found in the clipboard")
+		return m.updateApplyPanes()
+	}
+	// Exports made with --manifest, or earlier ones with the default
+	// manifest, tell which files changed since the model saw them
+	path := m.manifestPath
+	if path == "" {
+		path = defaultManifest
+	}
+	path, err = resolveManifestPath(m.workDir, path)
+	var man *manifest
+	if err == nil {
+		man, err = readManifest(path, true)
+	}
+	if err != nil {
+		screen.err = err
+	}
+	screen.results = planEdits(m.workDir, parsed.edits, man)
+	return m.updateApplyPanes()
+}
+
+// updateApply handles keys while the apply screen is open.
+func (m *model) updateApply(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	screen := m.apply
+	switch {
+	case key.Matches(msg, applyKeys.Cancel):
+		m.apply = nil
+		return m, tea.Batch(m.updateTree(), m.updateContent())
+
+	case key.Matches(msg, applyKeys.Up):
+		if screen.cursor > 0 {
+			screen.cursor--
+		}
+	case key.Matches(msg, applyKeys.Down):
+		if screen.cursor < len(screen.results)-1 {
+			screen.cursor++
+		}
+	case key.Matches(msg, applyKeys.Toggle):
+		if result := screen.current(); result != nil && result.applied > 0 && result.changed() && (!result.stale || result.merged) {
+			result.accepted = !result.accepted
+		}
+	case key.Matches(msg, applyKeys.All):
+		for _, result := range screen.results {
+			// Stale files are only written once merged
+			result.accepted = result.applied > 0 && result.changed() && (!result.stale || result.merged)
+		}
+	case key.Matches(msg, applyKeys.Merge):
+		if result := screen.current(); result != nil && result.stale {
+			screen.err = result.merge()
+		}
+	case key.Matches(msg, applyKeys.MergeAll):
+		screen.err = nil
+		for _, result := range screen.results {
+			if result.stale && !result.merged {
+				if err := result.merge(); err != nil {
+					screen.err = err
+				}
+			}
+		}
+
+	case key.Matches(msg, applyKeys.Write):
+		if err := writeResults(screen.results); err != nil {
+			screen.err = err
+			return m, m.updateApplyPanes()
+		}
+		m.notice = screen.summary()
+		m.apply = nil
+		// New files must show up in the tree
+		for _, result := range screen.results {
+			if !result.accepted {
+				continue
+			}
+			m.reloadDir(filepath.Dir(filepath.Join(m.workDir, filepath.FromSlash(result.path))))
+		}
+		m.flattenTree()
+		return m, tea.Batch(m.updateTree(), m.updateContent(), m.countTokensCmd(), m.loadGitStatusCmd())
+
+	case key.Matches(msg, m.keys.ScrollUp):
+		m.rightViewport.HalfViewUp()
+		return m, nil
+	case key.Matches(msg, m.keys.ScrollDown):
+		m.rightViewport.HalfViewDown()
+		return m, nil
+	}
+	return m, m.updateApplyPanes()
+}
+
+func (s *applyScreen) current() *applyResult {
+	if s.cursor < 0 || s.cursor >= len(s.results) {
+		return nil
+	}
+	return s.results[s.cursor]
+}
+
+// summary describes what writing the accepted files did.
+func (s *applyScreen) summary() string {
+	written, conflicts := 0, 0
+	for _, result := range s.results {
+		if result.accepted && result.changed() {
+			written++
+		}
+		conflicts += len(result.conflicts)
+	}
+	summary := fmt.Sprintf("applied changes to %d files", written)
+	if conflicts > 0 {
+		summary += fmt.Sprintf(", %d edits did not apply", conflicts)
+	}
+	return summary
+}
+
+// updateApplyPanes renders the file list on the left and the diff and
+// conflicts of the file under the cursor on the right.
+func (m *model) updateApplyPanes() tea.Cmd {
+	screen := m.apply
+	var list strings.Builder
+	list.WriteString(lipgloss.NewStyle().Bold(true).Render("Apply response") + "\n\n")
+	if screen.err != nil {
+		list.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(screen.err.Error()) + "\n\n")
+	}
+
+	conflictStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	staleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	for i, result := range screen.results {
+		box := "[ ]"
+		if result.accepted {
+			box = "[x]"
+		}
+		line := fmt.Sprintf("%s %s", box, result.path)
+		if result.stale && !result.merged {
+			line += staleStyle.Render(" ~stale")
+		}
+		if len(result.conflicts) > 0 {
+			line += conflictStyle.Render(fmt.Sprintf(" !%d", len(result.conflicts)))
+		}
+		if i == screen.cursor {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Render("> ") + line
+		} else {
+			line = "  " + line
+		}
+		list.WriteString(line + "\n")
+	}
+	for _, problem := range screen.problems {
+		list.WriteString(conflictStyle.Render("! "+problem) + "\n")
+	}
+	m.leftViewport.SetContent(list.String())
+
+	var preview strings.Builder
+	if result := screen.current(); result != nil {
+		switch {
+		case result.canMerge():
+			preview.WriteString(staleStyle.Render(result.path+" changed since it was exported. Press m to three-way merge the response into it, or esc to abort.") + "\n\n")
+		case result.stale && !result.merged:
+			preview.WriteString(staleStyle.Render(result.path+" changed since it was exported and cannot be merged; it will not be written.") + "\n\n")
+		}
+		for _, conflict := range result.conflicts {
+			preview.WriteString(conflictStyle.Render("! "+conflict) + "\n")
+		}
+		if len(result.conflicts) > 0 {
+			preview.WriteString("\n")
+		}
+		diff, err := previewDiff(result, true)
+		switch {
+		case err != nil:
+			preview.WriteString("Error rendering diff: " + err.Error())
+		case diff == "":
+			preview.WriteString(result.path + " would not change")
+		default:
+			preview.WriteString(diff)
+		}
+	}
+	m.rightViewport.SetContent(preview.String())
+	m.rightViewport.YOffset = 0
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
+	"github.com/spf13/viper"
+)
+
+// trimStrategy controls how a bundle that exceeds the token budget is cut
+// down to size.
+type trimStrategy string
+
+const (
+	trimNone     trimStrategy = ""
+	trimDrop     trimStrategy = "drop"
+	trimTruncate trimStrategy = "truncate"
+)
+
+// modelBudgets are the default context budgets for common target models.
+// They can be overridden or extended with models.<name>.budget in config.
+var modelBudgets = map[string]int{
+	"claude":      200_000,
+	"claude-opus": 200_000,
+	"gpt-4o":      128_000,
+	"gpt-4.1":     1_000_000,
+	"gemini":      1_000_000,
+	"o3":          200_000,
+}
+
+// parseTokenCount parses counts such as 180000, 180k or 1.5M.
+func parseTokenCount(s string) (int, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier, s = 1_000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		multiplier, s = 1_000_000, strings.TrimSuffix(s, "m")
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid token count %q", s)
+	}
+	return int(n * multiplier), nil
+}
+
+// resolveBudget determines the token budget from the budget and model
+// settings. An explicit budget wins over a model default; zero means no
+// budget.
+func resolveBudget() (int, error) {
+	if value := viper.GetString("budget"); value != "" {
+		return parseTokenCount(value)
+	}
+
+	name := viper.GetString("model")
+	if name == "" {
+		return 0, nil
+	}
+	if value := viper.GetString("models." + name + ".budget"); value != "" {
+		return parseTokenCount(value)
+	}
+	if budget, ok := modelBudgets[name]; ok {
+		return budget, nil
+	}
+	return 0, fmt.Errorf("no budget known for model %q: set --budget or models.%s.budget in config", name, name)
+}
+
+// parseTrimStrategy validates a --trim value.
+func parseTrimStrategy(s string) (trimStrategy, error) {
+	switch trimStrategy(s) {
+	case trimNone, trimDrop, trimTruncate:
+		return trimStrategy(s), nil
+	default:
+		return trimNone, fmt.Errorf("unknown trim strategy %q (available: drop, truncate)", s)
+	}
+}
+
+// overBudget reports whether tokens exceeds a non-zero budget.
+func overBudget(tokens, budget int) bool {
+	return budget > 0 && tokens > budget
+}
+
+// budgetSummary describes tokens relative to budget, e.g.
+// "12.3k / 180k tokens (7%)".
+func budgetSummary(tokens, budget int) string {
+	summary := fmt.Sprintf("%s / %s tokens (%d%%)", tokenizer.Format(tokens), tokenizer.Format(budget), tokens*100/budget)
+	if overBudget(tokens, budget) {
+		summary += fmt.Sprintf(", %s over budget", tokenizer.Format(tokens-budget))
+	}
+	return summary
+}
+
+// filePriority ranks how useful a file is likely to be to a model. Lower
+// values are dropped first when trimming.
+func filePriority(relPath string) int {
+	base := path.Base(relPath)
+	switch {
+	case isLockfile(base):
+		return 0
+	case isTestFile(relPath):
+		return 1
+	case strings.HasSuffix(base, ".md") || strings.HasSuffix(base, ".txt"):
+		return 2
+	default:
+		return 3
+	}
+}
+
+// isTestFile reports whether the slash-separated relPath looks like a test
+// or test fixture.
+func isTestFile(relPath string) bool {
+	base := path.Base(relPath)
+	return strings.Contains(base, "_test.") || strings.Contains(base, ".test.") ||
+		strings.Contains(base, ".spec.") || strings.HasPrefix(base, "test_") ||
+		strings.HasPrefix(relPath, "test/") || strings.Contains(relPath, "/__tests__/") ||
+		strings.Contains(relPath, "/testdata/") || strings.HasPrefix(relPath, "testdata/")
+}
+
+func isLockfile(base string) bool {
+	switch base {
+	case "go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.lock",
+		"poetry.lock", "Gemfile.lock", "composer.lock", "flake.lock":
+		return true
+	}
+	return false
+}
+
+// trimToBudget reduces files so that their total token count fits budget.
+// trimDrop removes the lowest-priority files, largest first within a
+// priority. trimTruncate cuts the largest files down, keeping their
+// beginning. The returned notes describe what was removed. count is used to
+// recount truncated content.
+func trimToBudget(files []bundleFile, budget int, strategy trimStrategy, count func(string) int) ([]bundleFile, []string) {
+	total := 0
+	for _, file := range files {
+		total += file.Tokens
+	}
+	if !overBudget(total, budget) || strategy == trimNone {
+		return files, nil
+	}
+
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+
+	var notes []string
+	switch strategy {
+	case trimDrop:
+		sort.SliceStable(order, func(a, b int) bool {
+			pa, pb := filePriority(files[order[a]].Path), filePriority(files[order[b]].Path)
+			if pa != pb {
+				return pa < pb
+			}
+			return files[order[a]].Tokens > files[order[b]].Tokens
+		})
+		dropped := make(map[int]bool)
+		for _, i := range order {
+			if total <= budget {
+				break
+			}
+			dropped[i] = true
+			total -= files[i].Tokens
+			notes = append(notes, fmt.Sprintf("dropped %s (%s tokens)", files[i].Path, tokenizer.Format(files[i].Tokens)))
+		}
+		kept := make([]bundleFile, 0, len(files)-len(dropped))
+		for i, file := range files {
+			if !dropped[i] {
+				kept = append(kept, file)
+			}
+		}
+		return kept, notes
+
+	case trimTruncate:
+		sort.SliceStable(order, func(a, b int) bool {
+			return files[order[a]].Tokens > files[order[b]].Tokens
+		})
+		trimmed := append([]bundleFile{}, files...)
+		for _, i := range order {
+			excess := total - budget
+			if excess <= 0 {
+				break
+			}
+			file := &trimmed[i]
+			keep := max(file.Tokens-excess-truncationMarkerTokens, 0)
+			truncated := truncateToTokens(file.Content, keep, file.Tokens)
+			newTokens := count(truncated) + count(file.Diff)
+			total -= file.Tokens - newTokens
+			notes = append(notes, fmt.Sprintf("truncated %s from %s to %s tokens",
+				file.Path, tokenizer.Format(file.Tokens), tokenizer.Format(newTokens)))
+			file.Content = truncated
+			file.Size = int64(len(truncated))
+			file.Tokens = newTokens
+		}
+		return trimmed, notes
+	}
+
+	return files, nil
+}
+
+// truncationMarkerTokens is reserved for the marker truncateToTokens adds.
+const truncationMarkerTokens = 16
+
+// truncationMarker matches the line truncateToTokens puts in place of the
+// lines it cuts.
+var truncationMarker = regexp.MustCompile(`(?m)^\.\.\. \d+ lines truncated to fit the token budget \.\.\.$`)
+
+// truncateToTokens keeps roughly the first keep of total tokens of content,
+// cut at a line boundary, and marks the cut.
+func truncateToTokens(content string, keep, total int) string {
+	if total == 0 || keep >= total {
+		return content
+	}
+	cut := len(content) * keep / total
+	if i := strings.LastIndexByte(content[:cut], '\n'); i >= 0 {
+		cut = i + 1
+	} else {
+		cut = 0
+	}
+	omitted := countLines(content[cut:])
+	return content[:cut] + fmt.Sprintf("... %d lines truncated to fit the token budget ...\n", omitted)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTokenCount(t *testing.T) {
+	tests := map[string]int{
+		"180000":  180_000,
+		"180k":    180_000,
+		"1.5M":    1_500_000,
+		" 200K ":  200_000,
+		"128_000": 128_000,
+	}
+	for input, expect := range tests {
+		got, err := parseTokenCount(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expect, got, input)
+	}
+
+	_, err := parseTokenCount("lots")
+	require.Error(t, err)
+}
+
+func Test_trimToBudget(t *testing.T) {
+	count := tokenizer.Estimator{}.Count
+	file := func(path string, tokens int) bundleFile {
+		content := strings.Repeat("abc\n", tokens)
+		return bundleFile{Path: path, Content: content, Tokens: count(content)}
+	}
+	files := []bundleFile{
+		file("main.go", 100),
+		file("main_test.go", 80),
+		file("README.md", 30),
+		file("go.sum", 50),
+	}
+
+	tests := []struct {
+		name     string
+		budget   int
+		strategy trimStrategy
+		expect   func(t *testing.T, files []bundleFile, notes []string)
+	}{
+		{
+			name:     "under budget is untouched",
+			budget:   1000,
+			strategy: trimDrop,
+			expect: func(t *testing.T, trimmed []bundleFile, notes []string) {
+				t.Helper()
+				require.Equal(t, files, trimmed)
+				require.Empty(t, notes)
+			},
+		},
+		{
+			name:     "no strategy is untouched",
+			budget:   10,
+			strategy: trimNone,
+			expect: func(t *testing.T, trimmed []bundleFile, notes []string) {
+				t.Helper()
+				require.Equal(t, files, trimmed)
+			},
+		},
+		{
+			name:     "drop lowest priority first",
+			budget:   140,
+			strategy: trimDrop,
+			expect: func(t *testing.T, trimmed []bundleFile, notes []string) {
+				t.Helper()
+				require.Len(t, trimmed, 2)
+				require.Equal(t, "main.go", trimmed[0].Path)
+				require.Equal(t, "README.md", trimmed[1].Path)
+				require.Len(t, notes, 2)
+			},
+		},
+		{
+			name:     "truncate largest first",
+			budget:   200,
+			strategy: trimTruncate,
+			expect: func(t *testing.T, trimmed []bundleFile, notes []string) {
+				t.Helper()
+				require.Len(t, trimmed, 4)
+				total := 0
+				for _, f := range trimmed {
+					total += f.Tokens
+				}
+				require.LessOrEqual(t, total, 200)
+				require.Regexp(t, truncationMarker, trimmed[0].Content)
+				require.Equal(t, files[2], trimmed[2])
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trimmed, notes := trimToBudget(files, tt.budget, tt.strategy, count)
+			tt.expect(t, trimmed, notes)
+		})
+	}
+}
+
+func Test_needsBudgetConfirmationAfterTrim(t *testing.T) {
+	long := "package a\n\n" + strings.Repeat("var x = \"some long line of code\"\n", 200)
+	dir := newTestRepo(t, map[string]string{"a.go": "package a\n"})
+	writeFiles(t, dir, map[string]string{"a.go": long})
+
+	tests := []struct {
+		name   string
+		mode   contentMode
+		expect bool
+	}{
+		{name: "truncating gets under the budget", mode: contentFull, expect: false},
+		{name: "diffs are not truncated", mode: contentDiff, expect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &model{workDir: dir, contentMode: tt.mode, budget: 200, trim: trimTruncate}
+			require.NoError(t, m.buildFileTree())
+			m.toggleDirSelection(m.rootNode)
+			require.Equal(t, tt.expect, m.needsBudgetConfirmation())
+			if tt.expect {
+				require.Greater(t, m.exportTotal, m.budget)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/viper"
+)
+
+// fileClass tells files worth reading apart from those that only add noise
+// to a bundle.
+type fileClass string
+
+const (
+	classText      fileClass = ""
+	classBinary    fileClass = "binary"
+	classGenerated fileClass = "generated"
+	classMinified  fileClass = "minified"
+	classLockfile  fileClass = "lockfile"
+)
+
+// fileClasses lists the classes that can be hidden from the tree.
+var fileClasses = []fileClass{classBinary, classGenerated, classMinified, classLockfile}
+
+// parseFileClasses reads the names of classes, as given to --hide-classes.
+func parseFileClasses(names []string) (map[fileClass]bool, error) {
+	classes := make(map[fileClass]bool, len(names))
+	for _, name := range names {
+		class := fileClass(strings.TrimSpace(name))
+		switch class {
+		case classBinary, classGenerated, classMinified, classLockfile:
+			classes[class] = true
+		default:
+			return nil, fmt.Errorf("unknown file class %q (want binary, generated, minified or lockfile)", name)
+		}
+	}
+	return classes, nil
+}
+
+// badge is the short label shown next to files of the class in the tree.
+func (c fileClass) badge() string {
+	switch c {
+	case classBinary:
+		return "bin"
+	case classGenerated:
+		return "gen"
+	case classMinified:
+		return "min"
+	case classLockfile:
+		return "lock"
+	}
+	return ""
+}
+
+// plural names the files of the class in notices.
+func (c fileClass) plural() string {
+	if c == classLockfile {
+		return "lockfiles"
+	}
+	return string(c) + " files"
+}
+
+// sampleSize is how much of a file is read to classify it.
+const sampleSize = 8 << 10
+
+// minifiedLineLength is the average line length from which a script or
+// stylesheet is considered minified.
+const minifiedLineLength = 500
+
+// binaryExts are extensions of files that are binary whatever their first
+// bytes look like.
+var binaryExts = map[string]bool{
+	".exe": true, ".dll": true, ".so": true, ".dylib": true, ".bin": true,
+	".o": true, ".a": true, ".class": true, ".pyc": true, ".wasm": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true,
+	".ico": true, ".webp": true, ".tif": true, ".tiff": true, ".psd": true,
+	".pdf": true, ".zip": true, ".gz": true, ".tgz": true, ".bz2": true,
+	".xz": true, ".zst": true, ".7z": true, ".rar": true, ".tar": true,
+	".jar": true, ".war": true, ".woff": true, ".woff2": true, ".ttf": true,
+	".otf": true, ".eot": true, ".mp3": true, ".mp4": true, ".mov": true,
+	".wav": true, ".ogg": true, ".flac": true, ".avi": true, ".webm": true,
+	".sqlite": true, ".db": true,
+}
+
+// lockfiles are the names of dependency lockfiles.
+var lockfiles = map[string]bool{
+	"package-lock.json": true, "npm-shrinkwrap.json": true, "yarn.lock": true,
+	"pnpm-lock.yaml": true, "bun.lock": true, "bun.lockb": true,
+	"go.sum": true, "go.work.sum": true, "Cargo.lock": true,
+	"Gemfile.lock": true, "poetry.lock": true, "Pipfile.lock": true,
+	"uv.lock": true, "pdm.lock": true, "composer.lock": true,
+	"mix.lock": true, "Podfile.lock": true, "Package.resolved": true,
+	"flake.lock": true, "packages.lock.json": true, "pubspec.lock": true,
+	"gradle.lockfile": true, ".terraform.lock.hcl": true,
+}
+
+// minifiableExts are extensions of files that are commonly minified.
+var minifiableExts = map[string]bool{".js": true, ".mjs": true, ".cjs": true, ".css": true}
+
+// generatedMarker matches the comments generators leave at the top of their
+// output, such as Go's "// Code generated by stringer; DO NOT EDIT.".
+var generatedMarker = regexp.MustCompile(`(?m)^\W*(Code generated .*DO NOT EDIT|@generated\b)`)
+
+// detectClass classifies the file at path from its name and first bytes.
+func detectClass(path string) fileClass {
+	name := filepath.Base(path)
+	if class, ok := nameClass(name); ok {
+		return class
+	}
+	sample, err := readSample(path)
+	if err != nil {
+		// If we can't read the file, assume it's text
+		return classText
+	}
+	return sampleClass(name, sample)
+}
+
+// nameClass classifies a file by its name alone, when that is enough.
+func nameClass(name string) (fileClass, bool) {
+	if lockfiles[name] {
+		return classLockfile, true
+	}
+	if binaryExts[strings.ToLower(filepath.Ext(name))] {
+		return classBinary, true
+	}
+	return classText, false
+}
+
+// sampleClass classifies a file called name from its first bytes.
+func sampleClass(name string, sample []byte) fileClass {
+	if class, ok := nameClass(name); ok {
+		return class
+	}
+	if len(sample) == 0 {
+		return classText
+	}
+	if !isTextSample(sample) {
+		return classBinary
+	}
+	if generatedMarker.Match(sample) {
+		return classGenerated
+	}
+	if minifiableExts[strings.ToLower(filepath.Ext(name))] && isMinified(name, sample) {
+		return classMinified
+	}
+	return classText
+}
+
+func readSample(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, sampleSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// isTextSample reports whether the first bytes of a file look like text.
+// Execute bits do not matter: scripts are text.
+func isTextSample(sample []byte) bool {
+	if bytes.IndexByte(sample, 0) >= 0 && detectEncoding(sample).charset == "" {
+		return false
+	}
+	if bytes.HasPrefix(sample, []byte("#!")) {
+		return true
+	}
+	contentType := http.DetectContentType(sample)
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	if contentType != "application/octet-stream" {
+		// Images, archives, fonts and the like, known by their magic numbers
+		return false
+	}
+
+	// Control characters, such as the escapes of a terminal log, in valid
+	// UTF-8 are still text
+	if utf8.Valid(trimPartialRune(sample)) {
+		return true
+	}
+	nonPrintable := 0
+	for _, b := range sample {
+		if (b < 32 || b > 126) && !isWhitespace(b) {
+			nonPrintable++
+		}
+	}
+	// If more than 30% of content is non-printable, consider it binary
+	return float64(nonPrintable)/float64(len(sample)) <= 0.30
+}
+
+// trimPartialRune drops the end of a rune cut by the sample size.
+func trimPartialRune(sample []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(sample); i++ {
+		start := len(sample) - i
+		if utf8.RuneStart(sample[start]) {
+			if !utf8.FullRune(sample[start:]) {
+				return sample[:start]
+			}
+			break
+		}
+	}
+	return sample
+}
+
+// isMinified reports whether a script or stylesheet is minified: named so,
+// or packed on a few very long lines.
+func isMinified(name string, sample []byte) bool {
+	if strings.Contains(name, ".min.") {
+		return true
+	}
+	lines := bytes.Count(sample, []byte("\n")) + 1
+	return len(sample)/lines >= minifiedLineLength
+}
+
+func isWhitespace(b byte) bool {
+	return b == '\n' || b == '\r' || b == '\t' || b == ' '
+}
+
+// classifier gives files their class. Extensions listed in the config
+// override what the content suggests.
+type classifier struct {
+	text   map[string]bool // extensions of files that are never binary
+	binary map[string]bool // extensions of files that are always binary
+}
+
+// newClassifier returns a classifier with the given extension lists.
+// Extensions are matched without regard to case, with or without the dot.
+func newClassifier(text, binary []string) *classifier {
+	exts := func(list []string) map[string]bool {
+		set := make(map[string]bool, len(list))
+		for _, ext := range list {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext == "" {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			set[ext] = true
+		}
+		return set
+	}
+	return &classifier{text: exts(text), binary: exts(binary)}
+}
+
+// classifierFromConfig reads the text-extensions and binary-extensions
+// settings.
+func classifierFromConfig() *classifier {
+	return newClassifier(viper.GetStringSlice("text-extensions"), viper.GetStringSlice("binary-extensions"))
+}
+
+// classOf returns the class of the file at path described by meta. A nil
+// classifier keeps the detected class.
+func (c *classifier) classOf(path string, meta fileMeta) fileClass {
+	if c == nil {
+		return meta.Class
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case c.binary[ext]:
+		return classBinary
+	case c.text[ext] && meta.Class == classBinary:
+		return classText
+	}
+	return meta.Class
+}
+
+// classOf returns the class of node. Its metadata is kept on the node until
+// the tree is refreshed, so filtering the tree again costs nothing, and the
+// index saves reading files that did not change since they were last seen.
+func (m *model) classOf(node *FileNode) fileClass {
+	if node.isDir {
+		return classText
+	}
+	if node.meta == nil {
+		meta, err := m.index.lookup(node.path)
+		if err != nil {
+			// If we can't stat the file, assume it's text
+			meta = fileMeta{}
+		}
+		node.meta = &meta
+	}
+	return m.classifier.classOf(node.path, *node.meta)
+}
+
+// classFilter returns a FilterFunc rejecting the files of hidden classes.
+func (m *model) classFilter() FilterFunc {
+	return func(node *FileNode) bool {
+		return !node.isDir && m.hiddenClasses[m.classOf(node)]
+	}
+}
+
+// toggleClass shows or hides the files of class.
+func (m *model) toggleClass(class fileClass) tea.Cmd {
+	if m.hiddenClasses == nil {
+		m.hiddenClasses = make(map[fileClass]bool)
+	}
+	m.hiddenClasses[class] = !m.hiddenClasses[class]
+	if m.hiddenClasses[class] {
+		m.notice = class.plural() + " hidden"
+	} else {
+		m.notice = class.plural() + " shown"
+	}
+	m.aggregateTokens()
+	if m.findPattern.Value() != "" {
+		m.refreshMatches()
+	}
+	m.flattenTree()
+	if m.cursor >= len(m.flatNodes) {
+		m.cursor = max(len(m.flatNodes)-1, 0)
+	}
+	return m.updateTree()
+}
+
+// toggledClass returns the class whose toggle key msg is.
+func (k keyMap) toggledClass(msg tea.KeyMsg) fileClass {
+	switch {
+	case key.Matches(msg, k.ToggleGen):
+		return classGenerated
+	case key.Matches(msg, k.ToggleMin):
+		return classMinified
+	case key.Matches(msg, k.ToggleLock):
+		return classLockfile
+	}
+	return classBinary
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_detectClass(t *testing.T) {
+	dir := t.TempDir()
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	tests := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+		expect  fileClass
+	}{
+		{name: "main.go", content: "package main\n", expect: classText},
+		{name: "run", content: "#!/bin/sh\necho hi\n", mode: 0o755, expect: classText},
+		{name: "tool.py", content: "#!/usr/bin/env python3\nprint('hi')\n", mode: 0o755, expect: classText},
+		{name: "empty.txt", content: "", expect: classText},
+		{name: "build.log", content: "\x1b[32mok\x1b[0m done ✓\n", expect: classText},
+		{name: "latin1.txt", content: "caf\xe9 cr\xe8me\n", expect: classText},
+		{name: "logo", content: png, expect: classBinary},
+		{name: "blob.dat", content: "\x00\x01\x02\x03", expect: classBinary},
+		{name: "app.exe", content: "MZ", expect: classBinary},
+		{name: "zz_generated.go", content: "// Code generated by stringer; DO NOT EDIT.\n\npackage main\n", expect: classGenerated},
+		{name: "schema.py", content: "# @generated by protoc\nimport x\n", expect: classGenerated},
+		{name: "app.min.js", content: "var a=1;\n", expect: classMinified},
+		{name: "bundle.js", content: strings.Repeat("var a=1;", 200), expect: classMinified},
+		{name: "app.js", content: strings.Repeat("const a = 1;\n", 200), expect: classText},
+		{name: "long.txt", content: strings.Repeat("word ", 400), expect: classText},
+		{name: "go.sum", content: "example.com/x v1.0.0 h1:abc=\n", expect: classLockfile},
+		{name: "package-lock.json", content: "{}\n", expect: classLockfile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			mode := tt.mode
+			if mode == 0 {
+				mode = 0o644
+			}
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), mode))
+			require.Equal(t, tt.expect, detectClass(path))
+		})
+	}
+}
+
+func Test_classifier(t *testing.T) {
+	c := newClassifier([]string{"dat"}, []string{".SVG"})
+	require.Equal(t, classText, c.classOf("x/blob.dat", fileMeta{Class: classBinary}))
+	require.Equal(t, classBinary, c.classOf("x/logo.svg", fileMeta{}))
+	require.Equal(t, classGenerated, c.classOf("x/gen.dat", fileMeta{Class: classGenerated}))
+	require.Equal(t, classBinary, c.classOf("x/noext", fileMeta{Class: classBinary}))
+
+	_, err := parseFileClasses([]string{"binary", "tests"})
+	require.ErrorContains(t, err, `unknown file class "tests"`)
+}
+
+func Test_classToggles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":    "package main\n",
+		"gen.go":     "// Code generated by hand; DO NOT EDIT.\n\npackage main\n",
+		"go.sum":     "example.com/x v1.0.0 h1:abc=\n",
+		"script":     "#!/bin/sh\n",
+		"image.png":  "\x89PNG",
+		"dist/a.css": strings.Repeat("a{b:c}", 200),
+	})
+	require.NoError(t, os.Chmod(filepath.Join(dir, "script"), 0o755))
+
+	m := &model{workDir: dir, findPattern: initFindInput(), hiddenClasses: map[fileClass]bool{classBinary: true}}
+	require.NoError(t, m.buildFileTree())
+	m.rootNode.children[0].expanded = true // dist
+	shown := func() []string {
+		m.flattenTree()
+		var names []string
+		for _, node := range m.flatNodes[1:] {
+			names = append(names, node.name)
+		}
+		return names
+	}
+	require.Equal(t, []string{"dist", "a.css", "gen.go", "go.sum", "main.go", "script"}, shown())
+	require.Contains(t, m.getNodeDisplay(m.nodeLookup[filepath.Join(dir, "gen.go")]), "[gen]")
+
+	m.toggleClass(classGenerated)
+	m.toggleClass(classMinified)
+	m.toggleClass(classLockfile)
+	require.Equal(t, "lockfiles hidden", m.notice)
+	require.Equal(t, []string{"dist", "main.go", "script"}, shown())
+
+	m.toggleClass(classBinary)
+	require.Equal(t, []string{"dist", "image.png", "main.go", "script"}, shown())
+
+	// Binary files are listed but not selected with their directory
+	m.toggleDirSelection(m.rootNode)
+	require.False(t, m.nodeLookup[filepath.Join(dir, "image.png")].selected)
+	require.True(t, m.nodeLookup[filepath.Join(dir, "script")].selected)
+}
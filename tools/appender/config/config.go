@@ -1,18 +1,173 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
+// RepoFileName is the repository-local config file, found by walking up
+// from the working directory.
+const RepoFileName = ".appender.yaml"
+
+// Settings that have no flag, with their defaults.
+var defaults = map[string]any{
+	"theme":             "auto",
+	"ignore":            []string{},
+	"profiles.location": "repo",
+}
+
+var envReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// Config files read by LoadFiles, and the keys each of them sets.
+var (
+	userFile, repoFile string
+	userKeys, repoKeys map[string]bool
+)
+
 func InitConfig() error {
 	viper.SetEnvPrefix("APPENDER")
+	viper.SetEnvKeyReplacer(envReplacer)
 	viper.AutomaticEnv()
 	viper.RegisterAlias("l", "logging")
+	for key, value := range defaults {
+		viper.SetDefault(key, value)
+	}
+	return nil
+}
+
+// UserFile returns the path of the user config file,
+// $XDG_CONFIG_HOME/appender/config.yaml on Linux.
+func UserFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "appender", "config.yaml"), nil
+}
+
+// FindRepoFile returns the nearest .appender.yaml at or above workDir, or
+// "" when there is none.
+func FindRepoFile(workDir string) string {
+	dir, err := filepath.Abs(workDir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, RepoFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadFiles reads the user config file and then the repository config file
+// for workDir, whose settings win. Either may be missing. Env variables and
+// flags still take precedence over both.
+func LoadFiles(workDir string) error {
+	userFile, repoFile = "", ""
+	userKeys, repoKeys = nil, nil
+
+	if path, err := UserFile(); err == nil {
+		keys, err := mergeFile(path)
+		if err != nil {
+			return err
+		}
+		if keys != nil {
+			userFile, userKeys = path, keys
+		}
+	}
+	if path := FindRepoFile(workDir); path != "" {
+		keys, err := mergeFile(path)
+		if err != nil {
+			return err
+		}
+		repoFile, repoKeys = path, keys
+	}
 	return nil
 }
 
+// mergeFile merges the config file at path into viper and returns the keys
+// it sets, nil if the file does not exist.
+func mergeFile(path string) (map[string]bool, error) {
+	file := viper.New()
+	file.SetConfigFile(path)
+	if err := file.ReadInConfig(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if err := viper.MergeConfigMap(file.AllSettings()); err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	keys := make(map[string]bool)
+	for _, key := range file.AllKeys() {
+		keys[key] = true
+	}
+	slog.Debug("loaded config file", "path", path, "keys", len(keys))
+	return keys, nil
+}
+
+// Files returns the config files read by LoadFiles, "" for those that do
+// not exist.
+func Files() (user, repo string) {
+	return userFile, repoFile
+}
+
+// FileKeys returns the keys set by the config files, sorted.
+func FileKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, set := range []map[string]bool{userKeys, repoKeys} {
+		for key := range set {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvName returns the environment variable that sets key.
+func EnvName(key string) string {
+	return "APPENDER_" + strings.ToUpper(envReplacer.Replace(key))
+}
+
+// Source describes where the effective value of key comes from, in order of
+// precedence: a flag given on the command line, an env variable, the
+// repository config file, the user config file or the default.
+func Source(flags *pflag.FlagSet, key string) string {
+	if flag := flags.Lookup(key); flag != nil && flag.Changed {
+		return "flag --" + key
+	}
+	if _, ok := os.LookupEnv(EnvName(key)); ok {
+		return "env " + EnvName(key)
+	}
+	if repoKeys[key] {
+		return repoFile
+	}
+	if userKeys[key] {
+		return userFile
+	}
+	return "default"
+}
+
 func GetLogLevel() (slog.Level, bool) {
 	if !viper.IsSet("logging") {
 		return slog.LevelInfo, false
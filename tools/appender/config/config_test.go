@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadFiles(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	repo := t.TempDir()
+	workDir := filepath.Join(repo, "a", "b")
+	require.NoError(t, os.MkdirAll(workDir, 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(configHome, "appender"), 0o755))
+
+	userPath := filepath.Join(configHome, "appender", "config.yaml")
+	require.NoError(t, os.WriteFile(userPath, []byte("format: xml\nbudget: 100k\ntheme: dark\nmodels:\n  mine:\n    budget: 10k\n"), 0o600))
+	repoPath := filepath.Join(repo, RepoFileName)
+	require.NoError(t, os.WriteFile(repoPath, []byte("format: markdown\ntrim: drop\nmodels:\n  other:\n    budget: 20k\n"), 0o600))
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	require.NoError(t, InitConfig())
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("format", "plain", "")
+	flags.String("budget", "", "")
+	flags.String("trim", "", "")
+	flags.String("tokenizer", "cl100k", "")
+	require.NoError(t, viper.BindPFlags(flags))
+	require.NoError(t, flags.Parse([]string{"--budget", "50k"}))
+	t.Setenv("APPENDER_TRIM", "truncate")
+
+	require.Equal(t, repoPath, FindRepoFile(workDir))
+	require.NoError(t, LoadFiles(workDir))
+	user, repoFile := Files()
+	require.Equal(t, userPath, user)
+	require.Equal(t, repoPath, repoFile)
+
+	tests := []struct {
+		key, value, source string
+	}{
+		{key: "format", value: "markdown", source: repoPath},
+		{key: "budget", value: "50k", source: "flag --budget"},
+		{key: "trim", value: "truncate", source: "env APPENDER_TRIM"},
+		{key: "theme", value: "dark", source: userPath},
+		{key: "tokenizer", value: "cl100k", source: "default"},
+		{key: "profiles.location", value: "repo", source: "default"},
+		// Tables from both files are merged
+		{key: "models.mine.budget", value: "10k", source: userPath},
+		{key: "models.other.budget", value: "20k", source: repoPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			require.Equal(t, tt.value, viper.GetString(tt.key))
+			require.Equal(t, tt.source, Source(flags, tt.key))
+		})
+	}
+
+	t.Run("no config files", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		require.NoError(t, LoadFiles(t.TempDir()))
+		user, repo := Files()
+		require.Empty(t, user)
+		require.Empty(t, repo)
+		require.Empty(t, FileKeys())
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(repoPath, []byte("format: [\n"), 0o600))
+		require.ErrorContains(t, LoadFiles(workDir), repoPath)
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jongschneider/ai-toolbox/tools/appender/config"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configKeys are the settings that make sense in config files, in the order
+// `appender config show` prints them. The other flags select files for one
+// run and are better given on the command line.
+var configKeys = []string{
+	"format",
+	"template",
+	"output",
+	"include",
+	"exclude",
+	"hidden",
+	"no-ignore",
+	"ignore",
+	"follow-symlinks",
+	"symlinks",
+	"hide-classes",
+	"normalize-eol",
+	"outline",
+	"path-only",
+	"outline-all",
+	"text-extensions",
+	"binary-extensions",
+	"tokenizer",
+	"budget",
+	"model",
+	"trim",
+	"content",
+	"diff-ref",
+	"manifest",
+	"theme",
+	"no-watch",
+	"no-index",
+	"profiles.location",
+	"logging",
+}
+
+// configSections are config keys holding a table of settings, such as
+// models.<name>.budget and keys.<action>.
+var configSections = []string{"models.", "keys."}
+
+// unknownConfigKeys returns the keys set in config files that appender does
+// not read, which are most likely typos.
+func unknownConfigKeys() []string {
+	known := make(map[string]bool, len(configKeys))
+	for _, key := range configKeys {
+		known[key] = true
+	}
+	var unknown []string
+	for _, key := range config.FileKeys() {
+		if !known[key] && !hasSectionPrefix(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown
+}
+
+func hasSectionPrefix(key string) bool {
+	for _, prefix := range configSections {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// runConfigShow prints the effective value of every setting and where it
+// comes from.
+func runConfigShow(flags *pflag.FlagSet, w io.Writer) error {
+	user, repo := config.Files()
+	if user == "" {
+		user = "(none)"
+		if path, err := config.UserFile(); err == nil {
+			user = path + " (not found)"
+		}
+	}
+	if repo == "" {
+		repo = "(none found)"
+	}
+	fmt.Fprintf(w, "user config: %s\n", user)
+	fmt.Fprintf(w, "repo config: %s\n\n", repo)
+
+	keys := append([]string{}, configKeys...)
+	for _, key := range config.FileKeys() {
+		if hasSectionPrefix(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys[len(configKeys):])
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, configValue(viper.Get(key)), config.Source(flags, key))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if unknown := unknownConfigKeys(); len(unknown) > 0 {
+		fmt.Fprintf(w, "\nunknown keys, ignored: %s\n", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// configValue formats a setting for display.
+func configValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return `""`
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	case []any:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = fmt.Sprint(part)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
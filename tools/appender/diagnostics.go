@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// readError describes err without the path it is about, which the tree
+// already shows.
+func readError(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// unreadableNodes returns the entries shown in the tree that could not be read,
+// in tree order.
+func (m *model) unreadableNodes() []*FileNode {
+	var nodes []*FileNode
+	filters := m.filters()
+	var walk func(node *FileNode)
+	walk = func(node *FileNode) {
+		if !include(node, filters...) {
+			return
+		}
+		if node.err != nil {
+			nodes = append(nodes, node)
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	if m.rootNode != nil {
+		walk(m.rootNode)
+	}
+	return nodes
+}
+
+// diagnosticsScreen lists the entries that could not be read.
+type diagnosticsScreen struct {
+	nodes  []*FileNode
+	cursor int
+}
+
+// diagnosticsKeyMap lists the keys of the diagnostics screen for the help
+// view.
+type diagnosticsKeyMap struct {
+	Up, Down, Show, Close key.Binding
+}
+
+func (k diagnosticsKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Show, k.Close}
+}
+
+func (k diagnosticsKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Up, k.Down}, {k.Show, k.Close}}
+}
+
+var diagnosticsKeys = diagnosticsKeyMap{
+	Up:    key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "move up")),
+	Down:  key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "move down")),
+	Show:  key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "show in tree")),
+	Close: key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "close")),
+}
+
+// openDiagnostics shows the diagnostics screen.
+func (m *model) openDiagnostics() tea.Cmd {
+	m.diagnostics = &diagnosticsScreen{nodes: m.unreadableNodes()}
+	return m.updateDiagnosticsPanes()
+}
+
+// updateDiagnostics handles keys while the diagnostics screen is open.
+func (m *model) updateDiagnostics(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	screen := m.diagnostics
+	switch {
+	case key.Matches(msg, diagnosticsKeys.Close):
+		m.diagnostics = nil
+		return m, tea.Batch(m.updateTree(), m.updateContent())
+	case key.Matches(msg, diagnosticsKeys.Up):
+		if screen.cursor > 0 {
+			screen.cursor--
+		}
+	case key.Matches(msg, diagnosticsKeys.Down):
+		if screen.cursor < len(screen.nodes)-1 {
+			screen.cursor++
+		}
+	case key.Matches(msg, diagnosticsKeys.Show):
+		if len(screen.nodes) == 0 {
+			break
+		}
+		node := screen.nodes[screen.cursor]
+		m.diagnostics = nil
+		m.ensureNodeVisible(node)
+		m.flattenTree()
+		for i, n := range m.flatNodes {
+			if n == node {
+				m.cursor = i
+				m.ensureNodeInViewport()
+			}
+		}
+		return m, tea.Batch(m.updateTree(), m.updateContent())
+	}
+	return m, m.updateDiagnosticsPanes()
+}
+
+// updateDiagnosticsPanes renders the unreadable entries on the left and the
+// error of the one under the cursor on the right.
+func (m *model) updateDiagnosticsPanes() tea.Cmd {
+	screen := m.diagnostics
+	errStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+
+	var list strings.Builder
+	list.WriteString(lipgloss.NewStyle().Bold(true).Render("Unreadable entries") + "\n\n")
+	if len(screen.nodes) == 0 {
+		list.WriteString("Every entry in the tree could be read.\n")
+	}
+	for i, node := range screen.nodes {
+		line := "  " + m.diagnosticPath(node)
+		if i == screen.cursor {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Render("> ") + m.diagnosticPath(node)
+		}
+		list.WriteString(line + "\n")
+	}
+	m.leftViewport.SetContent(list.String())
+
+	var detail strings.Builder
+	if len(screen.nodes) > 0 {
+		node := screen.nodes[screen.cursor]
+		fmt.Fprintf(&detail, "%s\n\n", lipgloss.NewStyle().Bold(true).Render(m.diagnosticPath(node)))
+		if node.isDir {
+			detail.WriteString("The directory could not be listed, so its contents are missing from the tree.\n\n")
+		} else {
+			detail.WriteString("The file cannot be selected or exported.\n\n")
+		}
+		detail.WriteString(errStyle.Render(node.err.Error()) + "\n")
+	}
+	m.rightViewport.SetContent(detail.String())
+	m.rightViewport.YOffset = 0
+	return nil
+}
+
+// diagnosticPath names node in the diagnostics screen.
+func (m *model) diagnosticPath(node *FileNode) string {
+	if rel, ok := m.relToWorkDir(node.path); ok {
+		return rel
+	}
+	return node.path
+}
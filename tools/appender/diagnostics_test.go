@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_unreadableNodes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/one.go":     "package a\n",
+		"b/two.go":     "package b\n",
+		".hidden/x.go": "package x\n",
+	})
+	require.NoError(t, os.Symlink("missing.go", filepath.Join(dir, "a", "link.go")))
+	require.NoError(t, os.Symlink("nowhere", filepath.Join(dir, ".hidden", "link.go")))
+
+	m := &model{workDir: dir, removeHidden: true}
+	require.NoError(t, m.buildFileTree())
+	node := func(rel string) *FileNode {
+		return m.nodeLookup[filepath.Join(dir, filepath.FromSlash(rel))]
+	}
+
+	// Entries hidden by the filters are not reported
+	require.Equal(t, []*FileNode{node("a/link.go")}, m.unreadableNodes())
+
+	m.toggleDirSelection(m.rootNode)
+	require.True(t, node("a/one.go").selected)
+	require.True(t, node("b/two.go").selected)
+	require.False(t, node("a/link.go").selected)
+
+	n := m.selectPaths([]string{node("a/link.go").path, node("b/two.go").path})
+	require.Equal(t, 1, n)
+
+	var buf bytes.Buffer
+	require.NoError(t, runPack(packOptions{workDir: dir, noHidden: true}, &buf))
+	require.Contains(t, buf.String(), "# a/one.go\n")
+	require.NotContains(t, buf.String(), "link.go")
+}
+
+func Test_filesCheckedWhenSelected(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n", "gone.go": "package a\n"})
+	locked := filepath.Join(dir, "locked.go")
+	require.NoError(t, os.WriteFile(locked, []byte("package a\n"), 0o000))
+
+	m := &model{workDir: dir}
+	require.NoError(t, m.buildFileTree())
+	node := func(rel string) *FileNode {
+		return m.nodeLookup[filepath.Join(dir, rel)]
+	}
+
+	// Listing the directory does not open its files
+	require.Empty(t, m.unreadableNodes())
+
+	// Selecting them does
+	require.NoError(t, os.Remove(filepath.Join(dir, "gone.go")))
+	m.toggleDirSelection(m.rootNode)
+	require.True(t, node("a.go").selected)
+	require.False(t, node("gone.go").selected)
+	require.ErrorIs(t, node("gone.go").err, os.ErrNotExist)
+
+	if os.Geteuid() != 0 {
+		require.False(t, node("locked.go").selected)
+		require.ErrorIs(t, node("locked.go").err, os.ErrPermission)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// contentMode controls what is exported for each selected file.
+type contentMode string
+
+const (
+	contentFull contentMode = "full" // the file as it is on disk
+	contentDiff contentMode = "diff" // the unified diff against a ref
+	contentBoth contentMode = "both" // the diff followed by the full new version
+)
+
+// contentModes is the order content modes are cycled through in the UI.
+var contentModes = []contentMode{contentFull, contentDiff, contentBoth}
+
+// Change statuses recorded on bundle files exported with diffs.
+const (
+	statusModified  = "modified"
+	statusAdded     = "added"
+	statusDeleted   = "deleted"
+	statusRenamed   = "renamed"
+	statusUnchanged = "unchanged"
+)
+
+// parseContentMode validates a --content value.
+func parseContentMode(s string) (contentMode, error) {
+	for _, mode := range contentModes {
+		if string(mode) == s {
+			return mode, nil
+		}
+	}
+	if s == "" {
+		return contentFull, nil
+	}
+	return "", fmt.Errorf("unknown content mode %q (available: full, diff, both)", s)
+}
+
+// nextContentMode returns the mode after current, wrapping around.
+func nextContentMode(current contentMode) contentMode {
+	for i, mode := range contentModes {
+		if mode == current {
+			return contentModes[(i+1)%len(contentModes)]
+		}
+	}
+	return contentFull
+}
+
+// fileChange is a file that differs between a ref and the working tree.
+type fileChange struct {
+	status    string
+	path      string // relative to the repository root
+	oldPath   string // previous path of a renamed file
+	untracked bool   // the file is new and not yet known to git
+}
+
+// changesAgainst lists the files that differ between ref and the working
+// tree, with renames detected, plus untracked files as additions. The map
+// is keyed by the current path, or the old path for deletions.
+func (r *gitRepo) changesAgainst(ref string) (map[string]fileChange, error) {
+	out, err := runGit(r.root, "diff", "--name-status", "-M", "-z", ref)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]fileChange)
+	fields := splitNul(out)
+	for i := 0; i < len(fields); i++ {
+		code := fields[i]
+		if code == "" || i+1 >= len(fields) {
+			break
+		}
+		switch code[0] {
+		case 'R', 'C':
+			if i+2 >= len(fields) {
+				return changes, nil
+			}
+			change := fileChange{status: statusRenamed, oldPath: fields[i+1], path: fields[i+2]}
+			if code[0] == 'C' {
+				change = fileChange{status: statusAdded, path: fields[i+2]}
+			}
+			changes[change.path] = change
+			i += 2
+		case 'A':
+			changes[fields[i+1]] = fileChange{status: statusAdded, path: fields[i+1]}
+			i++
+		case 'D':
+			changes[fields[i+1]] = fileChange{status: statusDeleted, path: fields[i+1]}
+			i++
+		default:
+			changes[fields[i+1]] = fileChange{status: statusModified, path: fields[i+1]}
+			i++
+		}
+	}
+
+	untracked, err := r.changedFiles(gitUntracked)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range untracked {
+		changes[path] = fileChange{status: statusAdded, path: path, untracked: true}
+	}
+	return changes, nil
+}
+
+// diff returns the unified diff of a change against ref.
+func (r *gitRepo) diff(ref string, change fileChange) (string, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch {
+	case change.untracked:
+		out, err = runGitAllowExit(r.root, 1, "diff", "--no-color", "--no-index", "--", os.DevNull, change.path)
+	case change.status == statusRenamed:
+		out, err = runGit(r.root, "diff", "--no-color", "-M", ref, "--", change.oldPath, change.path)
+	default:
+		out, err = runGit(r.root, "diff", "--no-color", ref, "--", change.path)
+	}
+	return string(out), err
+}
+
+// resolveDiffRef picks the ref diffs are taken against: the explicit
+// --diff-ref, else the merge base with --since, else HEAD.
+func (r *gitRepo) resolveDiffRef(diffRef, since string) (string, error) {
+	if diffRef != "" {
+		return diffRef, nil
+	}
+	if since == "" {
+		return "HEAD", nil
+	}
+	out, err := runGit(r.root, "merge-base", since, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitContent is what a bundle needs from git beyond the files on disk: the
+// diffs of a diff export, or the review of a review pack. Running git takes
+// a while, so the preview loads it in the background.
+type gitContent struct {
+	repo    *gitRepo
+	ref     string                // diff exports: the ref diffs are taken against
+	changes map[string]fileChange // diff exports: as returned by changesAgainst
+	diffs   map[string]string     // diff exports: the diffs needed, keyed like changes
+	review  *review               // review packs
+	atHead  map[string]string     // review packs: contents at head needed, by repository path
+}
+
+// gitRequest describes the git content a bundle needs. It copies what it
+// needs from the model, so that loading it never touches the model.
+type gitRequest struct {
+	workDir  string
+	diffRef  string
+	sinceRef string
+	review   *reviewRange
+	paths    []string // the selected files that carry content
+}
+
+// gitRequest returns the request for the git content of files, or nil when
+// the content mode and review settings need none.
+func (m *model) gitRequest(files []bundleFile) *gitRequest {
+	if m.review == nil && (m.contentMode == "" || m.contentMode == contentFull) {
+		return nil
+	}
+	req := &gitRequest{workDir: m.workDir, diffRef: m.diffRef, sinceRef: m.sinceRef, review: m.review}
+	for _, file := range files {
+		// Files exported as a path carry no diff
+		if m.review == nil && file.Inclusion == string(inclusionPath) {
+			continue
+		}
+		req.paths = append(req.paths, file.AbsPath)
+	}
+	return req
+}
+
+// load runs git for req.
+func (req *gitRequest) load() (*gitContent, error) {
+	repo, err := openGitRepo(req.workDir)
+	if err != nil {
+		return nil, err
+	}
+	content := &gitContent{repo: repo}
+
+	if req.review != nil {
+		rev, err := repo.loadReview(*req.review)
+		if err != nil {
+			return nil, err
+		}
+		content.review = rev
+		content.atHead = make(map[string]string)
+		read := func(repoPath string) error {
+			if _, ok := content.atHead[repoPath]; ok || !rev.atHead[repoPath] {
+				return nil
+			}
+			data, err := rev.contentAtHead(repoPath)
+			if err != nil {
+				return fmt.Errorf("reading %s at %s: %w", repoPath, rev.rng.head, err)
+			}
+			content.atHead[repoPath] = data
+			return nil
+		}
+		for _, path := range req.paths {
+			if err := read(repo.repoPath(path)); err != nil {
+				return nil, err
+			}
+		}
+		// Files of the review missing from the working tree
+		for _, repoPath := range append(append([]string{}, rev.touched...), rev.tests...) {
+			path, ok := repo.toNodePath(req.workDir, repoPath)
+			if !ok {
+				continue
+			}
+			if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+				if err := read(repoPath); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return content, nil
+	}
+
+	if content.ref, err = repo.resolveDiffRef(req.diffRef, req.sinceRef); err != nil {
+		return nil, err
+	}
+	if content.changes, err = repo.changesAgainst(content.ref); err != nil {
+		return nil, err
+	}
+	content.diffs = make(map[string]string)
+	diff := func(key string) error {
+		change, ok := content.changes[key]
+		if _, done := content.diffs[key]; !ok || done {
+			return nil
+		}
+		out, err := repo.diff(content.ref, change)
+		if err != nil {
+			return fmt.Errorf("diffing %s: %w", change.path, err)
+		}
+		content.diffs[key] = out
+		return nil
+	}
+	for _, path := range req.paths {
+		if err := diff(repo.repoPath(path)); err != nil {
+			return nil, err
+		}
+	}
+	// Which deleted files belong to the selection is up to the model
+	for key, change := range content.changes {
+		if _, ok := repo.toNodePath(req.workDir, change.path); ok && change.status == statusDeleted {
+			if err := diff(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return content, nil
+}
+
+// attachDiffs adds the diffs of git to files according to m.contentMode,
+// and appends the deleted files that belong to the selection. Files that did
+// not change are kept with status "unchanged" so that the bundle still
+// lists them.
+func (m *model) attachDiffs(files []bundleFile, git *gitContent) []bundleFile {
+	if git == nil || m.contentMode == "" || m.contentMode == contentFull {
+		return files
+	}
+
+	result := make([]bundleFile, 0, len(files))
+	for _, file := range files {
+		// Files exported as a path carry no diff either
+		if file.Inclusion == string(inclusionPath) {
+			result = append(result, file)
+			continue
+		}
+		key := git.repo.repoPath(file.AbsPath)
+		change, ok := git.changes[key]
+		if !ok {
+			file.Status = statusUnchanged
+			if m.contentMode == contentDiff {
+				file.Content = ""
+			}
+			result = append(result, m.recount(file))
+			continue
+		}
+
+		file.Diff = git.diffs[key]
+		file.Status = change.status
+		if change.oldPath != "" {
+			if path, ok := git.repo.toNodePath(m.workDir, change.oldPath); ok {
+				rel, _ := filepath.Rel(m.workDir, path)
+				file.OldPath = filepath.ToSlash(rel)
+			}
+		}
+		if m.contentMode == contentDiff {
+			file.Content = ""
+		}
+		result = append(result, m.recount(file))
+	}
+
+	// Deleted files follow the others in path order
+	var deleted []string
+	for key, change := range git.changes {
+		if change.status == statusDeleted {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		change := git.changes[key]
+		path, ok := git.repo.toNodePath(m.workDir, change.path)
+		if !ok || !m.deletionSelected(path) {
+			continue
+		}
+		rel, _ := filepath.Rel(m.workDir, path)
+		result = append(result, m.recount(bundleFile{
+			AbsPath:  path,
+			Path:     filepath.ToSlash(rel),
+			Language: languageFor(path),
+			Status:   statusDeleted,
+			Diff:     git.diffs[key],
+		}))
+	}
+
+	return result
+}
+
+// deletionSelected reports whether a deleted file at path is part of the
+// selection: its nearest directory still in the tree is selected, or it
+// matches the include globs of a headless export.
+func (m *model) deletionSelected(path string) bool {
+	if len(m.includeGlobs) > 0 {
+		rel, err := filepath.Rel(m.workDir, path)
+		if err == nil && matchesAny(m.includeGlobs, filepath.ToSlash(rel)) && !matchesAny(m.excludeGlobs, filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if node, ok := m.nodeLookup[dir]; ok {
+			return node.selected
+		}
+		if dir == m.workDir || dir == filepath.Dir(dir) || dir == "." {
+			return m.rootNode != nil && m.rootNode.selected
+		}
+	}
+}
+
+// recount updates the size and token count of file after its content or
+// diff changed.
+func (m *model) recount(file bundleFile) bundleFile {
+	file.Size = int64(len(file.Content))
+	file.Tokens = m.countTokens(file.Content) + m.countTokens(file.Diff)
+	return file
+}
+
+// changeLabel describes the status of a file exported with diffs, e.g.
+// "modified" or "renamed from old/path.go", or a symlink exported as a
+// link. It is empty for full exports.
+func (f bundleFile) changeLabel() string {
+	if f.Link != "" {
+		return "symlink to " + f.Link
+	}
+	if f.Status == statusRenamed && f.OldPath != "" {
+		return "renamed from " + f.OldPath
+	}
+	return f.Status
+}
+
+// headerLabel is the changeLabel followed by the inclusion of a file not
+// exported in full, the line ranges of a partial export and the original
+// encoding of the file, if it was not UTF-8.
+func (f bundleFile) headerLabel() string {
+	var parts []string
+	for _, part := range []string{f.changeLabel(), inclusion(f.Inclusion).label(), f.Lines, f.Encoding} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
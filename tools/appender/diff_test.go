@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_attachDiffs(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"lib/a.go":    "package lib\n",
+		"lib/same.go": "package lib\n\nvar Same = 1\n",
+		"lib/old.go":  "package lib\n\nfunc Old() {}\n",
+		"lib/gone.go": "package lib\n\nfunc Gone() {}\n",
+		"other/x.go":  "package other\n",
+	})
+	writeFiles(t, dir, map[string]string{
+		"lib/a.go":   "package lib\n\nfunc A() {}\n",
+		"lib/new.go": "package lib\n\nfunc New() {}\n",
+	})
+	gitCmd(t, dir, "mv", "lib/old.go", "lib/renamed.go")
+	gitCmd(t, dir, "rm", "-q", "lib/gone.go", "other/x.go")
+
+	tests := []struct {
+		name     string
+		mode     contentMode
+		include  []string
+		expect   map[string]string // status by path
+		contents bool
+	}{
+		{
+			name:     "full",
+			mode:     contentFull,
+			include:  []string{"lib/**"},
+			expect:   map[string]string{"lib/a.go": "", "lib/new.go": "", "lib/renamed.go": "", "lib/same.go": ""},
+			contents: true,
+		},
+		{
+			name:    "diff",
+			mode:    contentDiff,
+			include: []string{"lib/**"},
+			expect: map[string]string{
+				"lib/a.go":       statusModified,
+				"lib/new.go":     statusAdded,
+				"lib/renamed.go": statusRenamed,
+				"lib/same.go":    statusUnchanged,
+				"lib/gone.go":    statusDeleted,
+			},
+		},
+		{
+			name:    "both",
+			mode:    contentBoth,
+			include: []string{"lib/a.go"},
+			expect: map[string]string{
+				"lib/a.go": statusModified,
+			},
+			contents: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &model{workDir: dir, contentMode: tt.mode}
+			require.NoError(t, m.buildFileTree())
+			m.includeGlobs = tt.include
+			m.selectByGlobs(m.rootNode, tt.include, nil, m.filters())
+
+			files, err := m.bundleFiles()
+			require.NoError(t, err)
+
+			got := make(map[string]string, len(files))
+			for _, file := range files {
+				got[file.Path] = file.Status
+				require.Equal(t, tt.contents, file.Content != "", file.Path)
+				if file.Status != "" && file.Status != statusUnchanged {
+					require.Contains(t, file.Diff, "diff --git", file.Path)
+				}
+			}
+			require.Equal(t, tt.expect, got)
+		})
+	}
+
+	t.Run("renamed file records its old path", func(t *testing.T) {
+		m := &model{workDir: dir, contentMode: contentDiff}
+		require.NoError(t, m.buildFileTree())
+		m.selectPaths([]string{filepath.Join(dir, "lib", "renamed.go")})
+
+		files, err := m.bundleFiles()
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		require.Equal(t, "lib/old.go", files[0].OldPath)
+
+		var buf bytes.Buffer
+		require.NoError(t, writePlain(&buf, files))
+		require.Contains(t, buf.String(), "# lib/renamed.go (renamed from lib/old.go)\n")
+	})
+
+	t.Run("deleted files follow the selected directory", func(t *testing.T) {
+		m := &model{workDir: dir, contentMode: contentDiff}
+		require.NoError(t, m.buildFileTree())
+		m.setDirSelection(m.nodeLookup[filepath.Join(dir, "lib")], true, m.filters())
+
+		files, err := m.bundleFiles()
+		require.NoError(t, err)
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		sort.Strings(paths)
+		require.Contains(t, paths, "lib/gone.go")
+		require.NotContains(t, paths, "other/x.go")
+	})
+
+	t.Run("deleted files come last in path order", func(t *testing.T) {
+		m := &model{workDir: dir, contentMode: contentDiff}
+		require.NoError(t, m.buildFileTree())
+		m.toggleDirSelection(m.rootNode)
+
+		files, err := m.bundleFiles()
+		require.NoError(t, err)
+		var deleted []string
+		for _, file := range files[len(files)-2:] {
+			require.Equal(t, statusDeleted, file.Status)
+			deleted = append(deleted, file.Path)
+		}
+		require.Equal(t, []string{"lib/gone.go", "other/x.go"}, deleted)
+	})
+}
+
+func Test_previewLoadsDiffsInBackground(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"a.go": "package a\n"})
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n\nfunc Changed() {}\n"})
+
+	m := newRangeTestModel(t, dir)
+	m.windowSize = windowSize{height: 40, width: 120}
+	m.contentMode = contentDiff
+	m.toggleDirSelection(m.rootNode)
+
+	// git runs in the command, not in updateContent
+	cmd := m.updateContent()
+	require.NotNil(t, cmd)
+	require.NotContains(t, m.rightViewport.View(), "Changed")
+	msg := cmd()
+	m.Update(msg)
+	require.Contains(t, m.rightViewport.View(), "+func Changed() {}")
+
+	// A preview arriving after a newer one was started is dropped
+	m.contentMode = contentFull
+	require.Nil(t, m.updateContent())
+	m.Update(msg)
+	require.NotContains(t, m.rightViewport.View(), "+func Changed() {}")
+}
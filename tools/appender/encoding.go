@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// textEncoding describes how a text file is stored on disk, so that it can
+// be exported as UTF-8 and written back the way it was found.
+type textEncoding struct {
+	charset string // "" for UTF-8, otherwise one of charsets
+	bom     bool   // the file starts with a byte order mark
+	crlf    bool   // lines end with \r\n
+}
+
+// charsets are the encodings other than UTF-8 that appender recognizes.
+var charsets = map[string]encoding.Encoding{
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"iso-8859-1":   charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+}
+
+// byteOrderMarks are the byte order marks by charset.
+var byteOrderMarks = map[string][]byte{
+	"":         {0xEF, 0xBB, 0xBF},
+	"utf-16le": {0xFF, 0xFE},
+	"utf-16be": {0xFE, 0xFF},
+}
+
+// detectEncoding tells how data is encoded from its byte order mark or,
+// without one, from its content: UTF-16 by the NUL bytes of mostly ASCII
+// text, UTF-8 if it is valid, and Latin-1 otherwise. Windows-1252 is picked
+// over Latin-1 when the control characters it replaces show up.
+func detectEncoding(data []byte) textEncoding {
+	for _, charset := range []string{"", "utf-16le", "utf-16be"} {
+		if bytes.HasPrefix(data, byteOrderMarks[charset]) {
+			return textEncoding{charset: charset, bom: true}
+		}
+	}
+	if order := utf16Order(data); order != "" {
+		return textEncoding{charset: order}
+	}
+	if utf8.Valid(trimPartialRune(data)) {
+		return textEncoding{}
+	}
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			return textEncoding{charset: "windows-1252"}
+		}
+	}
+	return textEncoding{charset: "iso-8859-1"}
+}
+
+// utf16Order recognizes UTF-16 without a byte order mark: in text that is
+// mostly ASCII, every other byte is NUL.
+func utf16Order(data []byte) string {
+	n := min(len(data), sampleSize) &^ 1
+	if n < 4 {
+		return ""
+	}
+	pairs, even, odd := n/2, 0, 0
+	for i := 0; i < n; i += 2 {
+		if data[i] == 0 {
+			even++
+		}
+		if data[i+1] == 0 {
+			odd++
+		}
+	}
+	switch {
+	case odd*2 > pairs && even*10 < pairs:
+		return "utf-16le"
+	case even*2 > pairs && odd*10 < pairs:
+		return "utf-16be"
+	}
+	return ""
+}
+
+// decodeText returns data as UTF-8, without byte order mark, along with how
+// it was encoded. Line endings are kept.
+func decodeText(data []byte) (string, textEncoding) {
+	enc := detectEncoding(data)
+	if enc.bom {
+		data = data[len(byteOrderMarks[enc.charset]):]
+	}
+	text := string(data)
+	if charset, ok := charsets[enc.charset]; ok {
+		if decoded, err := charset.NewDecoder().Bytes(data); err == nil {
+			text = string(decoded)
+		}
+	}
+	crlf := strings.Count(text, "\r\n")
+	enc.crlf = crlf > 0 && crlf*2 > strings.Count(text, "\n")
+	return text, enc
+}
+
+// decodeForEdit is decodeText with CRLF line endings turned into LF, as
+// they are written back by encodeText.
+func decodeForEdit(data []byte) (string, textEncoding) {
+	text, enc := decodeText(data)
+	if enc.crlf {
+		text = toLF(text)
+	}
+	return text, enc
+}
+
+// encodeText stores text, with LF line endings, the way enc describes.
+func encodeText(text string, enc textEncoding) ([]byte, error) {
+	if enc.crlf {
+		text = strings.ReplaceAll(toLF(text), "\n", "\r\n")
+	}
+	data := []byte(text)
+	if charset, ok := charsets[enc.charset]; ok {
+		encoded, err := charset.NewEncoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot be written in %s: %w", enc.charset, err)
+		}
+		data = encoded
+	}
+	if enc.bom {
+		data = append(append([]byte{}, byteOrderMarks[enc.charset]...), data...)
+	}
+	return data, nil
+}
+
+func toLF(text string) string {
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// label describes enc in export headers, mentioning CRLF line endings only
+// when they were normalized away. It is empty for plain UTF-8.
+func (enc textEncoding) label(normalized bool) string {
+	var parts []string
+	switch {
+	case enc.charset != "":
+		parts = append(parts, enc.charset)
+	case enc.bom:
+		parts = append(parts, "utf-8 with bom")
+	}
+	if enc.crlf && normalized {
+		parts = append(parts, "crlf")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exportText decodes the content of a file for export, with CRLF line
+// endings normalized when asked, and labels its original encoding.
+func (m *model) exportText(data []byte) (content, label string) {
+	text, enc := decodeText(data)
+	if m.normalizeEOL {
+		text = toLF(text)
+	}
+	return text, enc.label(m.normalizeEOL)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// utf16le encodes ASCII s as UTF-16LE.
+func utf16le(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		b.WriteByte(c)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func Test_decodeText(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		text   string
+		expect textEncoding
+		label  string
+	}{
+		{name: "utf-8", data: "héllo\n", text: "héllo\n", expect: textEncoding{}},
+		{name: "utf-8 bom", data: "\xEF\xBB\xBFhi\n", text: "hi\n", expect: textEncoding{bom: true}, label: "utf-8 with bom"},
+		{name: "utf-16le bom", data: "\xFF\xFE" + utf16le("hi\r\n"), text: "hi\r\n", expect: textEncoding{charset: "utf-16le", bom: true, crlf: true}, label: "utf-16le, crlf"},
+		{name: "utf-16be bom", data: "\xFE\xFF\x00h\x00i", text: "hi", expect: textEncoding{charset: "utf-16be", bom: true}, label: "utf-16be"},
+		{name: "utf-16le without bom", data: utf16le("key=value\n"), text: "key=value\n", expect: textEncoding{charset: "utf-16le"}, label: "utf-16le"},
+		{name: "latin-1", data: "caf\xe9\r\n", text: "café\r\n", expect: textEncoding{charset: "iso-8859-1", crlf: true}, label: "iso-8859-1, crlf"},
+		{name: "windows-1252", data: "\x93quoted\x94\n", text: "“quoted”\n", expect: textEncoding{charset: "windows-1252"}, label: "windows-1252"},
+		{name: "mixed line endings", data: "a\r\nb\nc\n", text: "a\r\nb\nc\n", expect: textEncoding{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, enc := decodeText([]byte(tt.data))
+			require.Equal(t, tt.text, text)
+			require.Equal(t, tt.expect, enc)
+			require.Equal(t, tt.label, enc.label(true))
+
+			// Edited text is written back the way it was found
+			edit, _ := decodeForEdit([]byte(tt.data))
+			data, err := encodeText(edit, enc)
+			require.NoError(t, err)
+			require.Equal(t, tt.data, string(data))
+		})
+	}
+
+	_, err := encodeText("✓\n", textEncoding{charset: "iso-8859-1"})
+	require.ErrorContains(t, err, "cannot be written in iso-8859-1")
+}
+
+func Test_exportEncodings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"res.txt":   "\xFF\xFE" + utf16le("name=value\r\n"),
+		"legacy.c":  "/* caf\xe9 */\n",
+		"plain.txt": "plain\n",
+	})
+	require.Equal(t, classText, detectClass(filepath.Join(dir, "res.txt")))
+
+	var buf bytes.Buffer
+	opts := packOptions{workDir: dir, include: []string{"*"}, eol: true, hide: map[fileClass]bool{classBinary: true}}
+	require.NoError(t, runPack(opts, &buf))
+	require.Equal(t, "# legacy.c (iso-8859-1)\n/* café */\n\n"+
+		"# plain.txt\nplain\n\n"+
+		"# res.txt (utf-16le, crlf)\nname=value\n\n", buf.String())
+}
+
+func Test_applyKeepsEncoding(t *testing.T) {
+	dir := t.TempDir()
+	original := "\xFF\xFE" + utf16le("name=old\r\nother=1\r\n")
+	writeFiles(t, dir, map[string]string{"res.txt": original, "legacy.txt": "caf\xe9\n"})
+
+	response := "res.txt\n<<<<<<< SEARCH\nname=old\n=======\nname=new\n>>>>>>> REPLACE\n\n" +
+		"legacy.txt\n<<<<<<< SEARCH\ncafé\n=======\ncafé ✓\n>>>>>>> REPLACE\n"
+	var stdout, stderr strings.Builder
+	err := runApply(applyOptions{workDir: dir}, response, &stdout, &stderr)
+	require.ErrorIs(t, err, errConflicts)
+	require.Contains(t, stderr.String(), "cannot be written in iso-8859-1")
+
+	data, err := os.ReadFile(filepath.Join(dir, "res.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "\xFF\xFE"+utf16le("name=new\r\nother=1\r\n"), string(data))
+	data, err = os.ReadFile(filepath.Join(dir, "legacy.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "caf\xe9\n", string(data))
+}
@@ -20,9 +20,20 @@ func initFindInput() textarea.Model {
 	return ti
 }
 
+// findGlob returns the glob of the find pattern along with the line ranges
+// of a path:120-180 pattern, which performFind ignores.
+func (m *model) findGlob() (string, []lineRange, error) {
+	pattern := m.findPattern.Value()
+	glob, ranges, err := splitLineSpec(pattern)
+	if err != nil {
+		return pattern, nil, err
+	}
+	return glob, ranges, nil
+}
+
 // performFind executes a glob pattern search and stores matches.
 func (m *model) performFind() {
-	pattern := m.findPattern.Value()
+	pattern, _, _ := m.findGlob()
 	if pattern == "" {
 		m.matchedNodes = nil
 		m.currentMatchIdx = -1
@@ -30,11 +41,7 @@ func (m *model) performFind() {
 	}
 
 	// Create filters for matching
-	filters := make([]FilterFunc, 0)
-	if m.removeHidden {
-		filters = append(filters, FilterHidden)
-	}
-	filters = append(filters, FilterBinary)
+	filters := m.filters()
 
 	// Instead of using doublestar.FilepathGlob directly,
 	// we'll walk the tree structure we already have and match against the pattern
@@ -130,7 +137,7 @@ func (m *model) navigateToMatch(idx int) {
 
 // ensureNodeInViewport adjusts the offset to make sure the current cursor is visible.
 func (m *model) ensureNodeInViewport() {
-	helpLines := len(strings.Split(helpMsg, "\n"))
+	helpLines := len(strings.Split(m.treeHint(), "\n"))
 	maxVisibleNodes := m.windowSize.height - helpLines - 2
 
 	// If cursor is below the viewport, adjust offset
@@ -166,3 +173,28 @@ func (m *model) prevMatch() {
 	}
 	m.navigateToMatch(prevIdx)
 }
+
+// refreshMatches recomputes the matches of the current pattern after more of
+// the tree has been read, without moving the cursor.
+func (m *model) refreshMatches() {
+	pattern, _, _ := m.findGlob()
+	if pattern == "" || m.rootNode == nil {
+		return
+	}
+	var current *FileNode
+	if m.currentMatchIdx >= 0 && m.currentMatchIdx < len(m.matchedNodes) {
+		current = m.matchedNodes[m.currentMatchIdx]
+	}
+	m.matchedNodes = make([]*FileNode, 0)
+	m.findMatchesInNode(m.rootNode, filepath.Join(m.workDir, pattern), m.filters())
+	m.currentMatchIdx = -1
+	for i, node := range m.matchedNodes {
+		if node == current {
+			m.currentMatchIdx = i
+		}
+	}
+	if m.currentMatchIdx < 0 && len(m.matchedNodes) > 0 {
+		m.currentMatchIdx = 0
+	}
+	m.flattenTree()
+}
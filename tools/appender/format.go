@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// bundleFile is a single file included in an export.
+type bundleFile struct {
+	AbsPath   string `json:"-"`
+	Path      string `json:"path"`
+	Language  string `json:"language"`
+	Size      int64  `json:"size"`
+	Tokens    int    `json:"-"`
+	Content   string `json:"content"`
+	Status    string `json:"status,omitempty"`    // change status when exporting diffs
+	OldPath   string `json:"old_path,omitempty"`  // previous path of a renamed file
+	Diff      string `json:"diff,omitempty"`      // unified diff against the diff ref
+	Link      string `json:"link,omitempty"`      // target of a symlink exported as a link
+	Encoding  string `json:"encoding,omitempty"`  // how the file is stored when not in UTF-8, see textEncoding.label
+	Lines     string `json:"lines,omitempty"`     // the line ranges exported of a partially selected file
+	Inclusion string `json:"inclusion,omitempty"` // "outline" or "path" for a file not exported in full
+}
+
+// Format names an output layout for the exported bundle.
+type Format string
+
+const (
+	FormatPlain    Format = "plain"
+	FormatXML      Format = "xml"
+	FormatMarkdown Format = "markdown"
+	FormatJSON     Format = "json"
+	FormatJSONL    Format = "jsonl"
+)
+
+// formatFunc writes files to w in a particular layout.
+type formatFunc func(w io.Writer, files []bundleFile) error
+
+var formatters = map[Format]formatFunc{
+	FormatPlain:    writePlain,
+	FormatXML:      writeXML,
+	FormatMarkdown: writeMarkdown,
+	FormatJSON:     writeJSON,
+	FormatJSONL:    writeJSONL,
+}
+
+// formatOrder is the order formats are cycled through in the UI.
+var formatOrder = []Format{FormatPlain, FormatXML, FormatMarkdown, FormatJSON, FormatJSONL}
+
+// parseFormat validates a format name given on the command line.
+func parseFormat(name string) (Format, error) {
+	format := Format(strings.ToLower(name))
+	if _, ok := formatters[format]; !ok {
+		names := make([]string, 0, len(formatOrder))
+		for _, f := range formatOrder {
+			names = append(names, string(f))
+		}
+		return "", fmt.Errorf("unknown format %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
+// nextFormat returns the format after current in formatOrder, wrapping around.
+func nextFormat(current Format) Format {
+	for i, f := range formatOrder {
+		if f == current {
+			return formatOrder[(i+1)%len(formatOrder)]
+		}
+	}
+	return formatOrder[0]
+}
+
+// previewMarkdown converts a rendered bundle into markdown for the glamour
+// preview. Markdown output is shown as-is and the plain layout keeps its
+// historical rendering; everything else is wrapped in a fenced block so it is
+// highlighted instead of being interpreted as markdown.
+func previewMarkdown(format Format, rendered string) string {
+	switch format {
+	case FormatPlain, FormatMarkdown:
+		return rendered
+	case FormatXML:
+		return fenced("xml", rendered)
+	case FormatJSON, FormatJSONL:
+		return fenced("json", rendered)
+	default:
+		return fenced("", rendered)
+	}
+}
+
+func writePlain(w io.Writer, files []bundleFile) error {
+	for _, file := range files {
+		header := file.Path
+		if label := file.headerLabel(); label != "" {
+			header += " (" + label + ")"
+		}
+		if _, err := fmt.Fprintf(w, "# %s\n%s", header, file.Diff); err != nil {
+			return err
+		}
+		if file.Diff != "" && file.Content != "" {
+			if _, err := fmt.Fprintf(w, "# %s (full)\n", file.Path); err != nil {
+				return err
+			}
+		}
+		if file.hasContent() {
+			if _, err := fmt.Fprintf(w, "%s\n", file.Content); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeXML emits the document layout recommended by Anthropic for long
+// context prompts. File contents are written verbatim so that code is not
+// obscured by entity escaping.
+func writeXML(w io.Writer, files []bundleFile) error {
+	var b strings.Builder
+	b.WriteString("<documents>\n")
+	for i, file := range files {
+		fmt.Fprintf(&b, "<document index=\"%d\">\n", i+1)
+		b.WriteString("<source>")
+		if err := xml.EscapeText(&b, []byte(file.Path)); err != nil {
+			return err
+		}
+		b.WriteString("</source>\n")
+		if file.Status != "" {
+			fmt.Fprintf(&b, "<status>%s</status>\n", file.Status)
+		}
+		if file.OldPath != "" {
+			b.WriteString("<previous_source>")
+			if err := xml.EscapeText(&b, []byte(file.OldPath)); err != nil {
+				return err
+			}
+			b.WriteString("</previous_source>\n")
+		}
+		if file.Link != "" {
+			b.WriteString("<symlink_target>")
+			if err := xml.EscapeText(&b, []byte(file.Link)); err != nil {
+				return err
+			}
+			b.WriteString("</symlink_target>\n")
+		}
+		if file.Inclusion != "" {
+			fmt.Fprintf(&b, "<inclusion>%s</inclusion>\n", file.Inclusion)
+		}
+		if file.Lines != "" {
+			fmt.Fprintf(&b, "<lines>%s</lines>\n", file.Lines)
+		}
+		if file.Encoding != "" {
+			fmt.Fprintf(&b, "<encoding>%s</encoding>\n", file.Encoding)
+		}
+		if file.Diff != "" {
+			b.WriteString("<diff>\n" + withNewline(file.Diff) + "</diff>\n")
+		}
+		if file.hasContent() {
+			b.WriteString("<document_content>\n" + withNewline(file.Content) + "</document_content>\n")
+		}
+		b.WriteString("</document>\n")
+	}
+	b.WriteString("</documents>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdown(w io.Writer, files []bundleFile) error {
+	for i, file := range files {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "## `%s`\n\n", file.Path)
+		if label := file.headerLabel(); label != "" {
+			fmt.Fprintf(&b, "_%s_\n\n", label)
+		}
+		if file.Diff != "" {
+			b.WriteString(fenced("diff", file.Diff))
+		}
+		if file.Diff != "" && file.Content != "" {
+			b.WriteString("\n")
+		}
+		if file.hasContent() {
+			b.WriteString(fenced(file.Language, file.Content))
+		}
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, files []bundleFile) error {
+	if files == nil {
+		files = []bundleFile{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(files)
+}
+
+func writeJSONL(w io.Writer, files []bundleFile) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, file := range files {
+		if err := enc.Encode(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasContent reports whether the full content of file should be written.
+// Files exported as diffs only carry their diff, unchanged ones nothing but
+// their status, and files exported as a path nothing at all.
+func (f bundleFile) hasContent() bool {
+	if f.Inclusion == string(inclusionPath) {
+		return false
+	}
+	return f.Content != "" || (f.Diff == "" && f.Status == "" && f.Link == "")
+}
+
+// withNewline returns s terminated by a newline unless it is empty.
+func withNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
+
+// fenced wraps content in a markdown code fence tagged with lang. The fence
+// is always longer than the longest run of backticks inside content so the
+// block can never be closed early.
+func fenced(lang, content string) string {
+	fence := strings.Repeat("`", max(3, longestBacktickRun(content)+1))
+	return fence + lang + "\n" + withNewline(content) + fence + "\n"
+}
+
+func longestBacktickRun(s string) int {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+			continue
+		}
+		run = 0
+	}
+	return longest
+}
+
+// languageFor guesses the language of a file from its name for use as a
+// code fence tag.
+func languageFor(path string) string {
+	base := filepath.Base(path)
+	switch base {
+	case "Makefile", "makefile", "GNUmakefile":
+		return "makefile"
+	case "Dockerfile":
+		return "dockerfile"
+	case "justfile", "Justfile":
+		return "just"
+	case "go.mod", "go.sum":
+		return "gomod"
+	}
+
+	if lang, ok := languagesByExt[strings.ToLower(filepath.Ext(base))]; ok {
+		return lang
+	}
+	return ""
+}
+
+var languagesByExt = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".jsx":   "jsx",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".php":   "php",
+	".lua":   "lua",
+	".sh":    "bash",
+	".bash":  "bash",
+	".zsh":   "zsh",
+	".fish":  "fish",
+	".ps1":   "powershell",
+	".sql":   "sql",
+	".html":  "html",
+	".htm":   "html",
+	".css":   "css",
+	".scss":  "scss",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".xml":   "xml",
+	".md":    "markdown",
+	".nix":   "nix",
+	".proto": "protobuf",
+	".tf":    "hcl",
+	".vim":   "vim",
+	".el":    "elisp",
+	".ex":    "elixir",
+	".exs":   "elixir",
+	".erl":   "erlang",
+	".hs":    "haskell",
+	".ml":    "ocaml",
+	".scala": "scala",
+	".zig":   "zig",
+	".txt":   "text",
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fenced(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "no backticks",
+			lang:    "go",
+			content: "package main\n",
+			expect:  "```go\npackage main\n```\n",
+		},
+		{
+			name:    "adds trailing newline",
+			lang:    "",
+			content: "hello",
+			expect:  "```\nhello\n```\n",
+		},
+		{
+			name:    "longer fence than content",
+			lang:    "markdown",
+			content: "````go\nx\n````\n",
+			expect:  "`````markdown\n````go\nx\n````\n`````\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expect, fenced(tt.lang, tt.content))
+		})
+	}
+}
+
+func Test_formatters(t *testing.T) {
+	files := []bundleFile{
+		{Path: "a/main.go", Language: "go", Size: 13, Content: "package main\n"},
+		{Path: "b/<x>.txt", Language: "text", Size: 2, Content: "hi"},
+	}
+	tests := []struct {
+		format Format
+		expect string
+	}{
+		{
+			format: FormatPlain,
+			expect: "# a/main.go\npackage main\n\n# b/<x>.txt\nhi\n",
+		},
+		{
+			format: FormatXML,
+			expect: "<documents>\n" +
+				"<document index=\"1\">\n<source>a/main.go</source>\n<document_content>\npackage main\n</document_content>\n</document>\n" +
+				"<document index=\"2\">\n<source>b/&lt;x&gt;.txt</source>\n<document_content>\nhi\n</document_content>\n</document>\n" +
+				"</documents>\n",
+		},
+		{
+			format: FormatMarkdown,
+			expect: "## `a/main.go`\n\n```go\npackage main\n```\n\n## `b/<x>.txt`\n\n```text\nhi\n```\n",
+		},
+		{
+			format: FormatJSONL,
+			expect: `{"path":"a/main.go","language":"go","size":13,"content":"package main\n"}` + "\n" +
+				`{"path":"b/<x>.txt","language":"text","size":2,"content":"hi"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, formatters[tt.format](&buf, files))
+			require.Equal(t, tt.expect, buf.String())
+		})
+	}
+}
+
+func Test_formattersWithDiffs(t *testing.T) {
+	diff := "@@ -1 +1 @@\n-a\n+b\n"
+	files := []bundleFile{
+		{Path: "new.txt", Language: "text", Status: statusRenamed, OldPath: "old.txt", Diff: diff},
+		{Path: "b.txt", Language: "text", Status: statusModified, Diff: diff, Content: "b\n"},
+	}
+	tests := []struct {
+		format Format
+		expect string
+	}{
+		{
+			format: FormatPlain,
+			expect: "# new.txt (renamed from old.txt)\n" + diff +
+				"# b.txt (modified)\n" + diff + "# b.txt (full)\nb\n\n",
+		},
+		{
+			format: FormatXML,
+			expect: "<documents>\n" +
+				"<document index=\"1\">\n<source>new.txt</source>\n<status>renamed</status>\n<previous_source>old.txt</previous_source>\n<diff>\n" + diff + "</diff>\n</document>\n" +
+				"<document index=\"2\">\n<source>b.txt</source>\n<status>modified</status>\n<diff>\n" + diff + "</diff>\n<document_content>\nb\n</document_content>\n</document>\n" +
+				"</documents>\n",
+		},
+		{
+			format: FormatMarkdown,
+			expect: "## `new.txt`\n\n_renamed from old.txt_\n\n```diff\n" + diff + "```\n\n" +
+				"## `b.txt`\n\n_modified_\n\n```diff\n" + diff + "```\n\n```text\nb\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, formatters[tt.format](&buf, files))
+			require.Equal(t, tt.expect, buf.String())
+		})
+	}
+}
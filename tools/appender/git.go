@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitChangeSet names a group of files reported by git.
+type gitChangeSet string
+
+const (
+	gitModified  gitChangeSet = "modified"  // changed in the working tree but not staged
+	gitStaged    gitChangeSet = "staged"    // changes added to the index
+	gitUntracked gitChangeSet = "untracked" // not tracked and not ignored
+)
+
+// gitFileStatus is one entry of `git status`.
+type gitFileStatus struct {
+	path     string // relative to the repository root, slash separated
+	origPath string // previous path for renames and copies
+	index    byte   // status in the index (X in `git status --porcelain`)
+	worktree byte   // status in the working tree (Y)
+}
+
+// inSet reports whether the entry belongs to set.
+func (s gitFileStatus) inSet(set gitChangeSet) bool {
+	switch set {
+	case gitUntracked:
+		return s.index == '?'
+	case gitStaged:
+		return s.index != ' ' && s.index != '?' && s.index != '!'
+	case gitModified:
+		return s.worktree != ' ' && s.worktree != '?' && s.worktree != '!'
+	}
+	return false
+}
+
+// gitRepo runs git commands for the repository containing dir.
+type gitRepo struct {
+	root string // absolute path of the working tree root
+}
+
+// openGitRepo locates the repository containing dir using the git CLI.
+func openGitRepo(dir string) (*gitRepo, error) {
+	out, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	return &gitRepo{root: strings.TrimSpace(string(out))}, nil
+}
+
+// runGit runs git in dir and returns its stdout. Failures include git's
+// stderr so they can be shown to the user.
+func runGit(dir string, args ...string) ([]byte, error) {
+	return runGitAllowExit(dir, 0, args...)
+}
+
+// runGitAllowExit is runGit for commands that use a non-zero exit code
+// other than failure, such as `git diff --no-index` exiting 1 when the
+// files differ.
+func runGitAllowExit(dir string, allowed int, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if allowed != 0 && exitErr.ExitCode() == allowed {
+				return out, nil
+			}
+			return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// status returns the entries of `git status`, including every untracked
+// file rather than just untracked directories.
+func (r *gitRepo) status() ([]gitFileStatus, error) {
+	out, err := runGit(r.root, "status", "--porcelain=v1", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []gitFileStatus
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if len(field) < 4 {
+			continue
+		}
+		entry := gitFileStatus{index: field[0], worktree: field[1], path: field[3:]}
+		// Renames and copies are followed by the original path.
+		if entry.index == 'R' || entry.index == 'C' {
+			if i+1 < len(fields) {
+				entry.origPath = fields[i+1]
+				i++
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// changedFiles returns the files in the given sets, relative to the
+// repository root.
+func (r *gitRepo) changedFiles(sets ...gitChangeSet) ([]string, error) {
+	entries, err := r.status()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		for _, set := range sets {
+			if entry.inSet(set) {
+				paths = append(paths, entry.path)
+				break
+			}
+		}
+	}
+	return paths, nil
+}
+
+// changedSince returns the files that differ between the merge base of ref
+// and HEAD, and HEAD, i.e. the changes made on this branch since it forked
+// from ref.
+func (r *gitRepo) changedSince(ref string) ([]string, error) {
+	out, err := runGit(r.root, "diff", "--name-only", "-z", ref+"...HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return splitNul(out), nil
+}
+
+// splitNul splits NUL-terminated git output.
+func splitNul(out []byte) []string {
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// toNodePath converts a path relative to the repository root into the form
+// used for FileNode paths below workDir. ok is false for paths outside
+// workDir.
+func (r *gitRepo) toNodePath(workDir, repoPath string) (string, bool) {
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return "", false
+	}
+	// git reports the resolved root, so resolve workDir the same way
+	if resolved, err := filepath.EvalSymlinks(absWorkDir); err == nil {
+		absWorkDir = resolved
+	}
+	rel, err := filepath.Rel(absWorkDir, filepath.Join(r.root, filepath.FromSlash(repoPath)))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(workDir, rel), true
+}
+
+// repoPath is the inverse of toNodePath: it converts a FileNode path into a
+// slash-separated path relative to the repository root, resolving symlinks
+// the way git does. It is "" when path cannot be resolved.
+func (r *gitRepo) repoPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	rel, err := filepath.Rel(r.root, abs)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// gitNodePaths resolves the files in the given git status sets, or the
+// files changed since ref when ref is non-empty, to FileNode paths below
+// m.workDir.
+func (m *model) gitNodePaths(ref string, sets ...gitChangeSet) ([]string, error) {
+	repo, err := openGitRepo(m.workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var repoPaths []string
+	if ref != "" {
+		repoPaths, err = repo.changedSince(ref)
+	} else {
+		repoPaths, err = repo.changedFiles(sets...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(repoPaths))
+	for _, repoPath := range repoPaths {
+		if path, ok := repo.toNodePath(m.workDir, repoPath); ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// selectPaths selects the files at paths and expands their parent
+// directories so they are visible. Paths that are not in the tree, such as
+// deleted files or files inside an unread ignored directory, are skipped.
+// It returns the number of files selected.
+func (m *model) selectPaths(paths []string) int {
+	selected := 0
+	for _, path := range paths {
+		m.ensureLoaded(path)
+		node, ok := m.nodeLookup[path]
+		if !ok || node.isDir || !checkReadable(node) {
+			continue
+		}
+		node.selected = true
+		m.ensureNodeVisible(node)
+		selected++
+	}
+	return selected
+}
+
+// selectGitChanges selects the files reported by git for the given sets,
+// or changed since ref, and returns a notice describing the result.
+func (m *model) selectGitChanges(ref string, sets ...gitChangeSet) string {
+	paths, err := m.gitNodePaths(ref, sets...)
+	if err != nil {
+		return fmt.Sprintf("git: %v", err)
+	}
+
+	n := m.selectPaths(paths)
+	if ref != "" {
+		return fmt.Sprintf("selected %d files changed since %s", n, ref)
+	}
+	names := make([]string, 0, len(sets))
+	for _, set := range sets {
+		names = append(names, string(set))
+	}
+	return fmt.Sprintf("selected %d %s files", n, strings.Join(names, "/"))
+}
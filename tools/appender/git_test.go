@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepo creates a git repository in a temporary directory with an
+// initial commit on main containing files.
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	gitCmd(t, dir, "init", "-q", "-b", "main")
+	gitCmd(t, dir, "config", "user.email", "test@example.com")
+	gitCmd(t, dir, "config", "user.name", "Test")
+	gitCmd(t, dir, "config", "commit.gpgsign", "false")
+	writeFiles(t, dir, files)
+	gitCmd(t, dir, "add", "-A")
+	gitCmd(t, dir, "commit", "-q", "-m", "initial")
+	return dir
+}
+
+func gitCmd(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		full := filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0o600))
+	}
+}
+
+func Test_gitNodePaths(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"main.go":        "package main\n",
+		"lib/a.go":       "package lib\n",
+		"lib/b.go":       "package lib\n",
+		"docs/readme.md": "# docs\n",
+	})
+
+	gitCmd(t, dir, "checkout", "-q", "-b", "feature")
+	writeFiles(t, dir, map[string]string{"lib/c.go": "package lib\n"})
+	gitCmd(t, dir, "add", "lib/c.go")
+	gitCmd(t, dir, "commit", "-q", "-m", "add c")
+
+	writeFiles(t, dir, map[string]string{
+		"lib/a.go":      "package lib\n\nfunc A() {}\n",
+		"main.go":       "package main\n\nfunc main() {}\n",
+		"lib/new.go":    "package lib\n",
+		"docs/todo.txt": "todo\n",
+	})
+	gitCmd(t, dir, "add", "main.go")
+
+	tests := []struct {
+		name    string
+		workDir string
+		ref     string
+		sets    []gitChangeSet
+		expect  []string
+	}{
+		{name: "modified", workDir: dir, sets: []gitChangeSet{gitModified}, expect: []string{"lib/a.go"}},
+		{name: "staged", workDir: dir, sets: []gitChangeSet{gitStaged}, expect: []string{"main.go"}},
+		{name: "untracked", workDir: dir, sets: []gitChangeSet{gitUntracked}, expect: []string{"docs/todo.txt", "lib/new.go"}},
+		{name: "modified and staged", workDir: dir, sets: []gitChangeSet{gitModified, gitStaged}, expect: []string{"lib/a.go", "main.go"}},
+		{name: "since main", workDir: dir, ref: "main", expect: []string{"lib/c.go"}},
+		{name: "subdirectory", workDir: filepath.Join(dir, "lib"), sets: []gitChangeSet{gitModified, gitStaged, gitUntracked}, expect: []string{"a.go", "new.go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &model{workDir: tt.workDir}
+			paths, err := m.gitNodePaths(tt.ref, tt.sets...)
+			require.NoError(t, err)
+
+			rel := make([]string, 0, len(paths))
+			for _, path := range paths {
+				r, err := filepath.Rel(tt.workDir, path)
+				require.NoError(t, err)
+				rel = append(rel, filepath.ToSlash(r))
+			}
+			sort.Strings(rel)
+			require.Equal(t, tt.expect, rel)
+		})
+	}
+
+	t.Run("selects and expands", func(t *testing.T) {
+		m := &model{workDir: dir}
+		require.NoError(t, m.buildFileTree())
+		notice := m.selectGitChanges("", gitUntracked)
+		require.Equal(t, "selected 2 untracked files", notice)
+		require.True(t, m.nodeLookup[filepath.Join(dir, "lib", "new.go")].selected)
+		require.True(t, m.nodeLookup[filepath.Join(dir, "lib")].expanded)
+		require.False(t, m.nodeLookup[filepath.Join(dir, "lib", "a.go")].selected)
+	})
+}
+
+func Test_loadGitStatusCmd(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"keep.go":      "package main\n",
+		"lib/a.go":     "package lib\n",
+		"lib/old.go":   "package lib\n\nfunc Old() {}\n",
+		"lib/gone.go":  "package lib\n\nfunc Gone() {}\n",
+		"deep/x/y.txt": "y\n",
+	})
+	writeFiles(t, dir, map[string]string{
+		"lib/a.go":     "package lib\n\nvar A = 1\n",
+		"deep/x/z.txt": "z\n",
+		"added.go":     "package main\n",
+	})
+	gitCmd(t, dir, "add", "added.go")
+	gitCmd(t, dir, "mv", "lib/old.go", "lib/new.go")
+	gitCmd(t, dir, "rm", "-q", "lib/gone.go")
+
+	m := &model{workDir: dir}
+	msg, ok := m.loadGitStatusCmd()().(gitStatusMsg)
+	require.True(t, ok)
+	require.NoError(t, msg.err)
+
+	expect := map[string]byte{
+		"lib/a.go":     'M',
+		"deep/x/z.txt": '?',
+		"added.go":     'A',
+		"lib/new.go":   'R',
+		"lib":          dirChangedMarker,
+		"deep":         dirChangedMarker,
+		"deep/x":       dirChangedMarker,
+	}
+	got := make(map[string]byte, len(msg.markers))
+	for path, marker := range msg.markers {
+		rel, err := filepath.Rel(dir, path)
+		require.NoError(t, err)
+		got[filepath.ToSlash(rel)] = marker
+	}
+	// Deleted files are marked even though they are no longer in the tree
+	expect["lib/gone.go"] = 'D'
+	require.Equal(t, expect, got)
+	require.NotContains(t, got, "keep.go")
+}
+
+func Test_deletedFilesInTree(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"lib/a.go":    "package lib\n",
+		"lib/gone.go": "package lib\n\nfunc Gone() {}\n",
+		"z.go":        "package main\n",
+	})
+	require.NoError(t, os.Remove(filepath.Join(dir, "lib", "gone.go")))
+
+	m := newRangeTestModel(t, dir)
+	m.nodeLookup[filepath.Join(dir, "lib")].expanded = true
+	m.Update(m.loadGitStatusCmd()())
+
+	var names []string
+	for _, node := range m.flatNodes {
+		names = append(names, node.name)
+	}
+	require.Equal(t, []string{filepath.Base(dir), "lib", "a.go", "gone.go", "z.go"}, names)
+	gone := m.flatNodes[3]
+	require.True(t, gone.deleted)
+	require.Contains(t, m.getNodeDisplay(gone), "D")
+
+	// Deleted files cannot be selected
+	m.cursor = 3
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(" ")})
+	require.Equal(t, "cannot select gone.go: it was deleted", m.notice)
+	require.Empty(t, m.selectedFilePaths())
+
+	// The diff pane loads the diff in the background
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	require.True(t, m.showDiff)
+	require.Contains(t, m.rightViewport.View(), "Loading the diff of gone.go")
+	cmd := m.updateContent()
+	require.NotNil(t, cmd)
+	m.Update(cmd())
+	require.Contains(t, m.rightViewport.View(), "func Gone() {}")
+
+	// A diff arriving after the cursor moved on is dropped
+	msg := m.loadDiffCmd(gone.path)()
+	m.cursor = 2
+	m.updateContent()
+	m.Update(msg)
+	require.Contains(t, m.rightViewport.View(), "Loading the diff of a.go")
+}
+
+func Test_deletedFilesInTreeRelative(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"a.go":     "package main\n",
+		"gone.go":  "package main\n",
+		"lib/b.go": "package lib\n",
+		"z.go":     "package main\n",
+	})
+	require.NoError(t, os.Remove(filepath.Join(dir, "gone.go")))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { require.NoError(t, os.Chdir(wd)) })
+
+	// The default working directory
+	m := newRangeTestModel(t, ".")
+	m.Update(m.loadGitStatusCmd()())
+
+	var names []string
+	for _, node := range m.flatNodes {
+		names = append(names, node.name)
+	}
+	require.Equal(t, []string{".", "a.go", "gone.go", "lib", "z.go"}, names)
+	require.True(t, m.flatNodes[2].deleted)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// gitStatusMsg carries the git status of the tree, loaded in the background.
+type gitStatusMsg struct {
+	markers map[string]byte // status marker by FileNode path
+	err     error
+}
+
+// gitMarkerStyles colors the markers shown next to changed files.
+var gitMarkerStyles = map[byte]lipgloss.Style{
+	'M': lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	'A': lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+	'?': lipgloss.NewStyle().Foreground(lipgloss.Color("245")),
+	'D': lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+	'R': lipgloss.NewStyle().Foreground(lipgloss.Color("39")),
+	'U': lipgloss.NewStyle().Foreground(lipgloss.Color("201")).Bold(true),
+}
+
+// dirChangedMarker marks directories that contain changes. It is drawn
+// as a dot in the tree.
+const dirChangedMarker byte = '*'
+
+// marker condenses a status entry into the single letter shown in the tree.
+// Working tree changes take precedence over staged ones since they are
+// what the file on disk looks like now.
+func (s gitFileStatus) marker() byte {
+	switch {
+	case s.index == '?':
+		return '?'
+	case s.index == 'U' || s.worktree == 'U' || (s.index == 'A' && s.worktree == 'A') || (s.index == 'D' && s.worktree == 'D'):
+		return 'U'
+	case s.worktree == 'D' || s.index == 'D':
+		return 'D'
+	case s.worktree == 'M':
+		return 'M'
+	case s.index == 'A':
+		return 'A'
+	case s.index == 'R' || s.index == 'C':
+		return 'R'
+	default:
+		return 'M'
+	}
+}
+
+// loadGitStatusCmd reads `git status` in the background and maps it onto
+// FileNode paths, marking every directory that contains a change.
+func (m *model) loadGitStatusCmd() tea.Cmd {
+	workDir := m.workDir
+	return func() tea.Msg {
+		repo, err := openGitRepo(workDir)
+		if err != nil {
+			return gitStatusMsg{err: err}
+		}
+		entries, err := repo.status()
+		if err != nil {
+			return gitStatusMsg{err: err}
+		}
+
+		markers := make(map[string]byte, len(entries))
+		for _, entry := range entries {
+			path, ok := repo.toNodePath(workDir, entry.path)
+			if !ok {
+				continue
+			}
+			markers[path] = entry.marker()
+
+			rel, err := filepath.Rel(workDir, path)
+			if err != nil {
+				continue
+			}
+			for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+				dirPath := filepath.Join(workDir, dir)
+				if _, ok := markers[dirPath]; ok {
+					break // the rest of the ancestors are already marked
+				}
+				markers[dirPath] = dirChangedMarker
+			}
+		}
+		return gitStatusMsg{markers: markers}
+	}
+}
+
+// gitMarker renders the status marker for node, or "" when it is unchanged.
+func (m *model) gitMarker(node *FileNode) string {
+	marker, ok := m.gitMarkers[node.path]
+	if !ok {
+		return ""
+	}
+	if marker == dirChangedMarker {
+		return " " + lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Render("•")
+	}
+	return " " + gitMarkerStyles[marker].Render(string(marker))
+}
+
+// diffLoadedMsg carries the diff of the file at path, computed in the
+// background for the diff pane.
+type diffLoadedMsg struct {
+	path string
+	diff string
+	err  error
+}
+
+// fileDiff returns the colorized diff of the file at path against HEAD.
+// Untracked files are shown as entirely added.
+func fileDiff(workDir, path string, untracked bool) (string, error) {
+	repo, err := openGitRepo(workDir)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	// Relative paths keep the diff headers short
+	rel, err := filepath.Rel(repo.root, abs)
+	if err != nil {
+		return "", err
+	}
+
+	if untracked {
+		// `git diff --no-index` exits with status 1 when the files differ
+		out, err := runGitAllowExit(repo.root, 1, "diff", "--color=always", "--no-index", "--", os.DevNull, rel)
+		return string(out), err
+	}
+
+	out, err := runGit(repo.root, "diff", "--color=always", "HEAD", "--", rel)
+	if err != nil {
+		// A repository without commits has no HEAD to diff against
+		out, err = runGit(repo.root, "diff", "--color=always", "--cached", "--", rel)
+	}
+	return string(out), err
+}
+
+// updateDiff shows the diff of the file under the cursor in the right pane.
+// Running git can take a while, so the diff is computed in the background
+// and shown by showDiffLoaded.
+func (m *model) updateDiff() tea.Cmd {
+	content := "Nothing to diff"
+	var cmd tea.Cmd
+	if m.cursor >= 0 && m.cursor < len(m.flatNodes) {
+		node := m.flatNodes[m.cursor]
+		content = "Select a file to see its diff"
+		if !node.isDir {
+			content = "Loading the diff of " + node.name + "…"
+			cmd = m.loadDiffCmd(node.path)
+		}
+	}
+
+	m.rightViewport.SetContent(content)
+	m.rightViewport.YOffset = 0
+	return cmd
+}
+
+// loadDiffCmd computes the diff of the file at path off the UI goroutine.
+func (m *model) loadDiffCmd(path string) tea.Cmd {
+	workDir, untracked := m.workDir, m.gitMarkers[path] == '?'
+	return func() tea.Msg {
+		diff, err := fileDiff(workDir, path, untracked)
+		return diffLoadedMsg{path: path, diff: diff, err: err}
+	}
+}
+
+// showDiffLoaded shows a diff computed by loadDiffCmd, unless the pane was
+// closed or the cursor moved to another file in the meantime.
+func (m *model) showDiffLoaded(msg diffLoadedMsg) {
+	if !m.showDiff || m.cursor < 0 || m.cursor >= len(m.flatNodes) || m.flatNodes[m.cursor].path != msg.path {
+		return
+	}
+	content := msg.diff
+	switch {
+	case msg.err != nil:
+		content = "Error running git diff: " + msg.err.Error()
+	case msg.diff == "":
+		content = filepath.Base(msg.path) + " has no changes"
+	}
+	m.rightViewport.SetContent(content)
+	m.rightViewport.YOffset = 0
+}
+
+// withDeleted adds a line for each file git reports as deleted to flat, in
+// path order among the entries of its directory when that is expanded, so
+// that the deletion shows in the tree. The lines are placeholders: they are
+// not in the tree and cannot be selected.
+func (m *model) withDeleted(flat []*FileNode) []*FileNode {
+	deleted := make(map[string][]string)
+	for path, marker := range m.gitMarkers {
+		if _, ok := m.nodeLookup[path]; marker == 'D' && !ok {
+			dir := filepath.Dir(path)
+			deleted[dir] = append(deleted[dir], path)
+		}
+	}
+	if len(deleted) == 0 {
+		return flat
+	}
+	for _, paths := range deleted {
+		sort.Strings(paths)
+	}
+
+	result := make([]*FileNode, 0, len(flat))
+	// place adds the deleted files of dir named before next, or all that are
+	// left when next is "".
+	place := func(dir *FileNode, next string) {
+		paths := deleted[dir.path]
+		n := len(paths)
+		if next != "" {
+			n = sort.Search(len(paths), func(i int) bool { return filepath.Base(paths[i]) >= next })
+		}
+		for i, path := range paths[:n] {
+			result = append(result, &FileNode{
+				name:    filepath.Base(path),
+				path:    path,
+				deleted: true,
+				meta:    &fileMeta{},
+				prefix:  buildPrefix(childIndent(dir), next == "" && i == n-1),
+			})
+		}
+		deleted[dir.path] = paths[n:]
+	}
+	var open []*FileNode // expanded directories whose entries are being listed
+	for _, node := range flat {
+		for len(open) > 0 && !inDir(node.path, open[len(open)-1].path) {
+			place(open[len(open)-1], "")
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 {
+			place(open[len(open)-1], node.name)
+		}
+		result = append(result, node)
+		if node.isDir && node.expanded {
+			open = append(open, node)
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		place(open[i], "")
+	}
+	return result
+}
+
+// inDir reports whether path lies below dir. Comparing the paths relative to
+// each other also works for a working directory given as ".", whose entries
+// have no "./" prefix.
+func inDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -1,20 +1,53 @@
 package main
 
-import "github.com/charmbracelet/bubbles/key"
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/spf13/viper"
+)
 
 type keyMap struct {
-	Up         key.Binding
-	Down       key.Binding
-	ToggleDir  key.Binding
-	Select     key.Binding
-	ToggleHide key.Binding
-	Save       key.Binding
-	Copy       key.Binding
-	Help       key.Binding
-	Quit       key.Binding
-	Find       key.Binding
-	NextMatch  key.Binding
-	PrevMatch  key.Binding
+	Up          key.Binding
+	Down        key.Binding
+	PageUp      key.Binding
+	PageDown    key.Binding
+	Top         key.Binding
+	Bottom      key.Binding
+	ToggleDir   key.Binding
+	Select      key.Binding
+	SelectLines key.Binding
+	Inclusion   key.Binding
+	ToggleHide  key.Binding
+	ToggleIgn   key.Binding
+	ToggleBin   key.Binding
+	ToggleGen   key.Binding
+	ToggleMin   key.Binding
+	ToggleLock  key.Binding
+	Save        key.Binding
+	Copy        key.Binding
+	Help        key.Binding
+	Quit        key.Binding
+	Find        key.Binding
+	NextMatch   key.Binding
+	PrevMatch   key.Binding
+	ClearFind   key.Binding
+	ScrollUp    key.Binding
+	ScrollDown  key.Binding
+	ScrollTop   key.Binding
+	ScrollEnd   key.Binding
+	GitMod      key.Binding
+	GitStaged   key.Binding
+	GitUntrack  key.Binding
+	GitSince    key.Binding
+	GitReview   key.Binding
+	ToggleDiff  key.Binding
+	Apply       key.Binding
+	Profiles    key.Binding
+	Diagnose    key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
@@ -23,10 +56,13 @@ func (k keyMap) ShortHelp() []key.Binding {
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.Up, k.Down, k.ToggleDir},
-		{k.Select, k.ToggleHide, k.Save},
-		{k.Find, k.NextMatch, k.PrevMatch},
-		{k.Copy, k.Help, k.Quit},
+		{k.Up, k.Down, k.PageUp, k.PageDown, k.Top, k.Bottom, k.ToggleDir},
+		{k.Select, k.SelectLines, k.Inclusion, k.ToggleHide, k.ToggleIgn, k.Save},
+		{k.ToggleBin, k.ToggleGen, k.ToggleMin, k.ToggleLock},
+		{k.Find, k.NextMatch, k.PrevMatch, k.ClearFind},
+		{k.ScrollUp, k.ScrollDown, k.ScrollTop, k.ScrollEnd},
+		{k.GitMod, k.GitStaged, k.GitUntrack, k.GitSince, k.GitReview, k.ToggleDiff},
+		{k.Copy, k.Apply, k.Profiles, k.Diagnose, k.Help, k.Quit},
 	}
 }
 
@@ -39,18 +75,54 @@ var keys = keyMap{
 		key.WithKeys("down", "j"),
 		key.WithHelp("↓/j", "move down"),
 	),
+	PageUp: key.NewBinding(
+		key.WithKeys("pgup"),
+		key.WithHelp("pgup", "page up"),
+	),
+	PageDown: key.NewBinding(
+		key.WithKeys("pgdown"),
+		key.WithHelp("pgdown", "page down"),
+	),
+	Top: key.NewBinding(
+		key.WithKeys("home"),
+		key.WithHelp("home", "go to top"),
+	),
+	Bottom: key.NewBinding(
+		key.WithKeys("end"),
+		key.WithHelp("end", "go to bottom"),
+	),
 	ToggleDir: key.NewBinding(
 		key.WithKeys("l", "h"),
 		key.WithHelp("l/h", "expand/collapse"),
 	),
 	Select: key.NewBinding(
-		key.WithKeys("space"),
+		key.WithKeys(" "),
 		key.WithHelp("space", "select"),
 	),
 	ToggleHide: key.NewBinding(
 		key.WithKeys("."),
 		key.WithHelp(".", "toggle hidden"),
 	),
+	ToggleIgn: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "toggle ignored"),
+	),
+	ToggleBin: key.NewBinding(
+		key.WithKeys("b"),
+		key.WithHelp("b", "toggle binary"),
+	),
+	ToggleGen: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "toggle generated"),
+	),
+	ToggleMin: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "toggle minified"),
+	),
+	ToggleLock: key.NewBinding(
+		key.WithKeys("L"),
+		key.WithHelp("L", "toggle lockfiles"),
+	),
 	Save: key.NewBinding(
 		key.WithKeys("enter"),
 		key.WithHelp("enter", "save"),
@@ -79,4 +151,276 @@ var keys = keyMap{
 		key.WithKeys("N"),
 		key.WithHelp("N", "prev match"),
 	),
+	ClearFind: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "clear find"),
+	),
+	ScrollUp: key.NewBinding(
+		key.WithKeys("K"),
+		key.WithHelp("K", "scroll preview up"),
+	),
+	ScrollDown: key.NewBinding(
+		key.WithKeys("J"),
+		key.WithHelp("J", "scroll preview down"),
+	),
+	ScrollTop: key.NewBinding(
+		key.WithKeys("g"),
+		key.WithHelp("g", "preview top"),
+	),
+	ScrollEnd: key.NewBinding(
+		key.WithKeys("G"),
+		key.WithHelp("G", "preview bottom"),
+	),
+	GitMod: key.NewBinding(
+		key.WithKeys("M"),
+		key.WithHelp("M", "select modified"),
+	),
+	GitStaged: key.NewBinding(
+		key.WithKeys("S"),
+		key.WithHelp("S", "select staged"),
+	),
+	GitUntrack: key.NewBinding(
+		key.WithKeys("U"),
+		key.WithHelp("U", "select untracked"),
+	),
+	GitSince: key.NewBinding(
+		key.WithKeys("D"),
+		key.WithHelp("D", "select changed since --since"),
+	),
+	GitReview: key.NewBinding(
+		key.WithKeys("R"),
+		key.WithHelp("R", "select --review range"),
+	),
+	ToggleDiff: key.NewBinding(
+		key.WithKeys("d"),
+		key.WithHelp("d", "toggle diff pane"),
+	),
+	Apply: key.NewBinding(
+		key.WithKeys("A"),
+		key.WithHelp("A", "apply response from clipboard"),
+	),
+	Profiles: key.NewBinding(
+		key.WithKeys("P"),
+		key.WithHelp("P", "selection profiles"),
+	),
+	Diagnose: key.NewBinding(
+		key.WithKeys("!"),
+		key.WithHelp("!", "unreadable entries"),
+	),
+	SelectLines: key.NewBinding(
+		key.WithKeys("V"),
+		key.WithHelp("V", "select lines"),
+	),
+	Inclusion: key.NewBinding(
+		key.WithKeys("o"),
+		key.WithHelp("o", "full/outline/path only"),
+	),
+}
+
+// exportKeyMap lists the keys of the save and copy dialogs. Like the keys of
+// the other screens they are fixed: the keys section of the config remaps
+// the keys of the file tree only.
+type exportKeyMap struct {
+	Save, Copy, Decline, Format, Content, Cancel key.Binding
+}
+
+var exportKeys = exportKeyMap{
+	Save:    key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "save")),
+	Copy:    key.NewBinding(key.WithKeys("y"), key.WithHelp("y", "copy")),
+	Decline: key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "cancel")),
+	Format:  key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "change format")),
+	Content: key.NewBinding(key.WithKeys("shift+tab"), key.WithHelp("shift+tab", "change content")),
+	Cancel:  key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "cancel")),
+}
+
+// findKeyMap lists the fixed keys of the find input; other keys are typed
+// into it.
+type findKeyMap struct {
+	Accept, Cancel key.Binding
+}
+
+var findKeys = findKeyMap{
+	Accept: key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "keep matches")),
+	Cancel: key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "clear find")),
+}
+
+// sharedScreen is a screen with fixed keys that hands the keys it does not
+// use on to some actions of the file tree.
+type sharedScreen struct {
+	name    string
+	keys    [][]key.Binding
+	actions []string // the file tree actions still handled on the screen
+}
+
+// sharedScreens lists the screens that fall back to file tree actions.
+// Those actions must not be bound to a key the screen takes for itself.
+var sharedScreens = []sharedScreen{
+	{name: "the apply screen", keys: applyKeys.FullHelp(), actions: []string{"scroll-up", "scroll-down"}},
+}
+
+// keyAction names a binding for the keys section of the config.
+type keyAction struct {
+	name    string
+	binding *key.Binding
+}
+
+// actions lists the bindings of k by their config name.
+func (k *keyMap) actions() []keyAction {
+	return []keyAction{
+		{"up", &k.Up},
+		{"down", &k.Down},
+		{"page-up", &k.PageUp},
+		{"page-down", &k.PageDown},
+		{"top", &k.Top},
+		{"bottom", &k.Bottom},
+		{"toggle-dir", &k.ToggleDir},
+		{"select", &k.Select},
+		{"select-lines", &k.SelectLines},
+		{"cycle-inclusion", &k.Inclusion},
+		{"toggle-hidden", &k.ToggleHide},
+		{"toggle-ignored", &k.ToggleIgn},
+		{"toggle-binary", &k.ToggleBin},
+		{"toggle-generated", &k.ToggleGen},
+		{"toggle-minified", &k.ToggleMin},
+		{"toggle-lockfiles", &k.ToggleLock},
+		{"save", &k.Save},
+		{"copy", &k.Copy},
+		{"help", &k.Help},
+		{"quit", &k.Quit},
+		{"find", &k.Find},
+		{"next-match", &k.NextMatch},
+		{"prev-match", &k.PrevMatch},
+		{"clear-find", &k.ClearFind},
+		{"scroll-up", &k.ScrollUp},
+		{"scroll-down", &k.ScrollDown},
+		{"scroll-top", &k.ScrollTop},
+		{"scroll-bottom", &k.ScrollEnd},
+		{"git-modified", &k.GitMod},
+		{"git-staged", &k.GitStaged},
+		{"git-untracked", &k.GitUntrack},
+		{"git-since", &k.GitSince},
+		{"git-review", &k.GitReview},
+		{"toggle-diff", &k.ToggleDiff},
+		{"apply", &k.Apply},
+		{"profiles", &k.Profiles},
+		{"diagnostics", &k.Diagnose},
+	}
+}
+
+// keyNames maps the names used in config to the strings bubbletea reports
+// for the key, for keys that have no printable form.
+var keyNames = map[string]string{
+	"space": " ",
+}
+
+// keyLabels are the names shown in the help view for some keys.
+var keyLabels = map[string]string{
+	" ":    "space",
+	"up":   "↑",
+	"down": "↓",
+}
+
+// loadKeyMap returns the default bindings with the overrides from the keys
+// section of the config applied. Each override replaces all keys of an
+// action. Unknown actions, and keys bound to more than one action, are
+// errors.
+func loadKeyMap() (keyMap, error) {
+	k := keys
+	actions := k.actions()
+	known := make(map[string]*key.Binding, len(actions))
+	for _, action := range actions {
+		known[action.name] = action.binding
+	}
+
+	for name := range viper.GetStringMap("keys") {
+		if _, ok := known[name]; !ok {
+			names := make([]string, 0, len(actions))
+			for _, action := range actions {
+				names = append(names, action.name)
+			}
+			return k, fmt.Errorf("unknown key binding action %q (available: %s)", name, strings.Join(names, ", "))
+		}
+	}
+	for _, action := range actions {
+		configKey := "keys." + action.name
+		if !viper.IsSet(configKey) {
+			continue
+		}
+		var bound []string
+		for _, name := range viper.GetStringSlice(configKey) {
+			if name == "" {
+				continue
+			}
+			if mapped, ok := keyNames[name]; ok {
+				name = mapped
+			}
+			bound = append(bound, name)
+		}
+		if len(bound) == 0 {
+			return k, fmt.Errorf("key binding %s: no keys given", action.name)
+		}
+		labels := make([]string, len(bound))
+		for i, name := range bound {
+			labels[i] = name
+			if label, ok := keyLabels[name]; ok {
+				labels[i] = label
+			}
+		}
+		*action.binding = key.NewBinding(
+			key.WithKeys(bound...),
+			key.WithHelp(strings.Join(labels, "/"), action.binding.Help().Desc),
+		)
+	}
+
+	return k, k.conflicts()
+}
+
+// conflicts reports keys that are bound to more than one action, or to an
+// action a screen hands keys on to and to one of the screen's own.
+func (k *keyMap) conflicts() error {
+	owners := make(map[string][]string)
+	for _, action := range k.actions() {
+		for _, name := range action.binding.Keys() {
+			owners[name] = append(owners[name], action.name)
+		}
+	}
+	var problems []string
+	for name, actions := range owners {
+		if len(actions) > 1 {
+			problems = append(problems, fmt.Sprintf("%s is bound to %s", keyLabel(name), strings.Join(actions, " and ")))
+		}
+	}
+	for _, screen := range sharedScreens {
+		taken := make(map[string]string)
+		for _, group := range screen.keys {
+			for _, binding := range group {
+				for _, name := range binding.Keys() {
+					taken[name] = binding.Help().Desc
+				}
+			}
+		}
+		for _, action := range k.actions() {
+			if !slices.Contains(screen.actions, action.name) {
+				continue
+			}
+			for _, name := range action.binding.Keys() {
+				if desc, ok := taken[name]; ok {
+					problems = append(problems, fmt.Sprintf("%s is bound to %s, which %s takes for %s", keyLabel(name), action.name, screen.name, desc))
+				}
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("conflicting key bindings: %s", strings.Join(problems, "; "))
+}
+
+// keyLabel is how the help view shows the key called name.
+func keyLabel(name string) string {
+	if label, ok := keyLabels[name]; ok {
+		return label
+	}
+	return name
 }
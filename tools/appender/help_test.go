@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadKeyMap(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]any
+		expect    func(t *testing.T, k keyMap, err error)
+	}{
+		{
+			name: "defaults",
+			expect: func(t *testing.T, k keyMap, err error) {
+				t.Helper()
+				require.NoError(t, err)
+				require.True(t, key.Matches(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}, k.Select))
+				require.True(t, key.Matches(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'J'}}, k.ScrollDown))
+			},
+		},
+		{
+			name: "remapped",
+			overrides: map[string]any{
+				"keys.scroll-down": []string{"ctrl+d", "f"},
+				"keys.select":      []string{"x", "space"},
+				"keys.down":        "down",
+			},
+			expect: func(t *testing.T, k keyMap, err error) {
+				t.Helper()
+				require.NoError(t, err)
+				require.Equal(t, []string{"ctrl+d", "f"}, k.ScrollDown.Keys())
+				require.Equal(t, "ctrl+d/f", k.ScrollDown.Help().Key)
+				require.Equal(t, []string{"x", " "}, k.Select.Keys())
+				require.Equal(t, "x/space", k.Select.Help().Key)
+				require.Equal(t, "scroll preview down", k.ScrollDown.Help().Desc)
+				require.True(t, key.Matches(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}}, k.Select))
+				require.Equal(t, "↓", k.Down.Help().Key)
+				// The defaults are left alone
+				require.Equal(t, []string{" "}, keys.Select.Keys())
+			},
+		},
+		{
+			name:      "conflict",
+			overrides: map[string]any{"keys.scroll-down": "j", "keys.copy": "y"},
+			expect: func(t *testing.T, _ keyMap, err error) {
+				t.Helper()
+				require.EqualError(t, err, "conflicting key bindings: j is bound to down and scroll-down; "+
+					"j is bound to scroll-down, which the apply screen takes for move down")
+			},
+		},
+		{
+			name:      "shadowed on a screen",
+			overrides: map[string]any{"keys.scroll-up": "a"},
+			expect: func(t *testing.T, _ keyMap, err error) {
+				t.Helper()
+				require.EqualError(t, err, "conflicting key bindings: a is bound to scroll-up, which the apply screen takes for accept all")
+			},
+		},
+		{
+			name:      "unknown action",
+			overrides: map[string]any{"keys.explode": "x"},
+			expect: func(t *testing.T, _ keyMap, err error) {
+				t.Helper()
+				require.ErrorContains(t, err, `unknown key binding action "explode"`)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			for name, value := range tt.overrides {
+				viper.Set(name, value)
+			}
+			k, err := loadKeyMap()
+			tt.expect(t, k, err)
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	doublestar "github.com/bmatcuk/doublestar/v4"
+)
+
+// ignoreFileNames are the per-directory ignore files, lowest precedence
+// first.
+var ignoreFileNames = []string{".gitignore", ".ignore", ".appenderignore"}
+
+// ignoreRule is a single pattern from an ignore file.
+type ignoreRule struct {
+	pattern string // doublestar pattern relative to the directory of the ignore file
+	negate  bool   // pattern started with "!" and re-includes matches
+	dirOnly bool   // pattern ended with "/" and only matches directories
+}
+
+// ignoreMatcher implements gitignore semantics for the tree walk: nested
+// ignore files, negation, directory-only patterns, .git/info/exclude and
+// the global excludes file.
+type ignoreMatcher struct {
+	cwd      string                  // used to make relative paths absolute
+	repoRoot string                  // absolute root of the enclosing git repository, if any
+	global   []ignoreRule            // core.excludesFile and .git/info/exclude, relative to repoRoot
+	dirs     map[string][]ignoreRule // rules from ignore files, keyed by absolute directory
+	workDir  string                  // absolute directory the extra rules are relative to
+	extra    []ignoreRule            // patterns from config, applied after the ignore files
+
+	// descendIgnored makes the walk enter ignored directories so that their
+	// contents can be shown.
+	descendIgnored bool
+}
+
+// newIgnoreMatcher prepares a matcher for a walk starting at workDir. Ignore
+// files in directories between the repository root and workDir are loaded
+// up front; those below workDir are loaded by loadDir during the walk.
+// Extra patterns, in gitignore format, are relative to workDir and take
+// precedence over the ignore files.
+func newIgnoreMatcher(workDir string, patterns ...string) *ignoreMatcher {
+	cwd, _ := os.Getwd()
+	im := &ignoreMatcher{
+		cwd:  cwd,
+		dirs: make(map[string][]ignoreRule),
+	}
+
+	absWorkDir := im.abs(workDir)
+	im.workDir = absWorkDir
+	for _, pattern := range patterns {
+		if rule, ok := parseIgnoreLine(pattern); ok {
+			im.extra = append(im.extra, rule)
+		}
+	}
+	im.repoRoot = findRepoRoot(absWorkDir)
+	if im.repoRoot != "" {
+		if path := globalExcludesFile(); path != "" {
+			im.global = append(im.global, readIgnoreFile(path)...)
+		}
+		im.global = append(im.global, readIgnoreFile(filepath.Join(im.repoRoot, ".git", "info", "exclude"))...)
+
+		for dir := filepath.Dir(absWorkDir); strings.HasPrefix(dir, im.repoRoot); dir = filepath.Dir(dir) {
+			im.loadDir(dir)
+			if dir == im.repoRoot || dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	return im
+}
+
+func (im *ignoreMatcher) abs(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(im.cwd, path)
+}
+
+// loadDir reads the ignore files in dir. It must be called for a directory
+// before its entries are checked with ignored.
+func (im *ignoreMatcher) loadDir(dir string) {
+	im.setRules(dir, readDirRules(im.abs(dir)))
+}
+
+// setRules stores the rules read from the ignore files in dir.
+func (im *ignoreMatcher) setRules(dir string, rules []ignoreRule) {
+	abs := im.abs(dir)
+	if len(rules) > 0 {
+		im.dirs[abs] = rules
+	} else {
+		delete(im.dirs, abs)
+	}
+}
+
+// readDirRules reads the ignore files in dir. It does not touch a matcher,
+// so the loader can call it from any goroutine.
+func readDirRules(dir string) []ignoreRule {
+	var rules []ignoreRule
+	for _, name := range ignoreFileNames {
+		rules = append(rules, readIgnoreFile(filepath.Join(dir, name))...)
+	}
+	return rules
+}
+
+// ignored reports whether path is excluded by the loaded rules. Like git,
+// it does not look at the parent directories of path: the walk never enters
+// an ignored directory, so their contents never reach this check.
+func (im *ignoreMatcher) ignored(path string, isDir bool) bool {
+	abs := im.abs(path)
+	if isDir && filepath.Base(abs) == ".git" {
+		return true
+	}
+
+	// Collect the directories that may hold rules for path, outermost first,
+	// so that deeper ignore files take precedence.
+	var ancestors []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		ancestors = append(ancestors, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	if im.repoRoot != "" {
+		ignored = matchRules(im.global, im.repoRoot, abs, isDir, ignored)
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if rules, ok := im.dirs[ancestors[i]]; ok {
+			ignored = matchRules(rules, ancestors[i], abs, isDir, ignored)
+		}
+	}
+	return matchRules(im.extra, im.workDir, abs, isDir, ignored)
+}
+
+// matchRules applies rules, defined in base, to path. The last matching
+// rule decides; current is returned when none match.
+func matchRules(rules []ignoreRule, base, path string, isDir, current bool) bool {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return current
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matched, err := doublestar.Match(rule.pattern, rel); err == nil && matched {
+			current = !rule.negate
+		}
+	}
+	return current
+}
+
+// readIgnoreFile parses a gitignore-format file. Missing files yield no
+// rules.
+func readIgnoreFile(path string) []ignoreRule {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	slog.Debug("loaded ignore file", "path", path, "rules", len(rules))
+	return rules
+}
+
+// parseIgnoreLine converts one line of a gitignore file into a rule.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	switch {
+	case strings.HasPrefix(line, "!"):
+		rule.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// A pattern without a slash matches at any depth below the ignore
+	// file; one with a slash is relative to it.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	rule.pattern = line
+
+	return rule, true
+}
+
+// findRepoRoot returns the nearest directory at or above dir that contains a
+// .git entry, or "" when dir is not inside a repository.
+func findRepoRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// globalExcludesFile locates git's core.excludesFile, defaulting to
+// $XDG_CONFIG_HOME/git/ignore.
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	xdgConfig := os.Getenv("XDG_CONFIG_HOME")
+	if xdgConfig == "" && home != "" {
+		xdgConfig = filepath.Join(home, ".config")
+	}
+
+	candidates := []string{}
+	if home != "" {
+		candidates = append(candidates, filepath.Join(home, ".gitconfig"))
+	}
+	if xdgConfig != "" {
+		candidates = append(candidates, filepath.Join(xdgConfig, "git", "config"))
+	}
+	for _, config := range candidates {
+		if path := readExcludesFileSetting(config); path != "" {
+			if strings.HasPrefix(path, "~/") && home != "" {
+				path = filepath.Join(home, path[2:])
+			}
+			return path
+		}
+	}
+
+	if xdgConfig == "" {
+		return ""
+	}
+	return filepath.Join(xdgConfig, "git", "ignore")
+}
+
+// readExcludesFileSetting extracts core.excludesFile from a git config file.
+func readExcludesFileSetting(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	inCore := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inCore = strings.EqualFold(strings.Trim(line, "[] \t"), "core")
+			continue
+		}
+		if !inCore {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "excludesfile") {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ignoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".git/HEAD":               "ref: refs/heads/main\n",
+		".git/info/exclude":       "secret.txt\n",
+		".gitignore":              "*.log\nbuild/\n/dist\nnode_modules\n!keep.log\n",
+		".appenderignore":         "docs/**/*.png\n",
+		"main.go":                 "package main\n",
+		"debug.log":               "",
+		"keep.log":                "",
+		"secret.txt":              "",
+		"build/out.bin":           "",
+		"dist/app.js":             "",
+		"src/dist/app.js":         "",
+		"src/build":               "a file, not a directory\n",
+		"src/.gitignore":          "generated.go\n!important.log\n",
+		"src/generated.go":        "",
+		"src/important.log":       "",
+		"src/other.log":           "",
+		"web/node_modules/x/y.js": "",
+		"docs/img/a.png":          "",
+		"docs/readme.md":          "",
+	}
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0o600))
+	}
+
+	m := &model{workDir: root}
+	require.NoError(t, m.buildFileTree())
+
+	expectIgnored := map[string]bool{
+		".git":              true,
+		"main.go":           false,
+		"debug.log":         true,
+		"keep.log":          false,
+		"secret.txt":        true,
+		"build":             true,
+		"dist":              true,
+		"src/dist":          false,
+		"src/build":         false,
+		"src/generated.go":  true,
+		"src/important.log": false,
+		"src/other.log":     true,
+		"web/node_modules":  true,
+		"docs/img/a.png":    true,
+		"docs/readme.md":    false,
+	}
+	for path, ignored := range expectIgnored {
+		node, ok := m.nodeLookup[filepath.Join(root, path)]
+		require.True(t, ok, "missing node %s", path)
+		require.Equal(t, ignored, node.ignored, path)
+	}
+
+	// Ignored directories are not walked until they are shown.
+	require.Empty(t, m.nodeLookup[filepath.Join(root, "build")].children)
+	m.showIgnored = true
+	require.NoError(t, m.buildFileTree())
+	require.Len(t, m.nodeLookup[filepath.Join(root, "build")].children, 1)
+	require.Len(t, m.nodeLookup[filepath.Join(root, "src")].children, 6, "rebuilding must not duplicate children")
+
+	t.Run("patterns from config", func(t *testing.T) {
+		m := &model{workDir: root, ignoreRules: []string{"docs/", "!other.log", "main.go"}}
+		require.NoError(t, m.buildFileTree())
+		for path, ignored := range map[string]bool{
+			"docs":          true,
+			"main.go":       true,
+			"src/other.log": false,
+			"debug.log":     true,
+		} {
+			require.Equal(t, ignored, m.nodeLookup[filepath.Join(root, path)].ignored, path)
+		}
+	})
+}
+
+func Test_parseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		ok     bool
+		expect ignoreRule
+	}{
+		{line: "", ok: false},
+		{line: "# comment", ok: false},
+		{line: `\#notcomment`, ok: true, expect: ignoreRule{pattern: "**/#notcomment"}},
+		{line: "*.log  ", ok: true, expect: ignoreRule{pattern: "**/*.log"}},
+		{line: "!keep.log", ok: true, expect: ignoreRule{pattern: "**/keep.log", negate: true}},
+		{line: "build/", ok: true, expect: ignoreRule{pattern: "**/build", dirOnly: true}},
+		{line: "/dist", ok: true, expect: ignoreRule{pattern: "dist"}},
+		{line: "a/**/b/", ok: true, expect: ignoreRule{pattern: "a/**/b", dirOnly: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			rule, ok := parseIgnoreLine(tt.line)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.expect, rule)
+		})
+	}
+}
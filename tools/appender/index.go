@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
+)
+
+// indexVersion is bumped when the meaning of the index changes, which
+// discards the indexes written before.
+const indexVersion = 3
+
+// fileMeta is what appender knows about a file without reading it again.
+// It holds as long as the size, modification time and mode of the file do.
+type fileMeta struct {
+	Size     int64     `json:"size"`
+	ModTime  int64     `json:"mtime"` // nanoseconds since the epoch
+	Mode     uint32    `json:"mode"`
+	Class    fileClass `json:"class,omitempty"` // as detected, before the extension lists of the config
+	Language string    `json:"lang,omitempty"`
+	Counted  bool      `json:"counted,omitempty"` // Lines and Tokens are set
+	Lines    int       `json:"lines,omitempty"`
+	Tokens   int       `json:"tokens,omitempty"`
+}
+
+// matches reports whether meta still describes the file info is about.
+func (meta fileMeta) matches(info os.FileInfo) bool {
+	return meta.Size == info.Size() &&
+		meta.ModTime == info.ModTime().UnixNano() &&
+		meta.Mode == uint32(info.Mode())
+}
+
+// metaIndex caches file metadata by path, so that classifying files and
+// counting tokens only reads files that changed. It is safe for concurrent
+// use, as tokens are counted off the UI goroutine. A nil index caches
+// nothing.
+type metaIndex struct {
+	mu        sync.Mutex
+	workDir   string
+	path      string // where the index is persisted, "" to keep it in memory
+	tokenizer string // the tokenizer the token counts are for
+	entries   map[string]fileMeta
+	seen      map[string]bool // entries looked up since the index was opened
+	dirty     bool
+}
+
+// indexFile is the on-disk form of a metaIndex. Paths are relative to the
+// working directory and slash separated.
+type indexFile struct {
+	Version   int                 `json:"version"`
+	Tokenizer string              `json:"tokenizer"`
+	Files     map[string]fileMeta `json:"files"`
+}
+
+// newMetaIndex returns an empty index kept in memory.
+func newMetaIndex(workDir, tokenizerName string) *metaIndex {
+	return &metaIndex{
+		workDir:   workDir,
+		tokenizer: tokenizerName,
+		entries:   make(map[string]fileMeta),
+		seen:      make(map[string]bool),
+	}
+}
+
+// indexPath returns where the index of workDir is stored.
+func indexPath(workDir string) (string, error) {
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return "", err
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "index", dirKey(absWorkDir)+".json"), nil
+}
+
+// openMetaIndex loads the index persisted for workDir. Token counts made
+// with another tokenizer are dropped. An index that cannot be read is
+// started afresh.
+func openMetaIndex(workDir, tokenizerName string) (*metaIndex, error) {
+	ix := newMetaIndex(workDir, tokenizerName)
+	path, err := indexPath(workDir)
+	if err != nil {
+		return ix, err
+	}
+	ix.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return ix, fmt.Errorf("reading index: %w", err)
+	}
+	var file indexFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return ix, fmt.Errorf("reading index %s: %w", path, err)
+	}
+	if file.Version != indexVersion {
+		return ix, nil
+	}
+	for rel, meta := range file.Files {
+		if file.Tokenizer != tokenizerName {
+			meta.Counted, meta.Lines, meta.Tokens = false, 0, 0
+		}
+		ix.entries[filepath.Join(workDir, filepath.FromSlash(rel))] = meta
+	}
+	return ix, nil
+}
+
+// save persists the entries looked up since the index was opened; those of
+// files that were not seen, most likely deleted, are dropped.
+func (ix *metaIndex) save() error {
+	if ix == nil || ix.path == "" {
+		return nil
+	}
+	ix.mu.Lock()
+	if !ix.dirty && len(ix.seen) == len(ix.entries) {
+		ix.mu.Unlock()
+		return nil
+	}
+	file := indexFile{Version: indexVersion, Tokenizer: ix.tokenizer, Files: make(map[string]fileMeta, len(ix.seen))}
+	for path := range ix.seen {
+		rel, err := filepath.Rel(ix.workDir, path)
+		if err != nil {
+			continue
+		}
+		file.Files[filepath.ToSlash(rel)] = ix.entries[path]
+	}
+	ix.mu.Unlock()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := writeStateFile(ix.path, data); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return nil
+}
+
+// lookup returns the metadata of the file at path, reading the file only
+// when the index has nothing current for it.
+func (ix *metaIndex) lookup(path string) (fileMeta, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileMeta{}, err
+	}
+	if ix != nil {
+		ix.mu.Lock()
+		meta, ok := ix.entries[path]
+		if ok && meta.matches(info) {
+			ix.seen[path] = true
+			ix.mu.Unlock()
+			return meta, nil
+		}
+		ix.mu.Unlock()
+	}
+
+	meta := fileMeta{
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Mode:     uint32(info.Mode()),
+		Language: languageFor(path),
+	}
+	if !info.IsDir() {
+		meta.Class = detectClass(path)
+	}
+	ix.store(path, meta)
+	return meta, nil
+}
+
+// setCounts records the line and token counts of the file meta is about.
+func (ix *metaIndex) setCounts(path string, meta fileMeta, lines, tokens int) {
+	meta.Counted, meta.Lines, meta.Tokens = true, lines, tokens
+	ix.store(path, meta)
+}
+
+func (ix *metaIndex) store(path string, meta fileMeta) {
+	if ix == nil {
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.entries[path] = meta
+	ix.seen[path] = true
+	ix.dirty = true
+}
+
+// loadMetaIndex returns the index of workDir for tok, kept in memory only
+// when inMemory is set. An index that cannot be read is replaced.
+func loadMetaIndex(workDir string, tok tokenizer.Tokenizer, inMemory bool) *metaIndex {
+	name := tokenizer.Estimate
+	if tok != nil {
+		name = tok.Name()
+	}
+	if inMemory {
+		return newMetaIndex(workDir, name)
+	}
+	ix, err := openMetaIndex(workDir, name)
+	if err != nil {
+		slog.Warn("starting a new index", "error", err)
+	}
+	return ix
+}
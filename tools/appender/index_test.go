@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_metaIndex(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go":    "package a\n",
+		"gone.go": "package a\n",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blob.dat"), []byte{0, 1, 2, 3}, 0o644))
+	path := filepath.Join(dir, "a.go")
+
+	ix, err := openMetaIndex(dir, "estimate")
+	require.NoError(t, err)
+	meta, err := ix.lookup(path)
+	require.NoError(t, err)
+	require.Equal(t, classText, meta.Class)
+	require.Equal(t, "go", meta.Language)
+	require.False(t, meta.Counted)
+	ix.setCounts(path, meta, 1, 3)
+
+	blob, err := ix.lookup(filepath.Join(dir, "blob.dat"))
+	require.NoError(t, err)
+	require.Equal(t, classBinary, blob.Class)
+	_, err = ix.lookup(filepath.Join(dir, "gone.go"))
+	require.NoError(t, err)
+	require.NoError(t, ix.save())
+
+	t.Run("cached", func(t *testing.T) {
+		ix, err := openMetaIndex(dir, "estimate")
+		require.NoError(t, err)
+		meta, err := ix.lookup(path)
+		require.NoError(t, err)
+		require.True(t, meta.Counted)
+		require.Equal(t, 3, meta.Tokens)
+	})
+
+	t.Run("tokenizer changed", func(t *testing.T) {
+		ix, err := openMetaIndex(dir, "cl100k")
+		require.NoError(t, err)
+		meta, err := ix.lookup(path)
+		require.NoError(t, err)
+		require.False(t, meta.Counted)
+		require.Equal(t, "go", meta.Language)
+	})
+
+	t.Run("file changed", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("package a\n\nfunc A() {}\n"), 0o644))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(path, later, later))
+		ix, err := openMetaIndex(dir, "estimate")
+		require.NoError(t, err)
+		meta, err := ix.lookup(path)
+		require.NoError(t, err)
+		require.False(t, meta.Counted)
+	})
+
+	t.Run("unseen entries are dropped", func(t *testing.T) {
+		ix, err := openMetaIndex(dir, "estimate")
+		require.NoError(t, err)
+		_, err = ix.lookup(path)
+		require.NoError(t, err)
+		require.NoError(t, ix.save())
+
+		ix, err = openMetaIndex(dir, "estimate")
+		require.NoError(t, err)
+		require.Len(t, ix.entries, 1)
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		m := &model{index: newMetaIndex(dir, "estimate"), hiddenClasses: map[fileClass]bool{classBinary: true}}
+		filter := m.classFilter()
+		node := &FileNode{path: filepath.Join(dir, "blob.dat")}
+		require.True(t, filter(node))
+		require.NotNil(t, node.meta)
+		require.True(t, filter(node))
+		require.False(t, filter(&FileNode{path: path}))
+	})
+}
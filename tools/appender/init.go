@@ -3,5 +3,16 @@ package main
 import tea "github.com/charmbracelet/bubbletea"
 
 func (m *model) Init() tea.Cmd {
-	return nil
+	if m.loader != nil {
+		cmds := []tea.Cmd{m.loader.wait(), m.loadGitStatusCmd(), m.startSpinner()}
+		if m.watcher != nil {
+			cmds = append(cmds, m.watcher.wait())
+		}
+		// Tokens are counted once the tree is complete
+		if m.loadsPending == 0 {
+			cmds = append(cmds, m.countTokensCmd())
+		}
+		return tea.Batch(cmds...)
+	}
+	return tea.Batch(m.countTokensCmd(), m.loadGitStatusCmd())
 }
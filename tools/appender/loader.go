@@ -0,0 +1,511 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// dirEntry is one entry of a directory listing.
+type dirEntry struct {
+	name   string
+	isDir  bool        // for symlinks, whether the target is a directory
+	link   string      // target of a symlink, as written in the link
+	target os.FileInfo // the file a symlink points to
+	err    error       // why the entry cannot be read, see readEntry
+}
+
+// dirListing is a directory read off the UI goroutine, with the rules of
+// the ignore files it contains. Listings are applied to the tree by
+// applyListing on the UI goroutine.
+type dirListing struct {
+	path      string
+	info      os.FileInfo // the directory itself, identifying it for loop detection
+	entries   []dirEntry
+	rules     []ignoreRule
+	err       error
+	recursive bool // the subtree was requested ahead of the background walk
+}
+
+// dirsLoadedMsg carries the listings the loader finished since the last
+// message.
+type dirsLoadedMsg struct {
+	listings []dirListing
+}
+
+// maxListingsPerMsg bounds how many listings are applied between redraws.
+const maxListingsPerMsg = 256
+
+// readListing reads the directory at path.
+func readListing(path string, recursive bool) dirListing {
+	listing := dirListing{path: path, recursive: recursive}
+	info, err := os.Stat(path)
+	if err != nil {
+		listing.err = err
+		return listing
+	}
+	listing.info = info
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		listing.err = err
+		return listing
+	}
+	listing.entries = make([]dirEntry, len(entries))
+	for i, entry := range entries {
+		listing.entries[i] = readEntry(path, entry)
+	}
+	listing.rules = readDirRules(path)
+	return listing
+}
+
+// loadJob asks the loader to read one directory.
+type loadJob struct {
+	path      string
+	recursive bool
+}
+
+// dirLoader reads directories with a bounded pool of workers. Jobs are
+// taken from the front of the queue, so directories the user is waiting on
+// can jump ahead of the background walk.
+type dirLoader struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []loadJob
+	stopped bool
+	results chan dirListing
+	done    chan struct{}
+}
+
+// newDirLoader starts a loader with one worker per CPU, at most eight.
+func newDirLoader() *dirLoader {
+	l := &dirLoader{
+		results: make(chan dirListing, maxListingsPerMsg),
+		done:    make(chan struct{}),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	for range min(runtime.NumCPU(), 8) {
+		go l.work()
+	}
+	return l
+}
+
+func (l *dirLoader) work() {
+	for {
+		l.mu.Lock()
+		for len(l.queue) == 0 && !l.stopped {
+			l.cond.Wait()
+		}
+		if l.stopped {
+			l.mu.Unlock()
+			return
+		}
+		job := l.queue[0]
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+
+		listing := readListing(job.path, job.recursive)
+		select {
+		case l.results <- listing:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// enqueue adds jobs to the back of the queue, or the front when urgent.
+func (l *dirLoader) enqueue(urgent bool, jobs ...loadJob) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if urgent {
+		l.queue = append(append([]loadJob{}, jobs...), l.queue...)
+	} else {
+		l.queue = append(l.queue, jobs...)
+	}
+	l.cond.Broadcast()
+}
+
+// stop ends the workers. Listings not yet delivered are dropped.
+func (l *dirLoader) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	l.cond.Broadcast()
+	close(l.done)
+}
+
+// wait returns a command delivering the next listings as a dirsLoadedMsg.
+func (l *dirLoader) wait() tea.Cmd {
+	return func() tea.Msg {
+		var msg dirsLoadedMsg
+		select {
+		case listing := <-l.results:
+			msg.listings = append(msg.listings, listing)
+		case <-l.done:
+			return nil
+		}
+		for len(msg.listings) < maxListingsPerMsg {
+			select {
+			case listing := <-l.results:
+				msg.listings = append(msg.listings, listing)
+			default:
+				return msg
+			}
+		}
+		return msg
+	}
+}
+
+// resetRoot creates the root node for workDir and the ignore matcher.
+func (m *model) resetRoot() error {
+	info, err := os.Stat(m.workDir)
+	if err != nil {
+		return err
+	}
+
+	if m.nodeLookup == nil {
+		m.nodeLookup = make(map[string]*FileNode)
+	}
+
+	m.rootNode = &FileNode{
+		name:     info.Name(),
+		path:     m.workDir,
+		info:     info,
+		isDir:    info.IsDir(),
+		isRoot:   true,
+		expanded: true,
+		selected: false,
+	}
+
+	if m.ignore == nil && !m.noIgnore {
+		m.ignore = newIgnoreMatcher(m.workDir, m.ignoreRules...)
+	}
+	if m.ignore != nil {
+		m.ignore.descendIgnored = m.showIgnored
+	}
+	return nil
+}
+
+// startLazyTree reads the top level of workDir and hands the rest of the
+// walk to a background loader, so the UI can start right away. Init starts
+// listening for its results.
+func (m *model) startLazyTree() error {
+	if err := m.resetRoot(); err != nil {
+		return err
+	}
+	listing := readListing(m.workDir, false)
+	m.nodeLookup[m.rootNode.path] = m.rootNode
+	m.loader = newDirLoader()
+	m.waiting = make(map[string]bool)
+	m.pendingSelect = make(map[*FileNode]bool)
+	m.queueLoads(m.applyListing(listing, false), false)
+	return nil
+}
+
+// applyListing adds the entries of a listing to the tree and returns the
+// directories to read next. Listings of directories that are already loaded
+// are dropped unless reload is set; a reload also forgets the nodes of
+// entries that are gone.
+func (m *model) applyListing(listing dirListing, reload bool) []*FileNode {
+	node, ok := m.nodeLookup[listing.path]
+	if listing.path == m.workDir {
+		node, ok = m.rootNode, true
+	}
+	if !ok || !node.isDir || (node.loaded && !reload) {
+		return nil
+	}
+	delete(m.waiting, node.path)
+	node.info = listing.info
+	node.err = listing.err
+	if listing.err != nil {
+		// One unreadable directory must not stop the walk
+		slog.Warn("reading directory", "path", listing.path, "error", listing.err)
+		if reload {
+			for _, child := range node.children {
+				m.forgetNode(child)
+			}
+		}
+		node.children = nil
+		node.loaded = true
+		return nil
+	}
+
+	if m.ignore != nil {
+		m.ignore.setRules(node.path, listing.rules)
+	}
+	if m.watcher != nil {
+		m.watcher.add(node.path)
+	}
+	previous := node.children
+	if reload {
+		// Entries may have been replaced, by an editor saving through a
+		// rename for instance
+		for _, child := range previous {
+			child.meta = nil
+		}
+	}
+	descend := addChildren(node, listing.entries, childIndent(node), m.followLinks, m.nodeLookup, m.ignore)
+	if reload {
+		current := make(map[*FileNode]bool, len(node.children))
+		for _, child := range node.children {
+			current[child] = true
+		}
+		for _, child := range previous {
+			if !current[child] {
+				m.forgetNode(child)
+			}
+		}
+	}
+
+	// Directories already read keep their children
+	unloaded := descend[:0]
+	for _, dir := range descend {
+		if !dir.loaded {
+			unloaded = append(unloaded, dir)
+		}
+	}
+	return unloaded
+}
+
+// forgetNode removes node and everything below it from the lookup.
+func (m *model) forgetNode(node *FileNode) {
+	if m.nodeLookup[node.path] == node {
+		delete(m.nodeLookup, node.path)
+	}
+	delete(m.waiting, node.path)
+	delete(m.pendingSelect, node)
+	for _, child := range node.children {
+		m.forgetNode(child)
+	}
+}
+
+// queueLoads has the loader read dirs; urgent ones, which the user is
+// waiting on, are read first and their whole subtree ahead of the rest.
+// Without a loader the directories are read right away.
+func (m *model) queueLoads(dirs []*FileNode, urgent bool) {
+	if len(dirs) == 0 {
+		return
+	}
+	if m.loader == nil {
+		for _, dir := range dirs {
+			m.queueLoads(m.applyListing(readListing(dir.path, urgent), false), urgent)
+		}
+		return
+	}
+	jobs := make([]loadJob, len(dirs))
+	for i, dir := range dirs {
+		jobs[i] = loadJob{path: dir.path, recursive: urgent}
+	}
+	m.loadsPending += len(jobs)
+	m.loader.enqueue(urgent, jobs...)
+}
+
+// loadSubtree synchronously reads every directory below node that the walk
+// would enter and has not been read yet.
+func (m *model) loadSubtree(node *FileNode) {
+	if !node.isDir {
+		return
+	}
+	if !node.loaded {
+		if m.ignore != nil && node.ignored && !m.ignore.descendIgnored {
+			return
+		}
+		m.applyListing(readListing(node.path, false), false)
+	}
+	for _, child := range node.children {
+		m.loadSubtree(child)
+	}
+}
+
+// subtreeLoaded reports whether every directory below node that the walk
+// would enter has been read.
+func (m *model) subtreeLoaded(node *FileNode) bool {
+	if !node.isDir {
+		return true
+	}
+	if !node.loaded {
+		return m.ignore != nil && node.ignored && !m.ignore.descendIgnored
+	}
+	for _, child := range node.children {
+		if !m.subtreeLoaded(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureLoaded synchronously reads the directories leading to path, and
+// path itself when it is a directory, so that its node exists if the file
+// does. The rest is left to the loader.
+func (m *model) ensureLoaded(path string) {
+	rel, err := filepath.Rel(m.workDir, path)
+	if m.rootNode == nil || err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+	node := m.rootNode
+	if rel != "." {
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			m.loadNow(node)
+			next, ok := m.nodeLookup[filepath.Join(node.path, part)]
+			if !ok {
+				return
+			}
+			node = next
+		}
+	}
+	m.loadNow(node)
+}
+
+// loadNow reads a directory that has not been read yet.
+func (m *model) loadNow(node *FileNode) {
+	if node.isDir && !node.loaded {
+		m.queueLoads(m.applyListing(readListing(node.path, false), false), false)
+	}
+}
+
+// reloadDir reads a directory again, picking up entries that were added or
+// removed. The closest loaded ancestor is read when dir itself is new.
+func (m *model) reloadDir(target string) {
+	m.ensureLoaded(target)
+	for dir := target; ; {
+		if node, ok := m.nodeLookup[dir]; ok && node.isDir && node.loaded {
+			m.queueLoads(m.applyListing(readListing(dir, false), true), false)
+			m.ensureLoaded(target)
+			return
+		}
+		if dir == m.workDir || dir == filepath.Dir(dir) {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// expandDir opens a directory, reading it first when the background walk
+// has not reached it yet.
+func (m *model) expandDir(node *FileNode) tea.Cmd {
+	node.expanded = true
+	if node.loaded {
+		return nil
+	}
+	if m.loader == nil {
+		m.loadSubtree(node)
+		return nil
+	}
+	m.waiting[node.path] = true
+	m.queueLoads([]*FileNode{node}, true)
+	return m.startSpinner()
+}
+
+// selectDir toggles the selection of a directory. A directory whose
+// subtree is still loading is selected once the loader has read it all.
+func (m *model) selectDir(node *FileNode) tea.Cmd {
+	if m.subtreeLoaded(node) {
+		m.toggleDirSelection(node)
+		return nil
+	}
+	if m.loader == nil {
+		m.loadSubtree(node)
+		m.toggleDirSelection(node)
+		return nil
+	}
+	m.pendingSelect[node] = !node.selected
+	m.waiting[node.path] = true
+	var dirs []*FileNode
+	var gather func(n *FileNode)
+	gather = func(n *FileNode) {
+		if !n.isDir || (m.ignore != nil && n.ignored && !m.ignore.descendIgnored) {
+			return
+		}
+		if !n.loaded {
+			dirs = append(dirs, n)
+			return
+		}
+		for _, child := range n.children {
+			gather(child)
+		}
+	}
+	gather(node)
+	m.queueLoads(dirs, true)
+	return m.startSpinner()
+}
+
+// loadIgnoredDirs has the loader read the ignored directories that were
+// skipped before ignored entries were shown.
+func (m *model) loadIgnoredDirs() {
+	var dirs []*FileNode
+	for _, node := range m.nodeLookup {
+		if node.isDir && node.ignored && !node.loaded {
+			dirs = append(dirs, node)
+		}
+	}
+	m.queueLoads(dirs, false)
+}
+
+// handleDirsLoaded applies listings from the loader, queues the directories
+// they reveal and finishes selections that were waiting on them.
+func (m *model) handleDirsLoaded(msg dirsLoadedMsg) tea.Cmd {
+	for _, listing := range msg.listings {
+		m.loadsPending--
+		m.queueLoads(m.applyListing(listing, false), listing.recursive)
+	}
+
+	changed := false
+	for node, selected := range m.pendingSelect {
+		if !m.subtreeLoaded(node) {
+			continue
+		}
+		delete(m.pendingSelect, node)
+		delete(m.waiting, node.path)
+		m.setDirSelection(node, selected, m.filters())
+		changed = true
+	}
+
+	m.flattenTree()
+	cmds := []tea.Cmd{m.loader.wait(), m.updateTree()}
+	if changed {
+		cmds = append(cmds, m.updateContent())
+	}
+	if m.loadsPending == 0 {
+		// The tree is complete: count tokens and refresh matches that may
+		// lie in directories read since the search
+		if m.findPattern.Value() != "" {
+			m.refreshMatches()
+		}
+		cmds = append(cmds, m.countTokensCmd())
+	}
+	return tea.Batch(cmds...)
+}
+
+// loading reports whether the loader has directories left to read.
+func (m *model) loading() bool {
+	return m.loadsPending > 0 || len(m.waiting) > 0
+}
+
+// startSpinner starts animating the loading indicators unless they already
+// are.
+func (m *model) startSpinner() tea.Cmd {
+	if m.spinning {
+		return nil
+	}
+	m.spinning = true
+	return m.spinner.Tick
+}
+
+// handleSpinnerTick advances the loading indicators until loading is done.
+func (m *model) handleSpinnerTick(msg spinner.TickMsg) tea.Cmd {
+	if !m.loading() {
+		m.spinning = false
+		return nil
+	}
+	var cmd tea.Cmd
+	m.spinner, cmd = m.spinner.Update(msg)
+	return tea.Batch(cmd, m.updateTree())
+}
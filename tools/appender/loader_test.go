@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lazyTree(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"top.go":            "package top\n",
+		"a/one.go":          "package a\n",
+		"a/b/two.go":        "package b\n",
+		"a/b/c/three.go":    "package c\n",
+		"a/b/c/d/four.go":   "package d\n",
+		"other/five.go":     "package other\n",
+		"locked/hidden.go":  "package locked\n",
+		"locked/sub/six.go": "package sub\n",
+	})
+
+	newModel := func(t *testing.T) *model {
+		t.Helper()
+		renderer, err := glamour.NewTermRenderer(glamour.WithStandardStyle("notty"))
+		require.NoError(t, err)
+		m := &model{workDir: dir, findPattern: initFindInput(), renderer: renderer}
+		require.NoError(t, m.startLazyTree())
+		t.Cleanup(m.loader.stop)
+		m.flattenTree()
+		return m
+	}
+	node := func(m *model, rel string) *FileNode {
+		return m.nodeLookup[filepath.Join(dir, filepath.FromSlash(rel))]
+	}
+	// drain applies loader results until nothing is left to read.
+	drain := func(t *testing.T, m *model) {
+		t.Helper()
+		for m.loadsPending > 0 {
+			msg, ok := m.loader.wait()().(dirsLoadedMsg)
+			require.True(t, ok)
+			m.handleDirsLoaded(msg)
+		}
+	}
+
+	t.Run("top level is read right away", func(t *testing.T) {
+		m := newModel(t)
+		require.NotNil(t, node(m, "top.go"))
+		require.NotNil(t, node(m, "a"))
+		require.True(t, m.loading())
+
+		drain(t, m)
+		require.NotNil(t, node(m, "a/b/c/d/four.go"))
+		require.True(t, m.subtreeLoaded(m.rootNode))
+		require.False(t, m.loading())
+	})
+
+	t.Run("selecting an unloaded directory selects its whole subtree", func(t *testing.T) {
+		m := newModel(t)
+		a := node(m, "a")
+		m.selectDir(a)
+		drain(t, m)
+
+		for _, rel := range []string{"a/one.go", "a/b/two.go", "a/b/c/three.go", "a/b/c/d/four.go"} {
+			require.True(t, node(m, rel).selected, rel)
+		}
+		require.False(t, node(m, "top.go").selected)
+		require.False(t, node(m, "other/five.go").selected)
+		require.Empty(t, m.pendingSelect)
+		require.Empty(t, m.waiting)
+	})
+
+	t.Run("ensureLoaded reads the directories leading to a path", func(t *testing.T) {
+		m := newModel(t)
+		m.ensureLoaded(filepath.Join(dir, "a", "b", "c", "d", "four.go"))
+		require.NotNil(t, node(m, "a/b/c/d/four.go"))
+		drain(t, m)
+	})
+
+	t.Run("reloadDir picks up added and removed files", func(t *testing.T) {
+		m := newModel(t)
+		drain(t, m)
+		writeFiles(t, dir, map[string]string{"a/b/new.go": "package b\n"})
+		require.NoError(t, os.Remove(filepath.Join(dir, "a", "b", "two.go")))
+		t.Cleanup(func() {
+			require.NoError(t, os.Remove(filepath.Join(dir, "a", "b", "new.go")))
+			writeFiles(t, dir, map[string]string{"a/b/two.go": "package b\n"})
+		})
+
+		m.reloadDir(filepath.Join(dir, "a", "b"))
+		drain(t, m)
+		require.NotNil(t, node(m, "a/b/new.go"))
+		require.Nil(t, node(m, "a/b/two.go"))
+		require.NotNil(t, node(m, "a/b/c/three.go"))
+	})
+
+	t.Run("unreadable directory does not stop the walk", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("permissions are not enforced")
+		}
+		locked := filepath.Join(dir, "locked")
+		require.NoError(t, os.Chmod(locked, 0o000))
+		t.Cleanup(func() { require.NoError(t, os.Chmod(locked, 0o755)) })
+
+		m := newModel(t)
+		drain(t, m)
+		require.True(t, node(m, "locked").loaded)
+		require.Empty(t, node(m, "locked").children)
+		require.NotNil(t, node(m, "a/b/c/d/four.go"))
+	})
+}
@@ -6,8 +6,10 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -24,9 +26,26 @@ func main() {
 		os.Exit(1)
 	}
 
+	args := os.Args[1:]
+	var command string
+	if len(args) > 0 && (args[0] == "pack" || args[0] == "review" || args[0] == "apply" || args[0] == "config") {
+		command, args = args[0], args[1:]
+	}
+
 	flags := pflag.NewFlagSet("appender", pflag.ExitOnError)
 	flags.IntP("logging", "l", 0, "Logging level (1=DEBUG, 2=INFO, 3=WARN, 4=ERROR)")
-	if err := flags.Parse(os.Args[1:]); err != nil {
+	flags.String("tokenizer", "", "Tokenizer used for token counts: estimate, or cl100k or o200k once their vocabulary is installed (default cl100k if installed, else estimate)")
+	flags.String("budget", "", "Token budget for the selection, e.g. 180k")
+	flags.String("model", "", "Target model whose context size sets the token budget")
+	flags.String("since", "", "Git ref; preselect files changed on this branch since it forked from the ref (D reselects)")
+	flags.String("content", string(contentFull), "What to export per file: full content, diff against --diff-ref, or both")
+	flags.String("diff-ref", "", "Ref diffs are taken against (default HEAD, or the merge base with --since)")
+	flags.Bool("fresh", false, "Start without restoring the previous session of the directory")
+	flags.Bool("no-watch", false, "Do not update the tree and preview when files change on disk")
+	flags.String("trim", "", "Fit selections over budget by dropping low-priority files (drop) or truncating the largest (truncate)")
+	addPackFlags(flags)
+	addApplyFlags(flags)
+	if err := flags.Parse(args); err != nil {
 		fmt.Printf("Error parsing flags: %v\n", err)
 		os.Exit(1)
 	}
@@ -35,30 +54,175 @@ func main() {
 		os.Exit(1)
 	}
 
+	// `appender review base..head [dir]` is `appender pack --review base..head [dir]`
+	positional := flags.Args()
+	if command == "review" {
+		if len(positional) == 0 {
+			fmt.Fprintln(os.Stderr, "Usage: appender review base..head [dir]")
+			os.Exit(1)
+		}
+		if err := flags.Set("review", positional[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		positional = positional[1:]
+	}
+	// `appender config show [dir]` prints the settings that apply in dir
+	if command == "config" {
+		if len(positional) == 0 || positional[0] != "show" {
+			fmt.Fprintln(os.Stderr, "Usage: appender config show [dir]")
+			os.Exit(1)
+		}
+		positional = positional[1:]
+	}
+	// `appender apply [response] [dir]` reads the response from a file, "-"
+	// for stdin, or the clipboard
+	var response string
+	if command == "apply" && len(positional) > 0 {
+		response, positional = positional[0], positional[1:]
+	}
 	workDir := "."
-	if flags.NArg() > 0 {
-		workDir = flags.Arg(0)
+	if len(positional) > 0 {
+		workDir = positional[0]
+	}
+
+	if err := config.LoadFiles(workDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if command == "config" {
+		if err := runConfigShow(flags, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	if err := setupLogging(); err != nil {
 		fmt.Printf("Error setting up logging: %v\n", err)
 		os.Exit(1)
 	}
+	if unknown := unknownConfigKeys(); len(unknown) > 0 {
+		slog.Warn("ignoring unknown config keys", "keys", unknown)
+	}
+
+	if command == "apply" {
+		opts := applyOptions{
+			workDir:   workDir,
+			input:     response,
+			dryRun:    viper.GetBool("dry-run"),
+			clipboard: viper.GetBool("clipboard"),
+			manifest:  viper.GetString("manifest"),
+			stale:     viper.GetString("stale"),
+		}
+		if opts.stale != staleAbort && opts.stale != staleMerge {
+			fmt.Fprintf(os.Stderr, "Error: unknown --stale value %q (available: abort, merge)\n", opts.stale)
+			os.Exit(1)
+		}
+		if opts.input == "" && !opts.clipboard && !term.IsTerminal(int(os.Stdin.Fd())) {
+			opts.input = "-"
+		}
+		text, err := readResponse(opts, os.Stdin, clipboard.ReadAll)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading response: %v\n", err)
+			os.Exit(1)
+		}
+		if err := runApply(opts, text, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	templates, err := loadTemplates(templateDirs(workDir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading output templates:\n%v\n", err)
+		os.Exit(1)
+	}
+	registerTemplates(templates)
+
+	// Without a terminal on stdout there is nothing to draw the UI on, so
+	// fall back to writing the bundle directly.
+	if command != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		slog.Info("starting headless export")
+		opts, err := packOptionsFromFlags(flags, workDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := runPack(opts, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	slog.Info("starting application")
 
+	format, err := formatFromFlags(flags)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Get terminal height and set window size to leave room for help text
 	w, h, _ := term.GetSize(int(os.Stdout.Fd())) //nolint:varnamelen
 	// Initialize glamour renderer
+	tok, err := newTokenizer(viper.GetString("tokenizer"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	budget, err := resolveBudget()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	trim, err := parseTrimStrategy(viper.GetString("trim"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	content, err := parseContentMode(viper.GetString("content"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	symlinks, err := parseSymlinkMode(viper.GetString("symlinks"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	hiddenClasses, err := parseFileClasses(viper.GetStringSlice("hide-classes"))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	review, err := reviewFromFlags(flags)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	bindings, err := loadKeyMap()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// The theme is a glamour style name or the path of a JSON style
 	renderer, err := glamour.NewTermRenderer(
-		glamour.WithAutoStyle(),
+		glamour.WithStylePath(viper.GetString("theme")),
 		glamour.WithWordWrap(80),
 	)
 	if err != nil {
-		fmt.Printf("Error creating renderer: %v\n", err)
+		fmt.Printf("Error creating renderer: theme %q: %v\n", viper.GetString("theme"), err)
 		os.Exit(1)
 	}
+	defaultOutput := viper.GetString("output")
+	if defaultOutput == "" || defaultOutput == "-" {
+		defaultOutput = "output.txt"
+	}
 	txtArea := textarea.New()
-	txtArea.SetValue("output.txt")
+	txtArea.SetValue(defaultOutput)
 	txtArea.ShowLineNumbers = false
 	txtArea.Placeholder = "Enter filename..."
 	txtArea.Focus()
@@ -70,8 +234,16 @@ func main() {
 			width:  w,
 			height: h - 2, // Leave space for help text,
 		},
-		renderer:     renderer,
-		removeHidden: true,
+		renderer:      renderer,
+		removeHidden:  !viper.GetBool("hidden"),
+		noIgnore:      viper.GetBool("no-ignore"),
+		ignoreRules:   viper.GetStringSlice("ignore"),
+		followLinks:   viper.GetBool("follow-symlinks"),
+		symlinks:      symlinks,
+		normalizeEOL:  viper.GetBool("normalize-eol"),
+		outlineAll:    viper.GetBool("outline-all"),
+		classifier:    classifierFromConfig(),
+		hiddenClasses: hiddenClasses,
 		leftViewport: viewport.New(
 			w/3-4, // Width (adjusted for borders and padding)
 			h-4,   // Height (adjusted for borders and padding)
@@ -81,32 +253,140 @@ func main() {
 			h-4,     // Height (adjusted for borders and padding)
 		),
 		outputPath:      txtArea,
-		keys:            keys,
+		defaultOutput:   defaultOutput,
+		format:          format,
+		tokenizer:       tok,
+		budget:          budget,
+		trim:            trim,
+		sinceRef:        viper.GetString("since"),
+		contentMode:     content,
+		diffRef:         viper.GetString("diff-ref"),
+		review:          review,
+		manifestPath:    viper.GetString("manifest"),
+		keys:            bindings,
 		help:            help.New(),
+		spinner:         spinner.New(spinner.WithSpinner(spinner.MiniDot)),
 		findPattern:     initFindInput(),
 		inFindMode:      false,
 		matchedNodes:    []*FileNode{},
 		currentMatchIdx: -1,
 	}
 
-	if err := initialModel.buildFileTree(); err != nil {
+	initialModel.index = loadMetaIndex(workDir, tok, viper.GetBool("no-index"))
+	if err := initialModel.startLazyTree(); err != nil {
 		fmt.Printf("Error building file tree: %v\n", err)
 		os.Exit(1)
 	}
+	if !viper.GetBool("no-watch") {
+		initialModel.startWatcher()
+	}
+
+	// Selections made by flags below replace the restored one
+	var restored *session
+	if !viper.GetBool("fresh") {
+		restored, err = readSession(workDir)
+		if err != nil {
+			slog.Warn("ignoring saved session", "error", err)
+			restored = nil
+		}
+		if restored != nil {
+			initialModel.restoreSession(*restored)
+		}
+	}
+
+	if initialModel.sinceRef != "" {
+		initialModel.notice = initialModel.selectGitChanges(initialModel.sinceRef)
+	}
+	if initialModel.review != nil {
+		initialModel.notice = initialModel.selectReview()
+	}
+	if name := viper.GetString("profile"); name != "" {
+		store, err := findProfileStore(workDir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		p, err := store.get(name)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		missing, err := initialModel.loadProfile(store, p)
+		if err != nil {
+			fmt.Printf("Error: profile %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		if flags.Changed("format") || flags.Changed("template") {
+			initialModel.format = format
+		}
+		initialModel.notice = profileNotice(name, len(initialModel.selectedFilePaths()), missing)
+	}
 
 	initialModel.flattenTree()
+	if restored != nil {
+		initialModel.placeCursor(restored.Cursor)
+	}
 
 	p := tea.NewProgram(initialModel, tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	initialModel.loader.stop()
+	if initialModel.watcher != nil {
+		initialModel.watcher.stop()
+	}
+	if err != nil {
 		fmt.Printf("Error running program: %v\n", err)
 		os.Exit(1)
 	}
+	if err := writeSession(workDir, initialModel.captureSession()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving session: %v\n", err)
+	}
+	if err := initialModel.index.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving index: %v\n", err)
+	}
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
+	case tokensCountedMsg:
+		if msg.files != nil {
+			m.applyFileTokenCounts(msg.counts, msg.files)
+		} else {
+			m.applyTokenCounts(msg.counts)
+		}
+		return m, m.updateTree()
+
+	case fsChangedMsg:
+		return m, m.handleFSChanges(msg)
+
+	case dirsLoadedMsg:
+		return m, m.handleDirsLoaded(msg)
+
+	case spinner.TickMsg:
+		return m, m.handleSpinnerTick(msg)
+
+	case gitStatusMsg:
+		if msg.err != nil {
+			// Not a repository, or git is unavailable: no markers
+			slog.Debug("git status unavailable", "error", msg.err)
+			return m, nil
+		}
+		m.gitMarkers = msg.markers
+		m.flattenTree()
+		m.cursor = min(m.cursor, max(len(m.flatNodes)-1, 0))
+		return m, m.updateTree()
+
+	case diffLoadedMsg:
+		m.showDiffLoaded(msg)
+		return m, nil
+
+	case previewLoadedMsg:
+		if msg.seq == m.previewSeq && !m.showDiff {
+			m.showPreview(msg.files, msg.git, msg.err)
+		}
+		return m, nil
+
 	// Handle the pattern changed message in the Update function
 	case findPatternChangedMsg:
 		if m.inFindMode {
@@ -131,10 +411,22 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 		)
 
 	case tea.KeyMsg:
+		if m.apply != nil {
+			return m.updateApply(msg)
+		}
+		if m.profiles != nil {
+			return m.updateProfiles(msg)
+		}
+		if m.diagnostics != nil {
+			return m.updateDiagnostics(msg)
+		}
+		if m.rangeSelect != nil {
+			return m.updateRanges(msg)
+		}
 		// Handle keys in find mode
 		if m.inFindMode {
-			switch msg.String() {
-			case tea.KeyEsc.String():
+			switch {
+			case key.Matches(msg, findKeys.Cancel):
 				// ESC completely exits find mode and clears highlighting
 				m.inFindMode = false
 				m.findPattern.Reset()
@@ -143,7 +435,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 				m.currentMatchIdx = -1
 				return m, m.updateTree()
 
-			case tea.KeyEnter.String():
+			case key.Matches(msg, findKeys.Accept):
 				// When Enter is pressed while the input is focused:
 				// 1. Perform the final search with current pattern
 				m.performFind()
@@ -156,6 +448,15 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 
 				// 3. Keep inFindMode true to preserve highlighting and n/N navigation
 
+				// A path:120-180 pattern selects those lines of the matches
+				_, ranges, err := m.findGlob()
+				switch {
+				case err != nil:
+					m.notice = err.Error()
+				case len(ranges) > 0:
+					m.notice = m.selectLineSpec(ranges)
+					return m, tea.Batch(m.updateTree(), m.updateContent())
+				}
 				return m, m.updateTree()
 			}
 
@@ -174,20 +475,41 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 			return m, nil
 		}
 		if m.showSaveModal {
-			switch msg.String() {
-			case tea.KeyEsc.String():
+			switch {
+			case key.Matches(msg, exportKeys.Cancel):
 				m.showSaveModal = false
+				m.saveError = nil
+				m.budgetConfirmed = false
 				m.outputPath.Reset()
-				m.outputPath.SetValue("output.txt")
+				m.outputPath.SetValue(m.defaultOutput)
 				return m, nil
-			case tea.KeyEnter.String():
+			case key.Matches(msg, exportKeys.Format):
+				m.format = nextFormat(m.outputFormat())
+				return m, m.updateContent()
+			case key.Matches(msg, exportKeys.Content):
+				m.contentMode = nextContentMode(m.contentMode)
+				return m, m.updateContent()
+			case key.Matches(msg, exportKeys.Save):
+				if m.needsBudgetConfirmation() && !m.budgetConfirmed {
+					m.budgetConfirmed = true
+					return m, nil
+				}
 				f, err := os.Create(m.outputPath.Value())
 				if err != nil {
 					slog.Error("Failed to create file", "error", err)
+					m.saveError = err
 					return m, nil
 				}
 				defer f.Close()
-				m.generateOutput(f)
+				files, err := m.generateOutput(f)
+				if err == nil {
+					err = m.writeManifest(files)
+				}
+				if err != nil {
+					slog.Error("Failed to write output", "error", err)
+					m.saveError = err
+					return m, nil
+				}
 				return m, tea.Quit
 			}
 			var cmd tea.Cmd
@@ -196,8 +518,12 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 		}
 
 		if m.showClipboardModal {
-			switch msg.String() {
-			case "y":
+			switch {
+			case key.Matches(msg, exportKeys.Copy):
+				if m.needsBudgetConfirmation() && !m.budgetConfirmed {
+					m.budgetConfirmed = true
+					return m, nil
+				}
 				err := m.copyToClipboard()
 				if err != nil {
 					m.clipboardError = err
@@ -205,9 +531,16 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 				}
 				m.showClipboardModal = false
 				return m, tea.Quit
-			case "n", tea.KeyEsc.String():
+			case key.Matches(msg, exportKeys.Format):
+				m.format = nextFormat(m.outputFormat())
+				return m, m.updateContent()
+			case key.Matches(msg, exportKeys.Content):
+				m.contentMode = nextContentMode(m.contentMode)
+				return m, m.updateContent()
+			case key.Matches(msg, exportKeys.Decline, exportKeys.Cancel):
 				m.showClipboardModal = false
 				m.clipboardError = nil
+				m.budgetConfirmed = false
 				return m, nil
 			default:
 				if m.clipboardError != nil {
@@ -218,68 +551,87 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 			}
 		}
 
+		m.notice = ""
+
 		if key.Matches(msg, m.keys.Help) {
 			m.help.ShowAll = !m.help.ShowAll
 			return m, nil
 		}
 
-		switch msg.String() {
-		case "/":
-			if !m.showSaveModal && !m.showClipboardModal {
-				m.inFindMode = true
-				m.findPattern.Reset()
-				m.findPattern.Focus()
-				m.matchedNodes = nil
-				m.currentMatchIdx = -1
+		k := m.keys
+		switch {
+		case key.Matches(msg, k.Find):
+			m.inFindMode = true
+			m.findPattern.Reset()
+			m.findPattern.Focus()
+			m.matchedNodes = nil
+			m.currentMatchIdx = -1
 
-				// Immediately update the tree to show the search bar
-				return m, m.updateTree()
-			}
-			fallthrough
+			// Immediately update the tree to show the search bar
+			return m, m.updateTree()
 
-		case "ctrl+c", "q":
+		case key.Matches(msg, k.Quit):
 			return m, tea.Quit
 
-		case "c":
-			slog.Info("pressing c")
+		case key.Matches(msg, k.Copy):
 			m.showClipboardModal = true
 			return m, nil
 
 		// Navigation keys are handled by handleKeyPress
-		case "up", "k", "down", "j", "pgup", "pgdown", "home", "end", "n", "N", tea.KeyEsc.String():
+		case key.Matches(msg, k.Up, k.Down, k.PageUp, k.PageDown, k.Top, k.Bottom, k.NextMatch, k.PrevMatch, k.ClearFind):
 			return m.handleKeyPress(msg)
 
 		// Right viewport scrolling
-		case "K":
+		case key.Matches(msg, k.ScrollUp):
 			m.rightViewport.HalfViewUp()
 
-		case "J":
+		case key.Matches(msg, k.ScrollDown):
 			m.rightViewport.HalfViewDown()
 
-		case "g":
+		case key.Matches(msg, k.ScrollTop):
 			m.rightViewport.GotoTop()
 
-		case "G":
+		case key.Matches(msg, k.ScrollEnd):
 			m.rightViewport.GotoBottom()
 
-		case " ":
+		case key.Matches(msg, k.Select):
 			currentNode := m.flatNodes[m.cursor]
+			if currentNode.deleted {
+				m.notice = fmt.Sprintf("cannot select %s: it was deleted", currentNode.name)
+				return m, nil
+			}
+			if !currentNode.selected && !checkReadable(currentNode) {
+				m.notice = fmt.Sprintf("cannot select %s: %s", currentNode.name, readError(currentNode.err))
+				return m, nil
+			}
+			if m.classOf(currentNode) == classBinary {
+				m.notice = fmt.Sprintf("cannot select %s: binary files are not exported", currentNode.name)
+				return m, nil
+			}
 			if currentNode.isDir {
-				m.toggleDirSelection(currentNode)
+				cmd = m.selectDir(currentNode)
 			} else {
 				currentNode.selected = !currentNode.selected
+				if !currentNode.selected {
+					m.setRanges(currentNode, nil)
+				}
 				m.nodeLookup[currentNode.path] = currentNode
 			}
 			// Update both tree and content after selection changes
 			return m, tea.Batch(
+				cmd,
 				m.updateTree(),
 				m.updateContent(),
 			)
 
-		case "l", "h":
+		case key.Matches(msg, k.ToggleDir):
 			currentNode := m.flatNodes[m.cursor]
 			if currentNode.isDir {
-				currentNode.expanded = !currentNode.expanded
+				if currentNode.expanded {
+					currentNode.expanded = false
+				} else {
+					cmd = m.expandDir(currentNode)
+				}
 				m.nodeLookup[currentNode.path] = currentNode
 				m.flattenTree()
 				// Adjust cursor if necessary after tree changes
@@ -287,18 +639,83 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) { //nolint:gocyclo
 					m.cursor = len(m.flatNodes) - 1
 				}
 			}
-			return m, m.updateTree()
+			return m, tea.Batch(cmd, m.updateTree())
 
-		case ".":
+		case key.Matches(msg, k.ToggleHide):
 			m.removeHidden = !m.removeHidden
+			m.aggregateTokens()
 			m.flattenTree()
 			return m, m.updateTree()
-		case tea.KeyEnter.String():
-			if !m.showSaveModal {
-				m.showSaveModal = true
-				m.outputPath.Focus()
+
+		case key.Matches(msg, k.ToggleBin, k.ToggleGen, k.ToggleMin, k.ToggleLock):
+			return m, m.toggleClass(k.toggledClass(msg))
+
+		case key.Matches(msg, k.ToggleDiff):
+			m.showDiff = !m.showDiff
+			return m, m.updateContent()
+
+		case key.Matches(msg, k.Apply):
+			return m, m.openApply()
+
+		case key.Matches(msg, k.Profiles):
+			return m, m.openProfiles()
+
+		case key.Matches(msg, k.Diagnose):
+			return m, m.openDiagnostics()
+
+		case key.Matches(msg, k.SelectLines):
+			return m, m.openRanges()
+
+		case key.Matches(msg, k.Inclusion):
+			return m, m.cycleInclusion(m.flatNodes[m.cursor])
+
+		case key.Matches(msg, k.GitMod, k.GitStaged, k.GitUntrack, k.GitSince, k.GitReview):
+			switch {
+			case key.Matches(msg, k.GitMod):
+				m.notice = m.selectGitChanges("", gitModified)
+			case key.Matches(msg, k.GitStaged):
+				m.notice = m.selectGitChanges("", gitStaged)
+			case key.Matches(msg, k.GitUntrack):
+				m.notice = m.selectGitChanges("", gitUntracked)
+			case key.Matches(msg, k.GitSince):
+				if m.sinceRef == "" {
+					m.notice = "start appender with --since <ref> to select changes since a ref"
+					return m, nil
+				}
+				m.notice = m.selectGitChanges(m.sinceRef)
+			case key.Matches(msg, k.GitReview):
+				if m.review == nil {
+					m.notice = "start appender with --review base..head to select a review range"
+					return m, nil
+				}
+				m.notice = m.selectReview()
+			}
+			m.flattenTree()
+			return m, tea.Batch(
+				m.updateTree(),
+				m.updateContent(),
+			)
+
+		case key.Matches(msg, k.ToggleIgn):
+			if m.ignore == nil {
 				return m, nil
 			}
+			m.showIgnored = !m.showIgnored
+			m.ignore.descendIgnored = m.showIgnored
+			// Ignored directories are not read until they are shown
+			if m.showIgnored {
+				m.loadIgnoredDirs()
+			}
+			m.flattenTree()
+			if m.loadsPending > 0 {
+				return m, tea.Batch(m.updateTree(), m.startSpinner())
+			}
+			return m, tea.Batch(m.updateTree(), m.countTokensCmd())
+
+		case key.Matches(msg, k.Save):
+			m.showSaveModal = true
+			m.outputPath.Focus()
+			return m, nil
 		}
 	}
 
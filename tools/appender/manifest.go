@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultManifest is the --manifest value given without a path. Exports
+// then record their manifest in the state directory, outside the working
+// tree, where apply picks it up automatically.
+const defaultManifest = "default"
+
+// manifest records the files of an export so that a later apply can tell
+// whether they changed in the meantime. Copies of the exported files are
+// kept as blobs next to the manifest to serve as the base of three-way
+// merges.
+type manifest struct {
+	Created time.Time       `json:"created"`
+	Files   []manifestEntry `json:"files"`
+
+	path string // where the manifest was read from or written to
+}
+
+// manifestEntry describes one exported file.
+type manifestEntry struct {
+	Path    string    `json:"path"` // relative to the working directory, slash separated
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// resolveManifestPath makes a manifest path relative to workDir, and maps
+// defaultManifest to the manifest of workDir in the state directory.
+func resolveManifestPath(workDir, path string) (string, error) {
+	switch {
+	case path == defaultManifest:
+		absWorkDir, err := filepath.Abs(workDir)
+		if err != nil {
+			return "", err
+		}
+		dir, err := stateDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "manifests", dirKey(absWorkDir), "manifest.json"), nil
+	case path == "" || filepath.IsAbs(path):
+		return path, nil
+	}
+	return filepath.Join(workDir, path), nil
+}
+
+// blobDir holds the copies of exported files for a manifest at path.
+func blobDir(path string) string {
+	return filepath.Join(filepath.Dir(path), "blobs")
+}
+
+// writeManifest records the files of an export at m.manifestPath. Entries
+// without a file on disk, such as the log of a review pack or deleted
+// files in a diff export, are skipped.
+func (m *model) writeManifest(files []bundleFile) error {
+	if m.manifestPath == "" {
+		return nil
+	}
+	path, err := resolveManifestPath(m.workDir, m.manifestPath)
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	blobs := blobDir(path)
+	if err := os.MkdirAll(blobs, 0o755); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	// Manifests given a path inside the repository would otherwise have
+	// their copies show up as untracked files
+	if err := ignoreAll(blobs); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	man := manifest{Created: time.Now().UTC()}
+	keep := make(map[string]bool)
+	for _, file := range files {
+		if file.AbsPath == "" || file.Status == statusDeleted {
+			continue
+		}
+		info, err := os.Stat(file.AbsPath)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(file.AbsPath)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		man.Files = append(man.Files, manifestEntry{
+			Path:    file.Path,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			SHA256:  hash,
+		})
+
+		keep[hash] = true
+		blob := filepath.Join(blobs, hash)
+		if _, err := os.Stat(blob); err == nil {
+			continue
+		}
+		if err := os.WriteFile(blob, data, 0o600); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	// Only the latest export can be applied against, so older blobs go
+	entries, err := os.ReadDir(blobs)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if !keep[entry.Name()] && entry.Name() != ".gitignore" {
+			_ = os.Remove(filepath.Join(blobs, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// ignoreAll writes a .gitignore that ignores everything in dir, unless dir
+// has one already.
+func ignoreAll(dir string) error {
+	path := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, []byte("*\n"), 0o644)
+}
+
+// readManifest loads the manifest at path. A missing manifest is not an
+// error when optional is set; nil is returned instead.
+func readManifest(path string, optional bool) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var man manifest
+	if err := json.Unmarshal(data, &man); err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", path, err)
+	}
+	man.path = path
+	return &man, nil
+}
+
+// entry returns the manifest entry for a relative path.
+func (man *manifest) entry(path string) (manifestEntry, bool) {
+	for _, entry := range man.Files {
+		if entry.Path == path {
+			return entry, true
+		}
+	}
+	return manifestEntry{}, false
+}
+
+// blob returns the exported contents of entry, decoded like the file they
+// are merged with.
+func (man *manifest) blob(entry manifestEntry) (string, error) {
+	data, err := os.ReadFile(filepath.Join(blobDir(man.path), entry.SHA256))
+	if err != nil {
+		return "", err
+	}
+	text, _ := decodeForEdit(data)
+	return text, nil
+}
+
+// changedSince reports whether the file at absPath differs from entry. The
+// size and modification time are checked first so unchanged files are not
+// hashed.
+func (entry manifestEntry) changedSince(absPath string) bool {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return true
+	}
+	if info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
+		return false
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return true
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) != entry.SHA256
+}
+
+// checkStale flags result when its file changed since the export and
+// prepares the three-way merge: the edits are applied to the exported
+// version, which is what the model saw.
+func (man *manifest) checkStale(result *applyResult, edit *fileEdit) {
+	entry, ok := man.entry(result.path)
+	if !ok || result.absPath == "" || !entry.changedSince(result.absPath) {
+		return
+	}
+	result.stale = true
+	result.accepted = false
+
+	base, err := man.blob(entry)
+	if err != nil {
+		return
+	}
+	outcome := applyEdit(base, true, edit)
+	if outcome.delete || len(outcome.conflicts) > 0 {
+		return
+	}
+	result.base = &base
+	result.theirs = outcome.content
+	result.theirsApplied = outcome.applied
+}
+
+// canMerge reports whether a stale result can be three-way merged.
+func (r *applyResult) canMerge() bool {
+	return r.stale && !r.merged && r.base != nil && r.exists
+}
+
+// merge three-way merges the edits made to the exported version into the
+// file as it is now. Overlapping changes are left as conflict markers in
+// the file and reported.
+func (r *applyResult) merge() error {
+	if !r.canMerge() {
+		return fmt.Errorf("%s cannot be merged: the exported version was not kept or the edits do not apply to it", r.path)
+	}
+	merged, conflicts, err := mergeFile(r.before, *r.base, r.theirs)
+	if err != nil {
+		return err
+	}
+	// The edits applied cleanly to the exported version, so only the
+	// merge itself can conflict now
+	r.after = merged
+	r.applied = r.theirsApplied
+	r.merged = true
+	r.accepted = true
+	r.conflicts = append([]string{}, r.problems...)
+	if conflicts > 0 {
+		r.conflicts = append(r.conflicts, fmt.Sprintf("merge conflicts marked in the file: %d", conflicts))
+	}
+	return nil
+}
+
+// mergeFile runs `git merge-file` on the three versions and returns the
+// result and the number of conflicts.
+func mergeFile(current, base, theirs string) (string, int, error) {
+	tmp, err := os.MkdirTemp("", "appender-merge-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(tmp)
+
+	names := []string{"current", "exported", "response"}
+	for i, content := range []string{current, base, theirs} {
+		if err := os.WriteFile(filepath.Join(tmp, names[i]), []byte(content), 0o600); err != nil {
+			return "", 0, err
+		}
+	}
+
+	cmd := exec.Command("git", "merge-file", "-p",
+		"-L", "current", "-L", "exported", "-L", "response",
+		"current", "exported", "response")
+	cmd.Dir = tmp
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// merge-file exits with the number of conflicts
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+			return string(out), exitErr.ExitCode(), nil
+		}
+		return "", 0, fmt.Errorf("git merge-file: %s", strings.TrimSpace(stderr.String()))
+	}
+	return string(out), 0, nil
+}
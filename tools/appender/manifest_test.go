@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applyWithManifest(t *testing.T) {
+	original := "package a\n\nfunc A() int {\n\treturn 1\n}\n\nfunc B() int {\n\treturn 2\n}\n"
+	// The model changes A in the version it was given
+	response := "a.go\n<<<<<<< SEARCH\n\treturn 1\n=======\n\treturn 10\n>>>>>>> REPLACE\n"
+
+	tests := []struct {
+		name      string
+		current   string // contents of a.go at apply time, "" for unchanged
+		stale     string
+		expectErr error
+		expect    string
+		report    string
+	}{
+		{
+			name:   "unchanged files apply",
+			stale:  staleAbort,
+			expect: strings.Replace(original, "return 1", "return 10", 1),
+		},
+		{
+			name:      "stale files abort",
+			current:   strings.Replace(original, "return 2", "return 20", 1),
+			stale:     staleAbort,
+			expectErr: errStale,
+			expect:    strings.Replace(original, "return 2", "return 20", 1),
+			report:    "a.go: modify, 1 applied, changed since export\n",
+		},
+		{
+			name:    "stale files merge",
+			current: strings.Replace(original, "return 2", "return 20", 1),
+			stale:   staleMerge,
+			expect:  strings.Replace(strings.Replace(original, "return 2", "return 20", 1), "return 1", "return 10", 1),
+			report:  "a.go: modify, 1 applied, changed since export and merged\n",
+		},
+		{
+			name:      "overlapping changes leave conflict markers",
+			current:   strings.Replace(original, "return 1", "return 100", 1),
+			stale:     staleMerge,
+			expectErr: errConflicts,
+			report:    "a.go: modify, 1 applied, changed since export and merged, 1 conflicts\n  ! merge conflicts marked in the file: 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath("git"); err != nil {
+				t.Skip("git not installed")
+			}
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.go": original})
+
+			var out strings.Builder
+			require.NoError(t, runPack(packOptions{workDir: dir, format: FormatPlain, manifest: defaultManifest}, &out))
+			path, err := resolveManifestPath(dir, defaultManifest)
+			require.NoError(t, err)
+			man, err := readManifest(path, false)
+			require.NoError(t, err)
+			require.Len(t, man.Files, 1)
+			require.Equal(t, "a.go", man.Files[0].Path)
+			require.Len(t, man.Files[0].SHA256, 64)
+
+			if tt.current != "" {
+				writeFiles(t, dir, map[string]string{"a.go": tt.current})
+			}
+
+			var stdout, stderr strings.Builder
+			err = runApply(applyOptions{workDir: dir, stale: tt.stale}, response, &stdout, &stderr)
+			if tt.expectErr != nil {
+				require.ErrorIs(t, err, tt.expectErr)
+			} else {
+				require.NoError(t, err, stderr.String())
+			}
+			if tt.report != "" {
+				require.Equal(t, tt.report, stderr.String())
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "a.go"))
+			require.NoError(t, err)
+			if tt.expect != "" {
+				require.Equal(t, tt.expect, string(data))
+			} else {
+				require.Contains(t, string(data), "<<<<<<< current")
+				require.Contains(t, string(data), ">>>>>>> response")
+			}
+		})
+	}
+}
+
+func Test_previewLeavesManifest(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n"})
+	renderer, err := glamour.NewTermRenderer(glamour.WithStandardStyle("notty"))
+	require.NoError(t, err)
+	m := &model{workDir: dir, renderer: renderer, manifestPath: defaultManifest}
+	require.NoError(t, m.buildFileTree())
+	m.toggleDirSelection(m.rootNode)
+	path, err := resolveManifestPath(dir, defaultManifest)
+	require.NoError(t, err)
+
+	// Previewing is not exporting
+	m.updateContent()
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err), "preview wrote the manifest")
+
+	var out strings.Builder
+	files, err := m.generateOutput(&out)
+	require.NoError(t, err)
+	require.NoError(t, m.writeManifest(files))
+	man, err := readManifest(path, false)
+	require.NoError(t, err)
+	require.Len(t, man.Files, 1)
+}
+
+func Test_manifestIsNotUntracked(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	dir := newTestRepo(t, map[string]string{"a.go": "package a\n"})
+	writeFiles(t, dir, map[string]string{"new.go": "package a\n"})
+	untracked := func() []string {
+		m := &model{workDir: dir}
+		require.NoError(t, m.buildFileTree())
+		paths, err := m.gitNodePaths("", gitUntracked)
+		require.NoError(t, err)
+		return paths
+	}
+
+	// The default manifest lives in the state directory
+	var out strings.Builder
+	require.NoError(t, runPack(packOptions{workDir: dir, format: FormatPlain, manifest: defaultManifest}, &out))
+	path, err := resolveManifestPath(dir, defaultManifest)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(path, state), path)
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "new.go")}, untracked())
+
+	// A manifest put in the repository shows up, but not the blobs next to it
+	require.NoError(t, runPack(packOptions{workDir: dir, format: FormatPlain, manifest: "out/manifest.json"}, &out))
+	require.Equal(t, []string{filepath.Join(dir, "new.go"), filepath.Join(dir, "out", "manifest.json")}, untracked())
+}
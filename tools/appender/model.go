@@ -5,17 +5,21 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
 )
 
 type model struct {
@@ -28,14 +32,58 @@ type model struct {
 	offset             int        // Starting index for the window
 	renderer           *glamour.TermRenderer
 	removeHidden       bool
+	showIgnored        bool           // show entries excluded by ignore files
+	noIgnore           bool           // do not read ignore files at all
+	ignore             *ignoreMatcher // nil when noIgnore is set
+	ignoreRules        []string       // gitignore-style patterns from config, relative to workDir
+	followLinks        bool           // enter symlinks to directories
+	symlinks           symlinkMode    // export selected symlinks as their target or as links
+	normalizeEOL       bool           // export CRLF line endings as LF
+	outlineAll         bool           // outlines include unexported declarations
 	leftViewport       viewport.Model
 	rightViewport      viewport.Model
 	showClipboardModal bool
 	clipboardError     error
 	showSaveModal      bool
+	saveError          error
 	outputPath         textarea.Model
+	defaultOutput      string // initial path in the save dialog
+	format             Format
+	tokenizer          tokenizer.Tokenizer
+	tokensCounted      bool
+	budget             int                // token budget for the selection, 0 for none
+	trim               trimStrategy       // how to fit a selection that exceeds budget
+	trimNotes          []string           // what the last export trimmed
+	budgetConfirmed    bool               // user agreed to export over budget
+	exportTotal        int                // tokens of the export, as of the last budget check
+	sinceRef           string             // git ref used by the "changed since" selection
+	notice             string             // result of the last command, shown in the status line
+	gitMarkers         map[string]byte    // git status marker by node path
+	contentMode        contentMode        // full content, diff or both for each exported file
+	review             *reviewRange       // export a review pack of this commit range
+	diffRef            string             // ref diffs are taken against; defaults to HEAD
+	includeGlobs       []string           // headless include globs, also used to pick deleted files
+	excludeGlobs       []string           // headless exclude globs
+	showDiff           bool               // right pane shows the git diff of the cursor file
+	apply              *applyScreen       // open while previewing a response to apply
+	profiles           *profileScreen     // open while managing selection profiles
+	diagnostics        *diagnosticsScreen // open while reviewing unreadable entries
+	rangeSelect        *rangeScreen       // open while marking the line ranges of a file
+	manifestPath       string             // record exports in this manifest, relative to workDir
+	previewSeq         int                // numbers previews so that only the latest is shown
 	keys               keyMap
 	help               help.Model
+	// Lazy loading of the tree, see loader.go
+	loader        *dirLoader
+	loadsPending  int                // directories queued but not applied yet
+	waiting       map[string]bool    // directories the user expanded or selected before they were read
+	pendingSelect map[*FileNode]bool // directory selections applied once their subtree is read
+	spinner       spinner.Model
+	spinning      bool
+	watcher       *fsWatcher         // nil when not watching for changes
+	index         *metaIndex         // file metadata; nil to read files every time
+	classifier    *classifier        // extension lists overriding the detected file classes
+	hiddenClasses map[fileClass]bool // classes of files left out of the tree
 	// Find mode related fields
 	inFindMode      bool
 	findPattern     textarea.Model
@@ -48,73 +96,207 @@ type windowSize struct {
 	width  int
 }
 
+// buildFileTree reads the whole tree under workDir. The interactive UI uses
+// startLazyTree instead.
 func (m *model) buildFileTree() error {
-	info, err := os.Stat(m.workDir)
-	if err != nil {
+	if err := m.resetRoot(); err != nil {
 		return err
 	}
-
-	if m.nodeLookup == nil {
-		m.nodeLookup = make(map[string]*FileNode)
-	}
-
-	m.rootNode = &FileNode{
-		name:     info.Name(),
-		path:     m.workDir,
-		isDir:    info.IsDir(),
-		isRoot:   true,
-		expanded: true,
-		selected: false,
-	}
-
-	err = visitNode(m.rootNode, "", m.removeHidden, m.nodeLookup)
-	return err
+	visitNode(m.rootNode, "", m.removeHidden, m.followLinks, m.nodeLookup, m.ignore)
+	return nil
 }
 
-func (m *model) flattenTree() {
+// filters returns the FilterFuncs that apply to the tree given the current
+// model settings.
+func (m *model) filters() []FilterFunc {
 	filters := make([]FilterFunc, 0)
 	if m.removeHidden {
 		filters = append(filters, FilterHidden)
 	}
-	filters = append(filters, FilterBinary)
-	m.flatNodes = m.rootNode.flatten(m.nodeLookup, filters...)
+	if !m.showIgnored {
+		filters = append(filters, FilterIgnored)
+	}
+	// Always applied, as it also finds the class badges shown in the tree
+	filters = append(filters, m.classFilter())
+	return filters
+}
+
+func (m *model) flattenTree() {
+	m.flatNodes = m.withDeleted(m.rootNode.flatten(m.nodeLookup, m.filters()...))
 }
 
+// toggleDirSelection flips the selection of a directory and applies it to
+// every descendant that is currently shown by the filters.
 func (m *model) toggleDirSelection(node *FileNode) {
-	node.selected = !node.selected
+	m.setDirSelection(node, !node.selected, m.filters())
+}
+
+func (m *model) setDirSelection(node *FileNode, selected bool, filters []FilterFunc) {
+	node.selected = selected
 	m.nodeLookup[node.path] = node
 	for _, child := range node.children {
+		// Binary files are only listed, they cannot be exported
+		if !include(child, filters...) || child.err != nil || m.classOf(child) == classBinary || (selected && !checkReadable(child)) {
+			continue
+		}
 		if child.isDir {
-			m.toggleDirSelection(child)
+			m.setDirSelection(child, selected, filters)
 		} else {
-			child.selected = node.selected
+			child.selected = selected
+			if !selected {
+				child.ranges = nil
+			}
 			m.nodeLookup[child.path] = child
 		}
 	}
 }
 
-func (m *model) generateOutput(w io.Writer) {
-	var output strings.Builder
-	m.collectSelectedFiles(m.rootNode, &output)
-
-	_, err := w.Write([]byte(output.String()))
+// generateOutput renders the selection to w and returns the files it holds.
+// It has no side effects, so that the preview can use it; exports record the
+// files in the manifest once they are written.
+func (m *model) generateOutput(w io.Writer) ([]bundleFile, error) {
+	files, err := m.bundleFiles()
 	if err != nil {
-		fmt.Printf("Error writing output file: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+	if err := m.writeBundle(w, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// bundleFiles collects the selected files, attaches diffs according to the
+// content mode, or builds a review pack when a review range is set, and,
+// when a trim strategy is set, trims them to the token budget. Notes about
+// trimmed files are kept in m.trimNotes for display.
+func (m *model) bundleFiles() ([]bundleFile, error) {
+	var files []bundleFile
+	m.collectSelectedFiles(m.rootNode, &files)
+
+	var git *gitContent
+	if req := m.gitRequest(files); req != nil {
+		var err error
+		if git, err = req.load(); err != nil {
+			return nil, err
+		}
+	}
+	return m.assembleBundle(files, git), nil
+}
+
+// assembleBundle is bundleFiles once the selected files are collected and
+// the git content they need, if any, is loaded.
+func (m *model) assembleBundle(files []bundleFile, git *gitContent) []bundleFile {
+	var header []bundleFile
+	if git != nil && git.review != nil {
+		header, files = m.reviewEntries(files, git)
+	} else {
+		files = m.attachDiffs(files, git)
+	}
+
+	// The review log and diff are never trimmed, so the files share what
+	// is left of the budget
+	budget := m.budget
+	for _, entry := range header {
+		if budget > 0 {
+			budget = max(budget-entry.Tokens, 1)
+		}
+	}
+	files, m.trimNotes = trimToBudget(files, budget, m.trim, m.countTokens)
+	for _, note := range m.trimNotes {
+		slog.Info("trimmed bundle", "note", note)
+	}
+	return append(header, files...)
+}
+
+// writeBundle renders files in the selected output format.
+func (m *model) writeBundle(w io.Writer, files []bundleFile) error {
+	format := m.outputFormat()
+	write, ok := formatters[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err := write(w, files); err != nil {
+		return fmt.Errorf("rendering %s output: %w", format, err)
+	}
+	return nil
+}
+
+// needsBudgetConfirmation reports whether exporting the current selection
+// would exceed the token budget. With a trim strategy the total after
+// trimming counts, as trimming does not always get under the budget: diffs
+// are never truncated, nor the log and diff of a review pack. The total is
+// kept in m.exportTotal for the warning.
+func (m *model) needsBudgetConfirmation() bool {
+	_, m.exportTotal = m.selectionStats()
+	if m.trim != trimNone && m.budget > 0 {
+		files, err := m.bundleFiles()
+		if err != nil {
+			// The export itself reports the error
+			return false
+		}
+		m.exportTotal = 0
+		for _, file := range files {
+			m.exportTotal += file.Tokens
+		}
+	}
+	return overBudget(m.exportTotal, m.budget)
+}
+
+// outputFormat returns the selected format, defaulting to FormatPlain.
+func (m *model) outputFormat() Format {
+	if m.format == "" {
+		return FormatPlain
 	}
+	return m.format
 }
 
-func (m *model) collectSelectedFiles(node *FileNode, output *strings.Builder) {
-	if node.selected && !node.isDir {
+func (m *model) collectSelectedFiles(node *FileNode, files *[]bundleFile) {
+	if node.selected && !node.isDir && node.link != "" && m.symlinks == symlinkLink {
 		relPath, _ := filepath.Rel(m.workDir, node.path)
-		content, err := os.ReadFile(node.path)
+		*files = append(*files, bundleFile{
+			AbsPath: node.path,
+			Path:    filepath.ToSlash(relPath),
+			Link:    node.link,
+		})
+	} else if node.selected && !node.isDir {
+		relPath, _ := filepath.Rel(m.workDir, node.path)
+		data, err := os.ReadFile(node.path)
 		if err == nil {
-			fmt.Fprintf(output, "# %s\n%s\n", relPath, string(content))
+			content, encoding := m.exportText(data)
+			content, inclusion := m.outlineContent(node.path, content, m.exportInclusion(node))
+			lines := ""
+			switch {
+			case inclusion == inclusionPath:
+				content, encoding = "", ""
+			case inclusion == inclusionFull && len(node.ranges) > 0:
+				partial, shown := extractRanges(content, node.ranges)
+				if len(shown) == 0 {
+					// The file shrank since the lines were selected
+					slog.Warn("selected lines are past the end of the file, exporting it in full",
+						"path", node.path, "lines", formatRanges(node.ranges))
+					break
+				}
+				content, lines = partial, rangeLabel(shown)
+			}
+			if inclusion == inclusionFull {
+				inclusion = ""
+			}
+			*files = append(*files, bundleFile{
+				AbsPath:   node.path,
+				Path:      filepath.ToSlash(relPath),
+				Language:  languageFor(node.path),
+				Size:      int64(len(content)),
+				Tokens:    m.countTokens(content),
+				Content:   content,
+				Encoding:  encoding,
+				Lines:     lines,
+				Inclusion: string(inclusion),
+			})
 		}
 	}
 
 	for _, child := range node.children {
-		m.collectSelectedFiles(child, output)
+		m.collectSelectedFiles(child, files)
 	}
 }
 
@@ -122,6 +304,25 @@ func (m *model) collectSelectedFiles(node *FileNode, output *strings.Builder) {
 func (m *model) getNodeDisplay(node *FileNode) string {
 	// Start with the standard string representation
 	display := node.String()
+	if m.waiting[node.path] {
+		display += " " + m.spinner.View()
+	}
+	if node.err != nil {
+		display += lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(" ✗ " + readError(node.err))
+	}
+	inclusion := node.inclusion
+	if !node.isDir {
+		inclusion = m.exportInclusion(node)
+	}
+	if node.selected && len(node.ranges) > 0 && inclusion != inclusionOutline && inclusion != inclusionPath {
+		display += lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Render(" [" + rangeLabel(node.ranges) + "]")
+	}
+	if inclusion == inclusionOutline || inclusion == inclusionPath {
+		display += lipgloss.NewStyle().Foreground(lipgloss.Color("141")).Render(" [" + inclusion.label() + "]")
+	}
+	if badge := m.classOf(node).badge(); badge != "" {
+		display += lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Render(" [" + badge + "]")
+	}
 
 	// Check if this node is a match
 	isMatch := false
@@ -164,22 +365,66 @@ func (m *model) getNodeDisplay(node *FileNode) string {
 
 		// Reconstruct the display string with highlighted name
 		display = prefix + highlightedName + suffix
+	} else if node.ignored {
+		// Ignored entries are only listed when toggled on; dim them
+		display = lipgloss.NewStyle().Faint(true).Render(display)
 	}
 
+	display += m.gitMarker(node)
+
 	return display
 }
 
-// Add this method to update content.
+// previewLoadedMsg carries the git content of a preview, loaded in the
+// background by updateContent.
+type previewLoadedMsg struct {
+	seq   int
+	files []bundleFile
+	git   *gitContent
+	err   error
+}
+
+// updateContent refreshes the right pane. Diff exports and review packs
+// need git, which can take a while, so their preview is rendered once
+// previewLoadedMsg arrives; until then the previous preview stays up.
 func (m *model) updateContent() tea.Cmd {
+	if m.showDiff {
+		return m.updateDiff()
+	}
+
+	var files []bundleFile
+	m.collectSelectedFiles(m.rootNode, &files)
+	m.previewSeq++
+	if req := m.gitRequest(files); req != nil {
+		seq := m.previewSeq
+		return func() tea.Msg {
+			git, err := req.load()
+			return previewLoadedMsg{seq: seq, files: files, git: git, err: err}
+		}
+	}
+	m.showPreview(files, nil, nil)
+	return nil
+}
+
+// showPreview renders files and their git content in the right pane.
+func (m *model) showPreview(files []bundleFile, git *gitContent, err error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	// Generate and render markdown content
-	m.generateOutput(buf)
-	renderedContent, err := m.renderer.Render(buf.String())
+	var renderedContent string
+	if err == nil {
+		err = m.writeBundle(buf, m.assembleBundle(files, git))
+	}
 	if err != nil {
+		renderedContent = fmt.Sprintf("Error generating output: %v", err)
+	} else if renderedContent, err = m.renderer.Render(previewMarkdown(m.outputFormat(), buf.String())); err != nil {
 		renderedContent = fmt.Sprintf("Error rendering content: %v", err)
 	}
 
+	if header := m.budgetHeader(); header != "" {
+		renderedContent = header + "\n" + renderedContent
+	}
+
 	// Reset viewport
 	m.rightViewport = viewport.New(
 		2*m.windowSize.width/3-4, // Width
@@ -189,10 +434,37 @@ func (m *model) updateContent() tea.Cmd {
 	// Set content and explicitly set viewport to top
 	m.rightViewport.SetContent(renderedContent)
 	m.rightViewport.YOffset = 0
-	return nil
 }
 
-const helpMsg = "\nPress space to select, l/h to expand/collapse directories, enter to generate output, q to quit\n"
+// budgetHeader renders the budget state shown above the preview.
+func (m *model) budgetHeader() string {
+	if m.budget == 0 {
+		return ""
+	}
+	_, tokens := m.selectionStats()
+	style := lipgloss.NewStyle().Bold(true).Padding(0, 1)
+	header := "Budget: " + budgetSummary(tokens, m.budget)
+	if overBudget(tokens, m.budget) {
+		style = style.Foreground(lipgloss.Color("196"))
+		if m.trim != trimNone {
+			header += fmt.Sprintf(" · trimming with %q", m.trim)
+		}
+	} else {
+		style = style.Foreground(lipgloss.Color("42"))
+	}
+
+	lines := []string{style.Render(header)}
+	for _, note := range m.trimNotes {
+		lines = append(lines, lipgloss.NewStyle().Faint(true).Padding(0, 1).Render(note))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// treeHint is shown below the tree, naming the keys as currently bound.
+func (m *model) treeHint() string {
+	return fmt.Sprintf("\nPress %s to select, %s to expand/collapse directories, %s to generate output, %s to quit\n",
+		m.keys.Select.Help().Key, m.keys.ToggleDir.Help().Key, m.keys.Save.Help().Key, m.keys.Quit.Help().Key)
+}
 
 func (m *model) updateTree() tea.Cmd {
 	var builder strings.Builder
@@ -212,13 +484,25 @@ func (m *model) updateTree() tea.Cmd {
 			Render(searchInfo) + "\n\n")
 	}
 
+	// Warn when the selection no longer fits the token budget
+	_, selectedTokens := m.selectionStats()
+	showBudgetWarning := overBudget(selectedTokens, m.budget)
+	if showBudgetWarning {
+		builder.WriteString(lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")).
+			Render(fmt.Sprintf("⚠ selection exceeds budget by %s tokens", tokenizer.Format(selectedTokens-m.budget))) + "\n\n")
+	}
+
 	// Calculate the actual visible height
 	// Subtract help message height and borders/padding
-	helpLines := len(strings.Split(helpMsg, "\n"))
+	helpLines := len(strings.Split(m.treeHint(), "\n"))
 	maxVisibleNodes := m.windowSize.height - helpLines - 2 // -2 for top/bottom borders
 	if m.inFindMode {
 		maxVisibleNodes -= 2 // Account for search line
 	}
+	if showBudgetWarning {
+		maxVisibleNodes -= 2 // Account for budget warning
+	}
 
 	// Ensure cursor stays within bounds
 	if m.cursor >= len(m.flatNodes) {
@@ -278,7 +562,7 @@ func (m *model) updateTree() tea.Cmd {
 		builder.WriteString("↓ more below\n")
 	}
 
-	builder.WriteString(helpMsg)
+	builder.WriteString(m.treeHint())
 
 	// Update viewport with new content
 	m.leftViewport = viewport.New(
@@ -297,61 +581,61 @@ func (m *model) updateTree() tea.Cmd {
 func (m *model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
-	switch msg.String() {
-	case "up", "k":
+	switch {
+	case key.Matches(msg, m.keys.Up):
 		if m.cursor > 0 {
 			m.cursor--
 			cmd = m.updateTree()
 		}
 
-	case "down", "j":
+	case key.Matches(msg, m.keys.Down):
 		if m.cursor < len(m.flatNodes)-1 {
 			m.cursor++
 			cmd = m.updateTree()
 		}
 
-	case "pgup":
+	case key.Matches(msg, m.keys.PageUp):
 		// Move cursor up by viewport height
-		visibleNodes := m.windowSize.height - len(strings.Split(helpMsg, "\n")) - 2
+		visibleNodes := m.windowSize.height - len(strings.Split(m.treeHint(), "\n")) - 2
 		m.cursor -= visibleNodes
 		if m.cursor < 0 {
 			m.cursor = 0
 		}
 		cmd = m.updateTree()
 
-	case "pgdown":
+	case key.Matches(msg, m.keys.PageDown):
 		// Move cursor down by viewport height
-		visibleNodes := m.windowSize.height - len(strings.Split(helpMsg, "\n")) - 2
+		visibleNodes := m.windowSize.height - len(strings.Split(m.treeHint(), "\n")) - 2
 		m.cursor += visibleNodes
 		if m.cursor >= len(m.flatNodes) {
 			m.cursor = len(m.flatNodes) - 1
 		}
 		cmd = m.updateTree()
 
-	case "home":
+	case key.Matches(msg, m.keys.Top):
 		m.cursor = 0
 		m.offset = 0
 		cmd = m.updateTree()
 
-	case "end":
+	case key.Matches(msg, m.keys.Bottom):
 		m.cursor = len(m.flatNodes) - 1
 		cmd = m.updateTree()
 
-	case "n":
+	case key.Matches(msg, m.keys.NextMatch):
 		// Allow n to work in normal mode (after find has been used)
 		if len(m.matchedNodes) > 0 {
 			m.nextMatch()
 			cmd = m.updateTree()
 		}
 
-	case "N":
+	case key.Matches(msg, m.keys.PrevMatch):
 		// Allow N to work in normal mode (after find has been used)
 		if len(m.matchedNodes) > 0 {
 			m.prevMatch()
 			cmd = m.updateTree()
 		}
 
-	case "esc":
+	case key.Matches(msg, m.keys.ClearFind):
 		// ESC completely exits find mode and clears highlighting
 		m.inFindMode = false
 		m.findPattern.Reset()
@@ -361,11 +645,22 @@ func (m *model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, m.updateTree()
 	}
 
+	// The diff pane follows the cursor
+	if m.showDiff {
+		cmd = tea.Batch(cmd, m.updateContent())
+	}
+
 	return m, cmd
 }
 
 func (m *model) copyToClipboard() error {
 	var output strings.Builder
-	m.collectSelectedFiles(m.rootNode, &output)
-	return clipboard.WriteAll(output.String())
+	files, err := m.generateOutput(&output)
+	if err != nil {
+		return err
+	}
+	if err := clipboard.WriteAll(output.String()); err != nil {
+		return err
+	}
+	return m.writeManifest(files)
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// inclusion is how much of a selected file is exported.
+type inclusion string
+
+const (
+	inclusionInherit inclusion = ""        // as the nearest directory above that sets one, full by default
+	inclusionFull    inclusion = "full"    // the whole file, or its selected line ranges
+	inclusionOutline inclusion = "outline" // the declarations and doc comments of a Go file, without function bodies
+	inclusionPath    inclusion = "path"    // the path alone
+)
+
+// inclusions is the order inclusions are cycled through in the UI.
+var inclusions = []inclusion{inclusionFull, inclusionOutline, inclusionPath}
+
+// label describes i in export headers and tree badges.
+func (i inclusion) label() string {
+	if i == inclusionPath {
+		return "path only"
+	}
+	return string(i)
+}
+
+// inclusionOf returns the inclusion set on node or, failing that, on the
+// nearest directory above it.
+func (m *model) inclusionOf(node *FileNode) inclusion {
+	for node.inclusion == inclusionInherit {
+		if node.isRoot || node.path == m.workDir {
+			return inclusionFull
+		}
+		parent, ok := m.nodeLookup[filepath.Dir(node.path)]
+		if !ok || parent == node {
+			return inclusionFull
+		}
+		node = parent
+	}
+	return node.inclusion
+}
+
+// exportInclusion is the inclusion a file is exported with: outlines only
+// apply to Go files, others are exported in full.
+func (m *model) exportInclusion(node *FileNode) inclusion {
+	i := m.inclusionOf(node)
+	if i == inclusionOutline && !isGoFile(node.path) {
+		return inclusionFull
+	}
+	return i
+}
+
+func isGoFile(path string) bool {
+	return filepath.Ext(path) == ".go"
+}
+
+// cycleInclusion moves node to the next inclusion. Setting it on a directory
+// applies it to everything below, replacing what was set there; files other
+// than Go files skip the outline.
+func (m *model) cycleInclusion(node *FileNode) tea.Cmd {
+	if node.deleted {
+		m.notice = node.name + " was deleted"
+		return nil
+	}
+	current := m.inclusionOf(node)
+	next := current
+	for i, mode := range inclusions {
+		if mode == current {
+			next = inclusions[(i+1)%len(inclusions)]
+		}
+	}
+	if next == inclusionOutline && !node.isDir && !isGoFile(node.path) {
+		next = inclusionPath
+	}
+	node.inclusion = next
+	if node.isDir {
+		var clear func(n *FileNode)
+		clear = func(n *FileNode) {
+			for _, child := range n.children {
+				child.inclusion = inclusionInherit
+				clear(child)
+			}
+		}
+		clear(node)
+	}
+	m.notice = fmt.Sprintf("%s: %s", node.name, next.label())
+	return tea.Batch(m.updateTree(), m.updateContent())
+}
+
+// applyInclusionGlobs sets inclusion on the selected files matched by globs.
+func (m *model) applyInclusionGlobs(globs []string, i inclusion) {
+	if len(globs) == 0 {
+		return
+	}
+	for path, node := range m.nodeLookup {
+		if !node.selected || node.isDir {
+			continue
+		}
+		if rel, ok := m.relToWorkDir(path); ok && matchesAny(globs, rel) {
+			node.inclusion = i
+		}
+	}
+}
+
+// exportTokens is the token count of what exporting node contributes, given
+// its inclusion and line ranges.
+func (m *model) exportTokens(node *FileNode) int {
+	switch m.exportInclusion(node) {
+	case inclusionPath:
+		return 0
+	case inclusionOutline:
+		if node.outlineTokens == 0 {
+			if content, err := m.outlineFile(node.path); err == nil {
+				node.outlineTokens = m.countTokens(content)
+			}
+		}
+		return node.outlineTokens
+	}
+	return node.selectTokens()
+}
+
+// outlineFile reads the Go file at path and returns its outline.
+func (m *model) outlineFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	content, _ := m.exportText(data)
+	return outlineGo(content, m.outlineAll)
+}
+
+// outlineGo returns the API surface of Go source: the package clause,
+// imports, type, constant and variable declarations, and function
+// signatures, each with its doc comment. Function bodies are left out, and
+// so are unexported declarations unless all is set.
+func outlineGo(src string, all bool) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return "", err
+	}
+
+	// Comments inside what is left out are not printed either
+	var dropped [][2]token.Pos
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if !all && !exportedFunc(decl) {
+				continue
+			}
+			if decl.Body != nil {
+				dropped = append(dropped, [2]token.Pos{decl.Body.Pos(), decl.Body.End()})
+				decl.Body = nil
+			}
+		case *ast.GenDecl:
+			if decl.Tok == token.IMPORT || all {
+				break
+			}
+			specs := decl.Specs[:0]
+			for _, spec := range decl.Specs {
+				if exportedSpec(spec) {
+					specs = append(specs, spec)
+				} else {
+					dropped = append(dropped, [2]token.Pos{specStart(spec), spec.End()})
+				}
+			}
+			if len(specs) == 0 {
+				continue
+			}
+			decl.Specs = specs
+		}
+		decls = append(decls, decl)
+	}
+
+	var b bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if file.Doc != nil {
+		b.WriteString(commentText(file.Doc))
+	}
+	fmt.Fprintf(&b, "package %s\n", file.Name.Name)
+	for _, decl := range decls {
+		start, end := decl.Pos(), decl.End()
+		if doc := declDoc(decl); doc != nil {
+			start = doc.Pos()
+		}
+		// A comment at the end of the last line belongs to the declaration
+		endLine := fset.Position(end).Line
+		var comments []*ast.CommentGroup
+		for _, group := range file.Comments {
+			inside := group.Pos() >= start && group.End() <= end
+			trailing := group.Pos() >= end && fset.Position(group.Pos()).Line == endLine
+			if (inside || trailing) && !within(group, dropped) {
+				comments = append(comments, group)
+			}
+		}
+		var printed bytes.Buffer
+		if err := cfg.Fprint(&printed, fset, &printer.CommentedNode{Node: decl, Comments: comments}); err != nil {
+			return "", err
+		}
+		b.WriteString("\n")
+		b.Write(bytes.TrimRight(printed.Bytes(), "\n"))
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// exportedFunc reports whether a function, or a method of an exported type,
+// is exported.
+func exportedFunc(decl *ast.FuncDecl) bool {
+	if !decl.Name.IsExported() {
+		return false
+	}
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return true
+	}
+	typ := decl.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t.IsExported()
+		default:
+			return true
+		}
+	}
+}
+
+// exportedSpec reports whether a type, constant or variable spec declares
+// an exported name.
+func exportedSpec(spec ast.Spec) bool {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Name.IsExported()
+	case *ast.ValueSpec:
+		for _, name := range spec.Names {
+			if name.IsExported() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// specStart is where spec begins, including its doc comment.
+func specStart(spec ast.Spec) token.Pos {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		if spec.Doc != nil {
+			return spec.Doc.Pos()
+		}
+	case *ast.ValueSpec:
+		if spec.Doc != nil {
+			return spec.Doc.Pos()
+		}
+	}
+	return spec.Pos()
+}
+
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		return decl.Doc
+	case *ast.GenDecl:
+		return decl.Doc
+	}
+	return nil
+}
+
+// within reports whether group lies inside one of ranges.
+func within(group *ast.CommentGroup, ranges [][2]token.Pos) bool {
+	for _, r := range ranges {
+		if group.Pos() >= r[0] && group.End() <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// commentText writes a comment group back as it appears in the source.
+func commentText(group *ast.CommentGroup) string {
+	var b strings.Builder
+	for _, c := range group.List {
+		b.WriteString(c.Text + "\n")
+	}
+	return b.String()
+}
+
+// outlineContent replaces content with its outline when inclusion asks for
+// one. Files that cannot be parsed are exported in full.
+func (m *model) outlineContent(path, content string, i inclusion) (string, inclusion) {
+	if i != inclusionOutline {
+		return content, i
+	}
+	outline, err := outlineGo(content, m.outlineAll)
+	if err != nil {
+		slog.Warn("outlining file, exporting it in full", "path", path, "error", err)
+		return content, inclusionFull
+	}
+	return outline, i
+}
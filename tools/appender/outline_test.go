@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/stretchr/testify/require"
+)
+
+const shapesSource = `// Package shapes draws shapes.
+package shapes
+
+import (
+	"fmt"
+	"math"
+)
+
+// Version is the package version.
+const Version = "1.0" // semver
+
+const (
+	// Pi is pi.
+	Pi = math.Pi
+	// tau is private.
+	tau = 2 * math.Pi
+)
+
+var registry = map[string]Shape{}
+
+// Shape is anything with an area.
+type Shape interface {
+	// Area returns the area.
+	Area() float64
+}
+
+// Circle is round.
+type Circle struct {
+	R float64 // radius
+}
+
+type point struct{ x, y int }
+
+// Area returns the area of c.
+func (c *Circle) Area() float64 {
+	// computed every time
+	return math.Pi * c.R * c.R
+}
+
+func (p point) String() string { return fmt.Sprint(p.x, p.y) }
+
+// New returns a circle.
+func New(r float64) *Circle { return &Circle{R: r} }
+
+func helper() {}
+`
+
+func Test_outlineGo(t *testing.T) {
+	outline, err := outlineGo(shapesSource, false)
+	require.NoError(t, err)
+	require.Equal(t, `// Package shapes draws shapes.
+package shapes
+
+import (
+	"fmt"
+	"math"
+)
+
+// Version is the package version.
+const Version = "1.0" // semver
+
+const (
+	// Pi is pi.
+	Pi = math.Pi
+)
+
+// Shape is anything with an area.
+type Shape interface {
+	// Area returns the area.
+	Area() float64
+}
+
+// Circle is round.
+type Circle struct {
+	R float64 // radius
+}
+
+// Area returns the area of c.
+func (c *Circle) Area() float64
+
+// New returns a circle.
+func New(r float64) *Circle
+`, outline)
+
+	all, err := outlineGo(shapesSource, true)
+	require.NoError(t, err)
+	require.Contains(t, all, "\t// tau is private.\n\ttau = 2 * math.Pi\n")
+	require.Contains(t, all, "\nvar registry = map[string]Shape{}\n")
+	require.Contains(t, all, "\nfunc (p point) String() string\n")
+	require.Contains(t, all, "\nfunc helper()\n")
+	require.NotContains(t, all, "computed every time")
+
+	_, err = outlineGo("package broken\nfunc {", false)
+	require.Error(t, err)
+}
+
+func Test_inclusions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"shapes/shapes.go":  shapesSource,
+		"shapes/README.md":  "# shapes\n",
+		"shapes/broken.go":  "package shapes\nfunc {\n",
+		"cmd/main.go":       "package main\n\nfunc main() {}\n",
+		"docs/big-notes.md": "notes\n",
+	})
+	renderer, err := glamour.NewTermRenderer(glamour.WithStandardStyle("notty"))
+	require.NoError(t, err)
+	m := &model{workDir: dir, renderer: renderer}
+	require.NoError(t, m.buildFileTree())
+	m.toggleDirSelection(m.rootNode)
+	node := func(rel string) *FileNode {
+		return m.nodeLookup[filepath.Join(dir, filepath.FromSlash(rel))]
+	}
+
+	// Directories pass their inclusion down; only Go files are outlined
+	m.cycleInclusion(node("shapes"))
+	require.Equal(t, "shapes: outline", m.notice)
+	require.Equal(t, inclusionOutline, m.exportInclusion(node("shapes/shapes.go")))
+	require.Equal(t, inclusionFull, m.exportInclusion(node("shapes/README.md")))
+	require.Contains(t, m.getNodeDisplay(node("shapes")), "[outline]")
+	require.Contains(t, m.getNodeDisplay(node("shapes/shapes.go")), "[outline]")
+	require.NotContains(t, m.getNodeDisplay(node("shapes/README.md")), "[outline]")
+	require.Less(t, m.exportTokens(node("shapes/shapes.go")), m.countTokens(shapesSource))
+
+	// Files skip the outline when it does not apply to them
+	m.cycleInclusion(node("docs/big-notes.md"))
+	require.Equal(t, inclusionPath, m.exportInclusion(node("docs/big-notes.md")))
+	require.Zero(t, m.exportTokens(node("docs/big-notes.md")))
+	m.cycleInclusion(node("docs/big-notes.md"))
+	require.Equal(t, inclusionFull, m.exportInclusion(node("docs/big-notes.md")))
+
+	// Setting a directory again replaces what was set below it
+	m.cycleInclusion(node("shapes/README.md"))
+	require.Equal(t, inclusionPath, m.exportInclusion(node("shapes/README.md")))
+	m.cycleInclusion(node("shapes"))
+	require.Equal(t, inclusionPath, m.exportInclusion(node("shapes/README.md")))
+	require.Equal(t, inclusionPath, m.exportInclusion(node("shapes/shapes.go")))
+
+	var files []bundleFile
+	m.collectSelectedFiles(m.rootNode, &files)
+	for _, file := range files {
+		if file.Path == "shapes/shapes.go" {
+			require.Equal(t, "path", file.Inclusion)
+			require.Empty(t, file.Content)
+			require.Zero(t, file.Tokens)
+		}
+	}
+}
+
+func Test_packInclusions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"shapes/shapes.go": shapesSource,
+		"shapes/broken.go": "package shapes\nfunc {\n",
+		"go.sum":           "example.com/x v1.0.0 h1:abc=\n",
+	})
+	outline, err := outlineGo(shapesSource, false)
+	require.NoError(t, err)
+
+	pack := func(format Format) string {
+		t.Helper()
+		var buf bytes.Buffer
+		opts := packOptions{workDir: dir, format: format, outline: []string{"shapes/**"}, pathOnly: []string{"go.sum"}}
+		require.NoError(t, runPack(opts, &buf))
+		return buf.String()
+	}
+
+	// Files that do not parse are exported in full
+	require.Equal(t, "# go.sum (path only)\n"+
+		"# shapes/broken.go\npackage shapes\nfunc {\n\n"+
+		"# shapes/shapes.go (outline)\n"+outline+"\n", pack(FormatPlain))
+
+	xml := pack(FormatXML)
+	require.Contains(t, xml, "<source>go.sum</source>\n<inclusion>path</inclusion>\n</document>")
+	require.Contains(t, xml, "<inclusion>outline</inclusion>\n<document_content>\n"+outline+"</document_content>")
+
+	require.Contains(t, pack(FormatMarkdown), "## `shapes/shapes.go`\n\n_outline_\n\n```go\n"+outline+"```\n")
+
+	var files []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(pack(FormatJSON)), &files))
+	require.Equal(t, "path", files[0]["inclusion"])
+	require.Equal(t, "", files[0]["content"])
+	require.Equal(t, "outline", files[2]["inclusion"])
+	require.Equal(t, outline, files[2]["content"])
+}
+
+func Test_applyRefusesOutline(t *testing.T) {
+	for _, format := range []Format{FormatXML, FormatMarkdown, FormatJSON, FormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"shapes.go": shapesSource})
+
+			var export bytes.Buffer
+			opts := packOptions{workDir: dir, format: format, outline: []string{"*.go"}}
+			require.NoError(t, runPack(opts, &export))
+
+			var stdout, stderr strings.Builder
+			err := runApply(applyOptions{workDir: dir}, export.String(), &stdout, &stderr)
+			require.ErrorIs(t, err, errConflicts)
+			require.Contains(t, stderr.String(), "whole file is an outline")
+
+			data, err := os.ReadFile(filepath.Join(dir, "shapes.go"))
+			require.NoError(t, err)
+			require.Equal(t, shapesSource, string(data))
+		})
+	}
+
+	// A path-only label refuses whatever follows it
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n"})
+	var stdout, stderr strings.Builder
+	err := runApply(applyOptions{workDir: dir}, "## `a.go`\n\n_path only_\n\n```go\n```\n", &stdout, &stderr)
+	require.ErrorIs(t, err, errConflicts)
+	require.Contains(t, stderr.String(), "whole file was exported as its path only")
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	doublestar "github.com/bmatcuk/doublestar/v4"
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// packOptions holds the settings for a headless export.
+type packOptions struct {
+	workDir   string
+	include   []string
+	lines     map[string][]lineRange // line ranges given with include globs, by glob
+	exclude   []string
+	output    string
+	format    Format
+	tokenizer string
+	budget    int
+	trim      trimStrategy
+	force     bool
+	noHidden  bool
+	noIgnore  bool
+	noIndex   bool               // do not persist file metadata between runs
+	follow    bool               // enter symlinks to directories
+	symlinks  symlinkMode        // export selected symlinks as their target or as links
+	ignore    []string           // extra gitignore-style patterns from config
+	hide      map[fileClass]bool // classes of files left out
+	eol       bool               // export CRLF line endings as LF
+	outline   []string           // globs of Go files exported as outlines
+	pathOnly  []string           // globs of files exported as their path alone
+	allDecls  bool               // outlines include unexported declarations
+	gitSets   []gitChangeSet     // restrict the selection to these git status sets
+	since     string             // restrict the selection to files changed since this ref
+	content   contentMode
+	diffRef   string
+	review    *reviewRange // build a review pack of this range
+	manifest  string       // record the export in this manifest
+	profile   *profile     // selection to start from instead of the include globs
+	profiles  *profileStore
+}
+
+// bareLineRange matches a line range on its own, as left by splitting a
+// path:120-180,200-210 include at its commas.
+var bareLineRange = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+// addPackFlags registers the flags that control headless selection and
+// output. They are shared by the pack subcommand and the top-level command
+// so that `appender --include '*.go' | pbcopy` behaves like `appender pack`.
+func addPackFlags(flags *pflag.FlagSet) {
+	flags.StringSliceP("include", "i", nil, "Glob of files to include, relative to the directory, optionally with line ranges as in path:120-180 (repeatable)")
+	flags.StringSliceP("exclude", "x", nil, "Glob of files to exclude, relative to the directory (repeatable)")
+	flags.StringP("output", "o", "", "Write the bundle to this file instead of stdout")
+	flags.StringP("format", "f", string(FormatPlain), "Output format (plain, xml, markdown, json, jsonl)")
+	flags.StringP("template", "t", "", "Name of a user-defined output template (overrides --format)")
+	flags.Bool("hidden", false, "Include hidden files and directories")
+	flags.Bool("no-ignore", false, "Do not respect .gitignore, .ignore and .appenderignore files")
+	flags.Bool("follow-symlinks", false, "Enter symlinks to directories; links that loop back are skipped")
+	flags.Bool("normalize-eol", false, "Export CRLF line endings as LF")
+	flags.StringSlice("outline", nil, "Glob of Go files to export as outlines: declarations and doc comments without function bodies (repeatable)")
+	flags.StringSlice("path-only", nil, "Glob of files to export as their path alone (repeatable)")
+	flags.Bool("outline-all", false, "Include unexported declarations in outlines")
+	flags.StringSlice("hide-classes", []string{string(classBinary)}, "Leave out files of these classes: binary, generated, minified, lockfile")
+	flags.Bool("no-index", false, "Do not keep file metadata and token counts between runs")
+	flags.String("symlinks", string(symlinkTarget), "Export selected symlinks as their target's content (target) or as the link itself (link)")
+	flags.Bool("modified", false, "Only include files modified in the git working tree")
+	flags.Bool("staged", false, "Only include files with staged changes")
+	flags.Bool("untracked", false, "Only include untracked files")
+	flags.Bool("force", false, "Write the bundle even if it exceeds the token budget")
+	flags.String("manifest", "", "Record the exported files with their SHA-256 in a manifest so apply can detect stale files; with apply, the manifest to check")
+	flags.Lookup("manifest").NoOptDefVal = defaultManifest
+	flags.String("profile", "", "Start from a saved selection profile (see .appender/profiles.yaml)")
+	flags.String("review", "", "Build a review pack of base..head: commit log, diff and the touched files at head")
+	flags.Bool("tests", false, "With --review, also include the tests paired with touched files")
+}
+
+// packOptionsFromFlags reads the pack flags back out of a parsed flag set.
+// Settings that can also come from config files or the environment are read
+// through viper, which layers them.
+func packOptionsFromFlags(flags *pflag.FlagSet, workDir string) (packOptions, error) {
+	opts := packOptions{
+		workDir:   workDir,
+		include:   viper.GetStringSlice("include"),
+		exclude:   viper.GetStringSlice("exclude"),
+		output:    viper.GetString("output"),
+		tokenizer: viper.GetString("tokenizer"),
+		noHidden:  !viper.GetBool("hidden"),
+		noIgnore:  viper.GetBool("no-ignore"),
+		follow:    viper.GetBool("follow-symlinks"),
+		noIndex:   viper.GetBool("no-index"),
+		eol:       viper.GetBool("normalize-eol"),
+		outline:   viper.GetStringSlice("outline"),
+		pathOnly:  viper.GetStringSlice("path-only"),
+		allDecls:  viper.GetBool("outline-all"),
+		ignore:    viper.GetStringSlice("ignore"),
+		diffRef:   viper.GetString("diff-ref"),
+		manifest:  viper.GetString("manifest"),
+	}
+
+	var err error
+	if opts.format, err = formatFromFlags(flags); err != nil {
+		return opts, err
+	}
+	if opts.budget, err = resolveBudget(); err != nil {
+		return opts, err
+	}
+	if opts.trim, err = parseTrimStrategy(viper.GetString("trim")); err != nil {
+		return opts, err
+	}
+	if opts.force, err = flags.GetBool("force"); err != nil {
+		return opts, err
+	}
+	for _, set := range []gitChangeSet{gitModified, gitStaged, gitUntracked} {
+		enabled, err := flags.GetBool(string(set))
+		if err != nil {
+			return opts, err
+		}
+		if enabled {
+			opts.gitSets = append(opts.gitSets, set)
+		}
+	}
+	if opts.since, err = flags.GetString("since"); err != nil {
+		return opts, err
+	}
+	if opts.content, err = parseContentMode(viper.GetString("content")); err != nil {
+		return opts, err
+	}
+	if opts.symlinks, err = parseSymlinkMode(viper.GetString("symlinks")); err != nil {
+		return opts, err
+	}
+	if opts.hide, err = parseFileClasses(viper.GetStringSlice("hide-classes")); err != nil {
+		return opts, err
+	}
+	if opts.review, err = reviewFromFlags(flags); err != nil {
+		return opts, err
+	}
+	name, err := flags.GetString("profile")
+	if err != nil {
+		return opts, err
+	}
+	if name != "" {
+		if opts.profiles, err = findProfileStore(opts.workDir); err != nil {
+			return opts, err
+		}
+		p, err := opts.profiles.get(name)
+		if err != nil {
+			return opts, err
+		}
+		opts.profile = &p
+		// Include globs from config would otherwise replace the profile
+		if !flags.Changed("include") {
+			opts.include = nil
+		}
+		// The profile's format applies unless one was given explicitly
+		if !flags.Changed("format") && !flags.Changed("template") {
+			format, err := profileFormat(p)
+			if err != nil {
+				return opts, fmt.Errorf("profile %s: %w", name, err)
+			}
+			if format != "" {
+				opts.format = format
+			}
+		}
+	}
+
+	if opts.include, opts.lines, err = splitIncludeSpecs(opts.include); err != nil {
+		return opts, err
+	}
+
+	for _, pattern := range slices.Concat(opts.include, opts.exclude, opts.outline, opts.pathOnly) {
+		if !doublestar.ValidatePattern(pattern) {
+			return opts, fmt.Errorf("invalid glob pattern %q", pattern)
+		}
+	}
+
+	return opts, nil
+}
+
+// formatFromFlags resolves the format and template settings to a
+// registered format. Templates must be registered before this is called.
+func formatFromFlags(flags *pflag.FlagSet) (Format, error) {
+	// A format given on the command line wins over a template from config
+	name := viper.GetString("template")
+	if flags.Changed("format") && !flags.Changed("template") {
+		name = ""
+	}
+	if name != "" {
+		if _, ok := formatters[Format(name)]; !ok {
+			return "", fmt.Errorf("unknown template %q: expected %s%s in .appender/templates or the user config directory", name, name, templateExt)
+		}
+		return Format(name), nil
+	}
+	return parseFormat(viper.GetString("format"))
+}
+
+// reviewFromFlags parses --review and --tests. It returns nil when no
+// review range was given.
+func reviewFromFlags(flags *pflag.FlagSet) (*reviewRange, error) {
+	value, err := flags.GetString("review")
+	if err != nil || value == "" {
+		return nil, err
+	}
+	rng, err := parseReviewRange(value)
+	if err != nil {
+		return nil, err
+	}
+	if rng.tests, err = flags.GetBool("tests"); err != nil {
+		return nil, err
+	}
+	return &rng, nil
+}
+
+// runPack builds the file tree for opts.workDir, selects files matching the
+// include/exclude globs and writes the bundle without starting the TUI.
+func runPack(opts packOptions, stdout io.Writer) error {
+	tok, err := newTokenizer(opts.tokenizer)
+	if err != nil {
+		return err
+	}
+	m := &model{
+		workDir:       opts.workDir,
+		removeHidden:  opts.noHidden,
+		noIgnore:      opts.noIgnore,
+		followLinks:   opts.follow,
+		symlinks:      opts.symlinks,
+		normalizeEOL:  opts.eol,
+		outlineAll:    opts.allDecls,
+		ignoreRules:   opts.ignore,
+		format:        opts.format,
+		tokenizer:     tok,
+		budget:        opts.budget,
+		trim:          opts.trim,
+		contentMode:   opts.content,
+		diffRef:       opts.diffRef,
+		sinceRef:      opts.since,
+		review:        opts.review,
+		manifestPath:  opts.manifest,
+		index:         loadMetaIndex(opts.workDir, tok, opts.noIndex),
+		classifier:    classifierFromConfig(),
+		hiddenClasses: opts.hide,
+	}
+	defer func() {
+		if err := m.index.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "appender: %v\n", err)
+		}
+	}()
+	if err := m.buildFileTree(); err != nil {
+		return fmt.Errorf("building file tree: %w", err)
+	}
+	if opts.profile != nil && len(opts.include) == 0 {
+		missing, err := m.loadProfile(opts.profiles, *opts.profile)
+		if err != nil {
+			return err
+		}
+		m.format = opts.format
+		for _, path := range missing {
+			fmt.Fprintf(os.Stderr, "appender: profile path no longer exists: %s\n", path)
+		}
+		m.deselectByGlobs(opts.exclude)
+	} else {
+		includes := opts.include
+		if len(includes) == 0 {
+			includes = []string{"**"}
+		}
+		m.includeGlobs, m.excludeGlobs = includes, opts.exclude
+		m.selectByGlobs(m.rootNode, includes, opts.exclude, m.filters())
+		m.applyLineSpecs(opts.lines)
+	}
+	if len(opts.gitSets) > 0 || opts.since != "" {
+		paths, err := m.gitNodePaths(opts.since, opts.gitSets...)
+		if err != nil {
+			return err
+		}
+		m.restrictSelection(paths)
+	}
+	if opts.review != nil {
+		paths, err := m.reviewNodePaths()
+		if err != nil {
+			return err
+		}
+		m.restrictSelection(paths)
+	}
+	m.applyInclusionGlobs(opts.outline, inclusionOutline)
+	m.applyInclusionGlobs(opts.pathOnly, inclusionPath)
+	// Files are opened when selected, so unreadable ones are known by now
+	for _, node := range m.unreadableNodes() {
+		fmt.Fprintf(os.Stderr, "appender: skipping %s: %s\n", m.diagnosticPath(node), readError(node.err))
+	}
+
+	files, err := m.bundleFiles()
+	if err != nil {
+		return err
+	}
+	for _, note := range m.trimNotes {
+		fmt.Fprintf(os.Stderr, "appender: %s\n", note)
+	}
+	total := 0
+	for _, file := range files {
+		total += file.Tokens
+	}
+	if overBudget(total, opts.budget) && !opts.force {
+		return fmt.Errorf("selection is %s tokens, over the %s budget: narrow the globs, use --trim or pass --force",
+			tokenizer.Format(total), tokenizer.Format(opts.budget))
+	}
+
+	var output strings.Builder
+	if err := m.writeBundle(&output, files); err != nil {
+		return err
+	}
+
+	w := stdout
+	if opts.output != "" && opts.output != "-" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if _, err := io.WriteString(w, output.String()); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return m.writeManifest(files)
+}
+
+// splitIncludeSpecs separates the line ranges of path:120-180 include specs,
+// which select only those lines of the files they match, from their globs.
+func splitIncludeSpecs(specs []string) ([]string, map[string][]lineRange, error) {
+	var globs []string
+	var lines map[string][]lineRange
+	last := "" // glob of the previous spec if it had ranges
+	for _, spec := range specs {
+		// The flag splits path:120-180,200 at the comma; 200 belongs to path
+		if last != "" && bareLineRange.MatchString(spec) {
+			ranges, err := parseLineRanges(spec)
+			if err != nil {
+				return nil, nil, err
+			}
+			lines[last] = append(lines[last], ranges...)
+			continue
+		}
+		last = ""
+		glob, ranges, err := splitLineSpec(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(ranges) > 0 {
+			if lines == nil {
+				lines = make(map[string][]lineRange)
+			}
+			lines[glob] = append(lines[glob], ranges...)
+			last = glob
+		}
+		globs = append(globs, glob)
+	}
+	return globs, lines, nil
+}
+
+// selectByGlobs marks every file below node whose path relative to workDir
+// matches one of the include globs and none of the exclude globs. Nodes
+// rejected by filters are skipped along with everything beneath them.
+func (m *model) selectByGlobs(node *FileNode, includes, excludes []string, filters []FilterFunc) {
+	if !node.isRoot && !include(node, filters...) {
+		return
+	}
+
+	if !node.isDir {
+		relPath, err := filepath.Rel(m.workDir, node.path)
+		if err != nil {
+			return
+		}
+		relPath = filepath.ToSlash(relPath)
+		node.selected = matchesAny(includes, relPath) && !matchesAny(excludes, relPath) && checkReadable(node)
+		m.nodeLookup[node.path] = node
+		return
+	}
+
+	for _, child := range node.children {
+		m.selectByGlobs(child, includes, excludes, filters)
+	}
+}
+
+// deselectByGlobs deselects the files whose path relative to workDir
+// matches one of excludes.
+func (m *model) deselectByGlobs(excludes []string) {
+	if len(excludes) == 0 {
+		return
+	}
+	for path, node := range m.nodeLookup {
+		if rel, err := filepath.Rel(m.workDir, path); err == nil && matchesAny(excludes, filepath.ToSlash(rel)) {
+			node.selected = false
+		}
+	}
+}
+
+// restrictSelection deselects every file that is not in paths.
+func (m *model) restrictSelection(paths []string) {
+	allowed := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		allowed[path] = true
+	}
+	for path, node := range m.nodeLookup {
+		if !node.isDir && !allowed[path] {
+			node.selected = false
+		}
+	}
+}
+
+// matchesAny reports whether relPath matches at least one of the patterns.
+func matchesAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if matched, err := doublestar.Match(pattern, relPath); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runPack(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   packOptions
+		expect func(t *testing.T, output string, err error)
+	}{
+		{
+			name: "include everything",
+			opts: packOptions{workDir: "testdata", noHidden: true},
+			expect: func(t *testing.T, output string, err error) {
+				t.Helper()
+				require.NoError(t, err)
+				require.Contains(t, output, "# banana.txt\n")
+				require.Contains(t, output, "# a/b/example.txt\n")
+				require.NotContains(t, output, ".hideme.txt")
+			},
+		},
+		{
+			name: "include and exclude",
+			opts: packOptions{
+				workDir:  "testdata",
+				include:  []string{"a/**"},
+				exclude:  []string{"**/example2.txt"},
+				noHidden: true,
+			},
+			expect: func(t *testing.T, output string, err error) {
+				t.Helper()
+				require.NoError(t, err)
+				require.Contains(t, output, "# a/b/example.txt\n")
+				require.Contains(t, output, "# a/e/x.txt\n")
+				require.NotContains(t, output, "example2.txt")
+				require.NotContains(t, output, "banana.txt")
+			},
+		},
+		{
+			name: "hidden files",
+			opts: packOptions{workDir: "testdata", include: []string{".hidden/*"}},
+			expect: func(t *testing.T, output string, err error) {
+				t.Helper()
+				require.NoError(t, err)
+				require.Contains(t, output, "# .hidden/.hideme.txt\n")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := runPack(tt.opts, &buf)
+			tt.expect(t, buf.String(), err)
+		})
+	}
+}
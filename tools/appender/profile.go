@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// profile is a named selection that can be restored later.
+type profile struct {
+	Selected []string `yaml:"selected,omitempty"` // files relative to the profile base, slash separated, as path:120-180 when partially selected
+	Include  []string `yaml:"include,omitempty"`  // globs relative to the profile base
+	Exclude  []string `yaml:"exclude,omitempty"`
+	Format   string   `yaml:"format,omitempty"`
+	Template string   `yaml:"template,omitempty"` // output template; wins over Format
+}
+
+// profileStore is the profiles file of a repository. Paths in its profiles
+// are relative to base, the repository root, so that profiles work from any
+// subdirectory.
+type profileStore struct {
+	path     string
+	base     string
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// Where new profiles files are created, set with profiles.location.
+const (
+	profilesInRepo = "repo" // .appender/profiles.yaml at the repository root
+	profilesInUser = "user" // the user config directory, keyed by repository root
+)
+
+// findProfileStore locates the profiles file for workDir: an existing
+// .appender/profiles.yaml at the repository root, an existing file in the
+// user config directory, or a new one where profiles.location says.
+func findProfileStore(workDir string) (*profileStore, error) {
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return nil, err
+	}
+	base := findRepoRoot(absWorkDir)
+	if base == "" {
+		base = absWorkDir
+	}
+
+	repoPath := filepath.Join(base, ".appender", "profiles.yaml")
+	userPath := ""
+	if configDir, err := os.UserConfigDir(); err == nil {
+		userPath = filepath.Join(configDir, "appender", "profiles", dirKey(base)+".yaml")
+	}
+
+	path := repoPath
+	switch {
+	case fileExists(repoPath):
+	case userPath != "" && fileExists(userPath):
+		path = userPath
+	case userPath != "" && viper.GetString("profiles.location") == profilesInUser:
+		path = userPath
+	}
+
+	store := &profileStore{path: path, base: base, Profiles: map[string]profile{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading profiles: %w", err)
+	}
+	if err := yaml.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("reading profiles %s: %w", path, err)
+	}
+	if store.Profiles == nil {
+		store.Profiles = map[string]profile{}
+	}
+	return store, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// save writes the profiles file.
+func (s *profileStore) save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("writing profiles: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0o644); err != nil {
+		return fmt.Errorf("writing profiles: %w", err)
+	}
+	return nil
+}
+
+// names returns the profile names in order.
+func (s *profileStore) names() []string {
+	names := make([]string, 0, len(s.Profiles))
+	for name := range s.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get returns the named profile or an error listing the known ones.
+func (s *profileStore) get(name string) (profile, error) {
+	p, ok := s.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("unknown profile %q in %s (available: %s)", name, s.path, strings.Join(s.names(), ", "))
+	}
+	return p, nil
+}
+
+// relPath converts a FileNode path to a path relative to the profile base.
+func (s *profileStore) relPath(nodePath string) (string, bool) {
+	abs, err := filepath.Abs(nodePath)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(s.base, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// nodePath converts a path relative to the profile base into a FileNode
+// path below workDir.
+func (s *profileStore) nodePath(workDir, relPath string) (string, bool) {
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absWorkDir, filepath.Join(s.base, filepath.FromSlash(relPath)))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(workDir, rel), true
+}
+
+// profileFiles resolves a profile against the tree: the FileNode paths it
+// selects, and the listed paths that are no longer in the tree or can no
+// longer be read.
+func (m *model) profileFiles(store *profileStore, p profile) (selected map[string]bool, missing []string) {
+	selected = make(map[string]bool)
+	if len(p.Include) > 0 {
+		// Patterns can match anywhere, so the whole tree is needed
+		m.loadSubtree(m.rootNode)
+		filters := m.filters()
+		for path, node := range m.nodeLookup {
+			if node.isDir || node.err != nil || !include(node, filters...) {
+				continue
+			}
+			if rel, ok := store.relPath(path); ok && matchesAny(p.Include, rel) && !matchesAny(p.Exclude, rel) {
+				selected[path] = true
+			}
+		}
+	}
+	for _, spec := range p.Selected {
+		rel, _, err := splitLineSpec(spec)
+		if err != nil {
+			missing = append(missing, spec)
+			continue
+		}
+		path, ok := store.nodePath(m.workDir, rel)
+		if ok {
+			m.ensureLoaded(path)
+		}
+		if node, found := m.nodeLookup[path]; !ok || !found || node.isDir || node.err != nil {
+			missing = append(missing, rel)
+			continue
+		}
+		selected[path] = true
+	}
+	return selected, missing
+}
+
+// profileRanges returns the line ranges of the files p selects partially,
+// by FileNode path.
+func (m *model) profileRanges(store *profileStore, p profile) map[string][]lineRange {
+	ranges := make(map[string][]lineRange)
+	for _, spec := range p.Selected {
+		rel, specRanges, err := splitLineSpec(spec)
+		if err != nil || len(specRanges) == 0 {
+			continue
+		}
+		if path, ok := store.nodePath(m.workDir, rel); ok {
+			ranges[path] = specRanges
+		}
+	}
+	return ranges
+}
+
+// selectedFilePaths returns the FileNode paths of the selected files.
+func (m *model) selectedFilePaths() map[string]bool {
+	selected := make(map[string]bool)
+	for path, node := range m.nodeLookup {
+		if node.selected && !node.isDir {
+			selected[path] = true
+		}
+	}
+	return selected
+}
+
+// loadProfile replaces the selection and format with those of p and returns
+// the listed paths that no longer exist.
+func (m *model) loadProfile(store *profileStore, p profile) ([]string, error) {
+	format, err := profileFormat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, missing := m.profileFiles(store, p)
+	for path, node := range m.nodeLookup {
+		node.selected = selected[path] && checkReadable(node)
+		node.ranges = nil
+	}
+	for path, ranges := range m.profileRanges(store, p) {
+		if selected[path] {
+			m.setRanges(m.nodeLookup[path], ranges)
+		}
+	}
+	for path := range selected {
+		m.ensureNodeVisible(m.nodeLookup[path])
+	}
+	if format != "" {
+		m.format = format
+	}
+	return missing, nil
+}
+
+// profileFormat resolves the format or template of a profile, "" if it sets
+// neither.
+func profileFormat(p profile) (Format, error) {
+	switch {
+	case p.Template != "":
+		if _, ok := formatters[Format(p.Template)]; !ok {
+			return "", fmt.Errorf("unknown template %q", p.Template)
+		}
+		return Format(p.Template), nil
+	case p.Format != "":
+		return parseFormat(p.Format)
+	}
+	return "", nil
+}
+
+// profileFromSelection captures the current selection as a profile. Globs
+// of the profile being replaced are kept: files they match are not listed
+// again, and matched files that are no longer selected are excluded.
+func (m *model) profileFromSelection(store *profileStore, previous profile) profile {
+	p := profile{Include: previous.Include, Exclude: slices.Clone(previous.Exclude)}
+	matched, _ := m.profileFiles(store, profile{Include: previous.Include, Exclude: previous.Exclude})
+	selected := m.selectedFilePaths()
+
+	for path := range selected {
+		// Partially selected files are listed even when a glob matches them
+		ranges := m.nodeLookup[path].ranges
+		if rel, ok := store.relPath(path); ok && (!matched[path] || len(ranges) > 0) {
+			p.Selected = append(p.Selected, lineSpec(rel, ranges))
+		}
+	}
+	for path := range matched {
+		if rel, ok := store.relPath(path); ok && !selected[path] {
+			p.Exclude = append(p.Exclude, escapeGlob(rel))
+		}
+	}
+	sort.Strings(p.Selected)
+	sort.Strings(p.Exclude[len(previous.Exclude):])
+
+	format := m.outputFormat()
+	if isBuiltinFormat(format) {
+		p.Format = string(format)
+	} else {
+		p.Template = string(format)
+	}
+	return p
+}
+
+// isBuiltinFormat reports whether format is one of appender's own formats
+// rather than a user template.
+func isBuiltinFormat(format Format) bool {
+	switch format {
+	case FormatPlain, FormatXML, FormatMarkdown, FormatJSON, FormatJSONL:
+		return true
+	}
+	return false
+}
+
+// escapeGlob quotes the glob metacharacters in a literal path.
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[]{}\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// profileDiff lists the files loading p would add to the current selection
+// ("+ path") and remove from it ("- path"), relative to workDir.
+func (m *model) profileDiff(store *profileStore, p profile) []string {
+	want, missing := m.profileFiles(store, p)
+	have := m.selectedFilePaths()
+
+	var lines []string
+	rel := func(path string) string {
+		r, err := filepath.Rel(m.workDir, path)
+		if err != nil {
+			return path
+		}
+		return filepath.ToSlash(r)
+	}
+	for path := range want {
+		if !have[path] {
+			lines = append(lines, "+ "+rel(path))
+		}
+	}
+	for path := range have {
+		if !want[path] {
+			lines = append(lines, "- "+rel(path))
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	for _, path := range missing {
+		lines = append(lines, "! "+path+" (missing)")
+	}
+	return lines
+}
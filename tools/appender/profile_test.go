@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_profiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := newTestRepo(t, map[string]string{
+		"cmd/main.go":      "package main\n",
+		"lib/a.go":         "package lib\n",
+		"lib/b.go":         "package lib\n",
+		"lib/b_test.go":    "package lib\n",
+		"docs/readme.md":   "# docs\n",
+		"docs/old-note.md": "old\n",
+	})
+	workDir := filepath.Join(dir, "lib")
+
+	newModel := func(t *testing.T, workDir string) *model {
+		t.Helper()
+		m := &model{workDir: workDir, removeHidden: true}
+		require.NoError(t, m.buildFileTree())
+		return m
+	}
+	selected := func(m *model) []string {
+		var paths []string
+		for path := range m.selectedFilePaths() {
+			rel, err := filepath.Rel(m.workDir, path)
+			require.NoError(t, err)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	store, err := findProfileStore(workDir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, ".appender", "profiles.yaml"), store.path)
+
+	// Save a selection made in a subdirectory; paths are kept relative to
+	// the repository root
+	m := newModel(t, workDir)
+	m.selectPaths([]string{filepath.Join(workDir, "a.go")})
+	m.format = FormatMarkdown
+	store.Profiles["core"] = m.profileFromSelection(store, profile{})
+	store.Profiles["docs"] = profile{Include: []string{"docs/**"}, Selected: []string{"cmd/main.go", "cmd/gone.go"}}
+	require.NoError(t, store.save())
+	require.Equal(t, profile{Selected: []string{"lib/a.go"}, Format: "markdown"}, store.Profiles["core"])
+
+	t.Run("load reports missing paths", func(t *testing.T) {
+		store, err := findProfileStore(dir)
+		require.NoError(t, err)
+		m := newModel(t, dir)
+		missing, err := m.loadProfile(store, store.Profiles["docs"])
+		require.NoError(t, err)
+		require.Equal(t, []string{"cmd/gone.go"}, missing)
+		require.Equal(t, []string{"cmd/main.go", "docs/old-note.md", "docs/readme.md"}, selected(m))
+	})
+
+	t.Run("diff against the current selection", func(t *testing.T) {
+		store, err := findProfileStore(dir)
+		require.NoError(t, err)
+		m := newModel(t, dir)
+		m.selectPaths([]string{filepath.Join(dir, "lib", "b.go"), filepath.Join(dir, "docs", "readme.md")})
+		require.Equal(t, []string{
+			"+ cmd/main.go",
+			"+ docs/old-note.md",
+			"- lib/b.go",
+			"! cmd/gone.go (missing)",
+		}, m.profileDiff(store, store.Profiles["docs"]))
+	})
+
+	t.Run("saving over a glob profile excludes deselected files", func(t *testing.T) {
+		store, err := findProfileStore(dir)
+		require.NoError(t, err)
+		m := newModel(t, dir)
+		_, err = m.loadProfile(store, store.Profiles["docs"])
+		require.NoError(t, err)
+		m.nodeLookup[filepath.Join(dir, "docs", "old-note.md")].selected = false
+
+		p := m.profileFromSelection(store, store.Profiles["docs"])
+		require.Equal(t, []string{"docs/**"}, p.Include)
+		require.Equal(t, []string{"docs/old-note.md"}, p.Exclude)
+		require.Equal(t, []string{"cmd/main.go"}, p.Selected)
+	})
+
+	t.Run("headless export", func(t *testing.T) {
+		store, err := findProfileStore(dir)
+		require.NoError(t, err)
+		core := store.Profiles["core"]
+		format, err := profileFormat(core)
+		require.NoError(t, err)
+
+		var out strings.Builder
+		opts := packOptions{workDir: dir, noHidden: true, format: format, profile: &core, profiles: store}
+		require.NoError(t, runPack(opts, &out))
+		require.Contains(t, out.String(), "lib/a.go")
+		require.Contains(t, out.String(), "```go\npackage lib\n```")
+		require.NotContains(t, out.String(), "main.go")
+	})
+
+	t.Run("user config location", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, ".appender")))
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		viper.Set("profiles.location", profilesInUser)
+		t.Cleanup(func() { viper.Set("profiles.location", "") })
+		store, err := findProfileStore(dir)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(store.path, os.Getenv("XDG_CONFIG_HOME")), store.path)
+	})
+}
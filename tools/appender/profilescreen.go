@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// profileScreen lists the saved selection profiles and shows how each
+// differs from the current selection.
+type profileScreen struct {
+	store         *profileStore
+	names         []string
+	cursor        int
+	naming        bool           // the name input for saving is focused
+	name          textarea.Model // name of the profile to save
+	confirmDelete bool           // x was pressed once
+	err           error
+}
+
+// profileKeyMap lists the keys of the profile screen for the help view.
+type profileKeyMap struct {
+	Up, Down, Load, Save, Delete, Close key.Binding
+}
+
+func (k profileKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Load, k.Save, k.Delete, k.Close}
+}
+
+func (k profileKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Up, k.Down}, {k.Load, k.Save, k.Delete}, {k.Close}}
+}
+
+var profileKeys = profileKeyMap{
+	Up:     key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "move up")),
+	Down:   key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "move down")),
+	Load:   key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "load profile")),
+	Save:   key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "save selection")),
+	Delete: key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "delete profile")),
+	Close:  key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "close")),
+}
+
+// openProfiles shows the profile screen.
+func (m *model) openProfiles() tea.Cmd {
+	name := textarea.New()
+	name.ShowLineNumbers = false
+	name.Placeholder = "profile name"
+	name.SetHeight(1)
+	name.CharLimit = 64
+
+	screen := &profileScreen{name: name}
+	m.profiles = screen
+	store, err := findProfileStore(m.workDir)
+	if err != nil {
+		screen.err = err
+		store = &profileStore{Profiles: map[string]profile{}}
+	}
+	screen.store = store
+	screen.names = store.names()
+	return m.updateProfilePanes()
+}
+
+// updateProfiles handles keys while the profile screen is open.
+func (m *model) updateProfiles(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	screen := m.profiles
+	if screen.naming {
+		switch msg.String() {
+		case "esc":
+			screen.naming = false
+			screen.name.Blur()
+		case "enter":
+			name := strings.TrimSpace(screen.name.Value())
+			if name == "" {
+				return m, nil
+			}
+			screen.naming = false
+			screen.name.Blur()
+			screen.err = m.saveProfile(name)
+			screen.names = screen.store.names()
+			for i, n := range screen.names {
+				if n == name {
+					screen.cursor = i
+				}
+			}
+		default:
+			var cmd tea.Cmd
+			screen.name, cmd = screen.name.Update(msg)
+			return m, tea.Batch(cmd, m.updateProfilePanes())
+		}
+		return m, m.updateProfilePanes()
+	}
+
+	if !key.Matches(msg, profileKeys.Delete) {
+		screen.confirmDelete = false
+	}
+	switch {
+	case key.Matches(msg, profileKeys.Close):
+		m.profiles = nil
+		return m, tea.Batch(m.updateTree(), m.updateContent())
+	case key.Matches(msg, profileKeys.Up):
+		if screen.cursor > 0 {
+			screen.cursor--
+		}
+	case key.Matches(msg, profileKeys.Down):
+		if screen.cursor < len(screen.names)-1 {
+			screen.cursor++
+		}
+	case key.Matches(msg, profileKeys.Save):
+		screen.naming = true
+		screen.name.Reset()
+		if name := screen.current(); name != "" {
+			screen.name.SetValue(name)
+		}
+		screen.name.Focus()
+	case key.Matches(msg, profileKeys.Delete):
+		name := screen.current()
+		if name == "" {
+			break
+		}
+		if !screen.confirmDelete {
+			screen.confirmDelete = true
+			break
+		}
+		screen.confirmDelete = false
+		delete(screen.store.Profiles, name)
+		screen.err = screen.store.save()
+		screen.names = screen.store.names()
+		screen.cursor = min(screen.cursor, max(len(screen.names)-1, 0))
+	case key.Matches(msg, profileKeys.Load):
+		name := screen.current()
+		if name == "" {
+			break
+		}
+		missing, err := m.loadProfile(screen.store, screen.store.Profiles[name])
+		if err != nil {
+			screen.err = err
+			break
+		}
+		m.notice = profileNotice(name, len(m.selectedFilePaths()), missing)
+		m.profiles = nil
+		m.flattenTree()
+		return m, tea.Batch(m.updateTree(), m.updateContent())
+	}
+	return m, m.updateProfilePanes()
+}
+
+// saveProfile stores the current selection as name, keeping the globs of a
+// profile it replaces.
+func (m *model) saveProfile(name string) error {
+	store := m.profiles.store
+	store.Profiles[name] = m.profileFromSelection(store, store.Profiles[name])
+	return store.save()
+}
+
+// profileNotice describes a loaded profile, listing paths that no longer
+// exist.
+func profileNotice(name string, selected int, missing []string) string {
+	notice := fmt.Sprintf("loaded profile %s: %d files", name, selected)
+	if len(missing) > 0 {
+		shown := missing
+		if len(shown) > 3 {
+			shown = shown[:3]
+		}
+		notice += fmt.Sprintf(", %d missing: %s", len(missing), strings.Join(shown, ", "))
+		if len(missing) > len(shown) {
+			notice += ", ..."
+		}
+	}
+	return notice
+}
+
+func (s *profileScreen) current() string {
+	if s.cursor < 0 || s.cursor >= len(s.names) {
+		return ""
+	}
+	return s.names[s.cursor]
+}
+
+// updateProfilePanes renders the profile list on the left and the profile
+// under the cursor, with its difference to the current selection, on the
+// right.
+func (m *model) updateProfilePanes() tea.Cmd {
+	screen := m.profiles
+	errStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+
+	var list strings.Builder
+	list.WriteString(lipgloss.NewStyle().Bold(true).Render("Profiles") + "\n")
+	list.WriteString(lipgloss.NewStyle().Faint(true).Render(screen.store.path) + "\n\n")
+	if screen.err != nil {
+		list.WriteString(errStyle.Render(screen.err.Error()) + "\n\n")
+	}
+	if len(screen.names) == 0 {
+		list.WriteString("No profiles yet. Press s to save the current selection.\n")
+	}
+	for i, name := range screen.names {
+		line := "  " + name
+		if i == screen.cursor {
+			line = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Render("> ") + name
+		}
+		list.WriteString(line + "\n")
+	}
+	if screen.naming {
+		list.WriteString("\nSave selection as:\n" + screen.name.View() + "\n")
+	}
+	if screen.confirmDelete {
+		list.WriteString("\n" + errStyle.Render(fmt.Sprintf("Press x again to delete %s", screen.current())) + "\n")
+	}
+	m.leftViewport.SetContent(list.String())
+
+	var detail strings.Builder
+	if name := screen.current(); name != "" {
+		p := screen.store.Profiles[name]
+		fmt.Fprintf(&detail, "%s\n\n", lipgloss.NewStyle().Bold(true).Render(name))
+		if len(p.Include) > 0 {
+			fmt.Fprintf(&detail, "include: %s\n", strings.Join(p.Include, ", "))
+		}
+		if len(p.Exclude) > 0 {
+			fmt.Fprintf(&detail, "exclude: %s\n", strings.Join(p.Exclude, ", "))
+		}
+		fmt.Fprintf(&detail, "listed files: %d\n", len(p.Selected))
+		if format, err := profileFormat(p); err != nil {
+			detail.WriteString(errStyle.Render(err.Error()) + "\n")
+		} else if format != "" {
+			fmt.Fprintf(&detail, "format: %s\n", format)
+		}
+
+		diff := m.profileDiff(screen.store, p)
+		detail.WriteString("\nCompared to the current selection:\n")
+		if len(diff) == 0 {
+			detail.WriteString("  identical\n")
+		}
+		styles := map[byte]lipgloss.Style{
+			'+': lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+			'-': lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+			'!': lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+		}
+		for _, line := range diff {
+			detail.WriteString("  " + styles[line[0]].Render(line) + "\n")
+		}
+	}
+	m.rightViewport.SetContent(detail.String())
+	m.rightViewport.YOffset = 0
+	return nil
+}
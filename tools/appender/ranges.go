@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lineRange is an inclusive range of 1-based line numbers.
+type lineRange struct {
+	start, end int
+}
+
+func (r lineRange) String() string {
+	if r.start == r.end {
+		return strconv.Itoa(r.start)
+	}
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// lineSpecSuffix matches the ranges at the end of a path:120-180,200 spec.
+var lineSpecSuffix = regexp.MustCompile(`:(\d+(?:-\d+)?(?:,\d+(?:-\d+)?)*)$`)
+
+// splitLineSpec splits a path:120-180,200-210 spec into the path, or glob,
+// and its line ranges. A spec without ranges is returned as is.
+func splitLineSpec(spec string) (string, []lineRange, error) {
+	match := lineSpecSuffix.FindStringSubmatchIndex(spec)
+	if match == nil {
+		return spec, nil, nil
+	}
+	ranges, err := parseLineRanges(spec[match[2]:match[3]])
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", spec, err)
+	}
+	return spec[:match[0]], ranges, nil
+}
+
+// parseLineRanges reads comma-separated ranges such as 120-180,200.
+func parseLineRanges(s string) ([]lineRange, error) {
+	var ranges []lineRange
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line range %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(to); err != nil {
+				return nil, fmt.Errorf("invalid line range %q", part)
+			}
+		}
+		if start < 1 || end < start {
+			return nil, fmt.Errorf("invalid line range %q: lines are numbered from 1, start first", part)
+		}
+		ranges = append(ranges, lineRange{start, end})
+	}
+	return mergeRanges(ranges), nil
+}
+
+// mergeRanges sorts ranges and joins those that overlap or touch.
+func mergeRanges(ranges []lineRange) []lineRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b lineRange) int { return a.start - b.start })
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end+1 {
+			last.end = max(last.end, r.end)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// formatRanges writes ranges the way parseLineRanges reads them.
+func formatRanges(ranges []lineRange) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// lineSpec writes rel with its ranges, if any, as a path:120-180 spec.
+func lineSpec(rel string, ranges []lineRange) string {
+	if len(ranges) == 0 {
+		return rel
+	}
+	return rel + ":" + formatRanges(ranges)
+}
+
+// elisionMarker matches the lines that stand for the lines left out of a
+// partial export.
+var elisionMarker = regexp.MustCompile(`(?m)^\.\.\. lines? \d+(-\d+)? omitted \.\.\.$`)
+
+// elision is the marker for the lines from start to end.
+func elision(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("... line %d omitted ...\n", start)
+	}
+	return fmt.Sprintf("... lines %d-%d omitted ...\n", start, end)
+}
+
+// extractRanges returns the lines of content in ranges, with markers for
+// the lines left out, and the ranges clamped to the lines content has.
+func extractRanges(content string, ranges []lineRange) (string, []lineRange) {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var b strings.Builder
+	var shown []lineRange
+	next := 1 // first line not written or elided yet
+	for _, r := range ranges {
+		if r.start > len(lines) {
+			break
+		}
+		r.end = min(r.end, len(lines))
+		if r.start > next {
+			b.WriteString(elision(next, r.start-1))
+		}
+		for _, line := range lines[r.start-1 : r.end] {
+			b.WriteString(line)
+		}
+		if !strings.HasSuffix(lines[r.end-1], "\n") {
+			b.WriteString("\n")
+		}
+		shown = append(shown, r)
+		next = r.end + 1
+	}
+	if next <= len(lines) {
+		b.WriteString(elision(next, len(lines)))
+	}
+	return b.String(), shown
+}
+
+// rangeLabel describes the ranges of a partial export in headers.
+func rangeLabel(ranges []lineRange) string {
+	if len(ranges) == 1 && ranges[0].start == ranges[0].end {
+		return "line " + ranges[0].String()
+	}
+	return "lines " + strings.ReplaceAll(formatRanges(ranges), ",", ", ")
+}
+
+// setRanges selects only the lines in ranges of the file node, or the whole
+// file when ranges is empty, and counts the tokens of those lines. A file
+// with every range past its end is exported, and counted, in full.
+func (m *model) setRanges(node *FileNode, ranges []lineRange) {
+	node.ranges = mergeRanges(ranges)
+	node.rangeTokens = 0
+	if len(node.ranges) == 0 {
+		return
+	}
+	node.selected = true
+	if data, err := os.ReadFile(node.path); err == nil {
+		content, _ := m.exportText(data)
+		if partial, shown := extractRanges(content, node.ranges); len(shown) > 0 {
+			content = partial
+		}
+		node.rangeTokens = m.countTokens(content)
+	}
+}
+
+// selectTokens is the token count of what exporting node contributes.
+func (node *FileNode) selectTokens() int {
+	if len(node.ranges) > 0 {
+		return node.rangeTokens
+	}
+	return node.tokens
+}
+
+// selectLineSpec selects ranges in the files matched by the find pattern and
+// reports what it did.
+func (m *model) selectLineSpec(ranges []lineRange) string {
+	selected := 0
+	for _, node := range m.matchedNodes {
+		if node.isDir || node.err != nil || m.classOf(node) == classBinary {
+			continue
+		}
+		m.setRanges(node, ranges)
+		selected++
+	}
+	switch selected {
+	case 0:
+		return "no file to select lines in"
+	case 1:
+		return "selected " + rangeLabel(ranges)
+	}
+	return fmt.Sprintf("selected %s in %d files", rangeLabel(ranges), selected)
+}
+
+// applyLineSpecs restricts the selected files matched by the globs of specs
+// to the ranges given with them. Ranges given for the same file add up.
+func (m *model) applyLineSpecs(specs map[string][]lineRange) {
+	if len(specs) == 0 {
+		return
+	}
+	for path, node := range m.nodeLookup {
+		if !node.selected || node.isDir {
+			continue
+		}
+		rel, ok := m.relToWorkDir(path)
+		if !ok {
+			continue
+		}
+		var ranges []lineRange
+		for glob, specRanges := range specs {
+			if matchesAny([]string{glob}, rel) {
+				ranges = append(ranges, specRanges...)
+			}
+		}
+		if len(ranges) > 0 {
+			m.setRanges(node, ranges)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/stretchr/testify/require"
+)
+
+// numberedLines returns lines "line 1" to "line n".
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func Test_splitLineSpec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		path   string
+		ranges []lineRange
+		err    string
+	}{
+		{spec: "main.go", path: "main.go"},
+		{spec: "src/big.go:120-180", path: "src/big.go", ranges: []lineRange{{120, 180}}},
+		{spec: "a.go:200,5-10,8-12,13", path: "a.go", ranges: []lineRange{{5, 13}, {200, 200}}},
+		{spec: "**/*.go:1-3", path: "**/*.go", ranges: []lineRange{{1, 3}}},
+		{spec: "c:/dir/file", path: "c:/dir/file"},
+		{spec: "a.go:10-5", err: `invalid line range "10-5"`},
+		{spec: "a.go:0", err: `invalid line range "0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			path, ranges, err := splitLineSpec(tt.spec)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.path, path)
+			require.Equal(t, tt.ranges, ranges)
+			// Specs are written back the way they are read
+			path, again, err := splitLineSpec(lineSpec(path, ranges))
+			require.NoError(t, err)
+			require.Equal(t, tt.path, path)
+			require.Equal(t, ranges, again)
+		})
+	}
+
+	globs, lines, err := splitIncludeSpecs([]string{"a.go:1-5", "10-20", "*.md", "b.go:3"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.go", "*.md", "b.go"}, globs)
+	require.Equal(t, map[string][]lineRange{"a.go": {{1, 5}, {10, 20}}, "b.go": {{3, 3}}}, lines)
+}
+
+func Test_extractRanges(t *testing.T) {
+	content := "one\ntwo\nthree\nfour\nfive\nsix"
+	tests := []struct {
+		name   string
+		ranges []lineRange
+		expect string
+		shown  []lineRange
+	}{
+		{
+			name:   "middle",
+			ranges: []lineRange{{2, 3}},
+			expect: "... line 1 omitted ...\ntwo\nthree\n... lines 4-6 omitted ...\n",
+			shown:  []lineRange{{2, 3}},
+		},
+		{
+			name:   "gaps",
+			ranges: []lineRange{{1, 1}, {4, 4}, {6, 6}},
+			expect: "one\n... lines 2-3 omitted ...\nfour\n... line 5 omitted ...\nsix\n",
+			shown:  []lineRange{{1, 1}, {4, 4}, {6, 6}},
+		},
+		{
+			name:   "past the end",
+			ranges: []lineRange{{5, 100}, {200, 210}},
+			expect: "... lines 1-4 omitted ...\nfive\nsix\n",
+			shown:  []lineRange{{5, 6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partial, shown := extractRanges(content, tt.ranges)
+			require.Equal(t, tt.expect, partial)
+			require.Equal(t, tt.shown, shown)
+		})
+	}
+	require.Equal(t, "lines 5-6, 9", rangeLabel([]lineRange{{5, 6}, {9, 9}}))
+	require.Equal(t, "line 5", rangeLabel([]lineRange{{5, 5}}))
+}
+
+func Test_packLineRanges(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"big.go":   "package big\n\nfunc a() {}\n\nfunc b() {}\n\nfunc c() {}\n",
+		"small.go": "package small\n",
+	})
+
+	globs, lines, err := splitIncludeSpecs([]string{"big.go:3,7", "small.go"})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, runPack(packOptions{workDir: dir, include: globs, lines: lines}, &buf))
+	require.Equal(t, "# big.go (lines 3, 7)\n"+
+		"... lines 1-2 omitted ...\nfunc a() {}\n... lines 4-6 omitted ...\nfunc c() {}\n\n"+
+		"# small.go\npackage small\n\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, runPack(packOptions{workDir: dir, include: globs, lines: lines, format: FormatXML}, &buf))
+	require.Contains(t, buf.String(), "<lines>lines 3, 7</lines>")
+
+	// Ranges past the end of a file that shrank export it in full
+	globs, lines, err = splitIncludeSpecs([]string{"small.go:5-9"})
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, runPack(packOptions{workDir: dir, include: globs, lines: lines}, &buf))
+	require.Equal(t, "# small.go\npackage small\n\n", buf.String())
+}
+
+func Test_lineRangesPersist(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := newTestRepo(t, map[string]string{
+		"big.go":   numberedLines(30),
+		"small.go": "package small\n",
+	})
+	big := filepath.Join(dir, "big.go")
+
+	m := &model{workDir: dir}
+	require.NoError(t, m.buildFileTree())
+	m.selectPaths([]string{big, filepath.Join(dir, "small.go")})
+	m.setRanges(m.nodeLookup[big], []lineRange{{20, 25}, {2, 4}})
+	require.Contains(t, m.getNodeDisplay(m.nodeLookup[big]), "[lines 2-4, 20-25]")
+	require.Positive(t, m.nodeLookup[big].selectTokens())
+	require.Less(t, m.nodeLookup[big].selectTokens(), m.countTokens(numberedLines(30)))
+
+	store, err := findProfileStore(dir)
+	require.NoError(t, err)
+	p := m.profileFromSelection(store, profile{Include: []string{"*.go"}})
+	require.Equal(t, []string{"big.go:2-4,20-25"}, p.Selected)
+
+	// Loading the profile restores the ranges
+	loaded := &model{workDir: dir}
+	require.NoError(t, loaded.buildFileTree())
+	_, err = loaded.loadProfile(store, p)
+	require.NoError(t, err)
+	require.Equal(t, []lineRange{{2, 4}, {20, 25}}, loaded.nodeLookup[big].ranges)
+	require.Empty(t, loaded.nodeLookup[filepath.Join(dir, "small.go")].ranges)
+
+	// So does restoring the session
+	s := m.captureSession()
+	require.Contains(t, s.Selected, "big.go:2-4,20-25")
+	restored := &model{workDir: dir}
+	require.NoError(t, restored.buildFileTree())
+	restored.restoreSession(s)
+	require.True(t, restored.nodeLookup[big].selected)
+	require.Equal(t, []lineRange{{2, 4}, {20, 25}}, restored.nodeLookup[big].ranges)
+
+	// Deselecting forgets them
+	m.toggleDirSelection(m.rootNode)
+	m.toggleDirSelection(m.rootNode)
+	require.Empty(t, m.nodeLookup[big].ranges)
+}
+
+func Test_rangeScreen(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"big.go": numberedLines(30), "sub/x.go": "package x\n"})
+
+	m := newRangeTestModel(t, dir)
+	press := func(keys ...string) {
+		for _, k := range keys {
+			msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			if k == "enter" {
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			}
+			m.Update(msg)
+		}
+	}
+	moveTo := func(name string) {
+		for i, node := range m.flatNodes {
+			if node.name == name {
+				m.cursor = i
+			}
+		}
+	}
+	moveTo("sub")
+	press("V")
+	require.Nil(t, m.rangeSelect)
+	require.Equal(t, "lines are selected in files, not directories", m.notice)
+
+	moveTo("big.go")
+	press("V", "j", "v", "j", "j", "v", "j", "j", "j", "v", "enter")
+	require.Nil(t, m.rangeSelect)
+	big := m.nodeLookup[filepath.Join(dir, "big.go")]
+	require.True(t, big.selected)
+	require.Equal(t, []lineRange{{2, 4}, {7, 7}}, big.ranges)
+	require.Equal(t, "selected lines 2-4, 7", m.notice)
+
+	// Reopening starts at the first range; x removes the range under the cursor
+	press("V", "x", "enter")
+	require.Equal(t, []lineRange{{7, 7}}, big.ranges)
+	press("V", "x", "enter")
+	require.Empty(t, big.ranges)
+	require.True(t, big.selected)
+	require.Equal(t, "whole file selected", m.notice)
+}
+
+// newRangeTestModel returns a model of dir that takes key presses.
+func newRangeTestModel(t *testing.T, dir string) *model {
+	t.Helper()
+	m := &model{workDir: dir, findPattern: initFindInput(), keys: keys}
+	require.NoError(t, m.buildFileTree())
+	m.flattenTree()
+	renderer, err := glamour.NewTermRenderer(glamour.WithStandardStyle("notty"))
+	require.NoError(t, err)
+	m.renderer = renderer
+	m.rightViewport.Height = 10
+	return m
+}
+
+func Test_applyRefusesElidedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"big.go": numberedLines(5)})
+
+	response := "## `big.go`\n\n```go\n... lines 1-2 omitted ...\nline 3 changed\n... lines 4-5 omitted ...\n```\n"
+	var stdout, stderr strings.Builder
+	err := runApply(applyOptions{workDir: dir}, response, &stdout, &stderr)
+	require.ErrorIs(t, err, errConflicts)
+	require.Contains(t, stderr.String(), "whole file has omitted lines")
+
+	data, err := os.ReadFile(filepath.Join(dir, "big.go"))
+	require.NoError(t, err)
+	require.Equal(t, numberedLines(5), string(data))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// rangeScreen marks the line ranges of a file to export, in the right pane.
+type rangeScreen struct {
+	node   *FileNode
+	lines  []string
+	cursor int // line under the cursor, 0-based
+	anchor int // line where the range being marked starts, -1 when none is
+	ranges []lineRange
+}
+
+// rangeKeyMap lists the keys of the line selection screen for the help view.
+type rangeKeyMap struct {
+	Up, Down, PageUp, PageDown, Mark, Remove, Apply, Cancel key.Binding
+}
+
+func (k rangeKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Mark, k.Remove, k.Apply, k.Cancel}
+}
+
+func (k rangeKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Up, k.Down, k.PageUp, k.PageDown}, {k.Mark, k.Remove, k.Apply, k.Cancel}}
+}
+
+var rangeKeys = rangeKeyMap{
+	Up:       key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "move up")),
+	Down:     key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "move down")),
+	PageUp:   key.NewBinding(key.WithKeys("pgup", "K"), key.WithHelp("pgup", "page up")),
+	PageDown: key.NewBinding(key.WithKeys("pgdown", "J"), key.WithHelp("pgdown", "page down")),
+	Mark:     key.NewBinding(key.WithKeys("v", " "), key.WithHelp("v", "start/end range")),
+	Remove:   key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "remove range")),
+	Apply:    key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "select ranges")),
+	Cancel:   key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "cancel")),
+}
+
+// openRanges shows the line selection screen for the file under the cursor.
+func (m *model) openRanges() tea.Cmd {
+	node := m.flatNodes[m.cursor]
+	switch {
+	case node.isDir:
+		m.notice = "lines are selected in files, not directories"
+		return nil
+	case node.deleted:
+		m.notice = "deleted files cannot be selected"
+		return nil
+	case node.err != nil:
+		m.notice = "unreadable files cannot be selected"
+		return nil
+	case m.classOf(node) == classBinary:
+		m.notice = "binary files are not exported"
+		return nil
+	}
+	data, err := os.ReadFile(node.path)
+	if err != nil {
+		m.notice = readError(err)
+		return nil
+	}
+	content, _ := m.exportText(data)
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	screen := &rangeScreen{node: node, lines: lines, anchor: -1, ranges: slices.Clone(node.ranges)}
+	if len(screen.ranges) > 0 {
+		screen.cursor = min(screen.ranges[0].start, len(lines)) - 1
+	}
+	m.rangeSelect = screen
+	m.updateRangePane()
+	m.rightViewport.SetYOffset(max(screen.cursor-m.rightViewport.Height/2, 0))
+	return nil
+}
+
+// updateRanges handles keys while the line selection screen is open.
+func (m *model) updateRanges(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	screen := m.rangeSelect
+	last := len(screen.lines) - 1
+	switch {
+	case key.Matches(msg, rangeKeys.Cancel):
+		if screen.anchor >= 0 {
+			screen.anchor = -1
+			break
+		}
+		m.rangeSelect = nil
+		return m, m.updateContent()
+	case key.Matches(msg, rangeKeys.Apply):
+		if screen.anchor >= 0 {
+			screen.mark()
+		}
+		m.rangeSelect = nil
+		screen.node.selected = true
+		m.setRanges(screen.node, screen.ranges)
+		m.notice = "whole file selected"
+		if len(screen.ranges) > 0 {
+			m.notice = "selected " + rangeLabel(screen.ranges)
+		}
+		return m, tea.Batch(m.updateTree(), m.updateContent())
+	case key.Matches(msg, rangeKeys.Up):
+		screen.cursor = max(screen.cursor-1, 0)
+	case key.Matches(msg, rangeKeys.Down):
+		screen.cursor = min(screen.cursor+1, last)
+	case key.Matches(msg, rangeKeys.PageUp):
+		screen.cursor = max(screen.cursor-m.rightViewport.Height, 0)
+	case key.Matches(msg, rangeKeys.PageDown):
+		screen.cursor = min(screen.cursor+m.rightViewport.Height, last)
+	case key.Matches(msg, rangeKeys.Mark):
+		if screen.anchor < 0 {
+			screen.anchor = screen.cursor
+		} else {
+			screen.mark()
+		}
+	case key.Matches(msg, rangeKeys.Remove):
+		screen.ranges = slices.DeleteFunc(screen.ranges, func(r lineRange) bool {
+			return screen.cursor+1 >= r.start && screen.cursor+1 <= r.end
+		})
+	}
+	// Keep the cursor in view
+	if screen.cursor < m.rightViewport.YOffset {
+		m.rightViewport.SetYOffset(screen.cursor)
+	} else if screen.cursor >= m.rightViewport.YOffset+m.rightViewport.Height {
+		m.rightViewport.SetYOffset(screen.cursor - m.rightViewport.Height + 1)
+	}
+	return m, m.updateRangePane()
+}
+
+// mark adds the range from the anchor to the cursor.
+func (s *rangeScreen) mark() {
+	start, end := min(s.anchor, s.cursor), max(s.anchor, s.cursor)
+	s.ranges = mergeRanges(append(s.ranges, lineRange{start + 1, end + 1}))
+	s.anchor = -1
+}
+
+// marked tells whether line, 0-based, is in a range or in the one being
+// marked.
+func (s *rangeScreen) marked(line int) (inRange, pending bool) {
+	for _, r := range s.ranges {
+		if line+1 >= r.start && line+1 <= r.end {
+			inRange = true
+		}
+	}
+	if s.anchor >= 0 {
+		pending = line >= min(s.anchor, s.cursor) && line <= max(s.anchor, s.cursor)
+	}
+	return inRange, pending
+}
+
+// updateRangePane renders the file with line numbers, the marked ranges and
+// the cursor.
+func (m *model) updateRangePane() tea.Cmd {
+	screen := m.rangeSelect
+	rangeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	pendingStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	numberStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	width := len(fmt.Sprint(len(screen.lines)))
+	var b strings.Builder
+	for i, line := range screen.lines {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		inRange, pending := screen.marked(i)
+		switch {
+		case pending:
+			line = pendingStyle.Render(line)
+		case inRange:
+			line = rangeStyle.Render(line)
+		}
+		prefix := "  "
+		if i == screen.cursor {
+			prefix = pendingStyle.Render("> ")
+		}
+		fmt.Fprintf(&b, "%s%s %s\n", prefix, numberStyle.Render(fmt.Sprintf("%*d", width, i+1)), line)
+	}
+	offset := m.rightViewport.YOffset
+	m.rightViewport.SetContent(b.String())
+	m.rightViewport.SetYOffset(offset)
+	return nil
+}
+
+// rangeStatus describes the ranges marked so far.
+func (s *rangeScreen) rangeStatus() string {
+	status := "no lines marked, enter selects the whole file"
+	if len(s.ranges) > 0 {
+		status = rangeLabel(s.ranges)
+	}
+	if s.anchor >= 0 {
+		status += fmt.Sprintf(", marking from line %d", s.anchor+1)
+	}
+	return status
+}
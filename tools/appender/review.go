@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// reviewRange is the commit range of a review pack, written base..head.
+type reviewRange struct {
+	base  string
+	head  string
+	tests bool // also include the test files paired with touched files
+}
+
+// parseReviewRange parses "base..head", "base...head" or just "base", which
+// reviews base..HEAD.
+func parseReviewRange(s string) (reviewRange, error) {
+	base, head, found := strings.Cut(s, "...")
+	if !found {
+		base, head, _ = strings.Cut(s, "..")
+	}
+	if base == "" {
+		return reviewRange{}, fmt.Errorf("invalid review range %q: expected base..head", s)
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	return reviewRange{base: base, head: head}, nil
+}
+
+func (r reviewRange) String() string {
+	return r.base + ".." + r.head
+}
+
+// review is the git side of a review pack: the commits in the range, the
+// diff of the range and the files it touches.
+type review struct {
+	repo    *gitRepo
+	rng     reviewRange
+	log     string
+	diff    string
+	touched []string        // files added or modified in the range, relative to the repository root
+	tests   []string        // test files paired with touched files that exist at head
+	atHead  map[string]bool // every file present at head
+}
+
+// loadReview reads the commits, diff and touched files of rng. The diff is
+// taken from the merge base, like a pull request, so that changes made on
+// base since head forked from it are left out.
+func (r *gitRepo) loadReview(rng reviewRange) (*review, error) {
+	rev := &review{repo: r, rng: rng}
+
+	out, err := runGit(r.root, "log", "--no-color", "--no-decorate", rng.base+".."+rng.head)
+	if err != nil {
+		return nil, err
+	}
+	rev.log = string(out)
+
+	if out, err = runGit(r.root, "diff", "--no-color", "-M", rng.base+"..."+rng.head); err != nil {
+		return nil, err
+	}
+	rev.diff = string(out)
+
+	// Deleted files have no contents at head; they are covered by the diff
+	if out, err = runGit(r.root, "diff", "--name-only", "-z", "-M", "--diff-filter=d", rng.base+"..."+rng.head); err != nil {
+		return nil, err
+	}
+	rev.touched = splitNul(out)
+
+	if out, err = runGit(r.root, "ls-tree", "-r", "--name-only", "-z", rng.head); err != nil {
+		return nil, err
+	}
+	rev.atHead = make(map[string]bool)
+	for _, path := range splitNul(out) {
+		rev.atHead[path] = true
+	}
+
+	if rng.tests {
+		touched := make(map[string]bool, len(rev.touched))
+		for _, path := range rev.touched {
+			touched[path] = true
+		}
+		for _, path := range rev.touched {
+			for _, candidate := range pairedTestFiles(path) {
+				if rev.atHead[candidate] && !touched[candidate] {
+					touched[candidate] = true
+					rev.tests = append(rev.tests, candidate)
+				}
+			}
+		}
+	}
+
+	return rev, nil
+}
+
+// pairedTestFiles returns the conventional locations of the tests for the
+// source file at relPath, e.g. foo_test.go for foo.go or foo.test.ts and
+// __tests__/foo.test.ts for foo.ts. Test files have no pairs.
+func pairedTestFiles(relPath string) []string {
+	if isTestFile(relPath) {
+		return nil
+	}
+	dir, base := path.Split(relPath)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+
+	switch ext {
+	case ".go":
+		return []string{dir + name + "_test.go"}
+	case ".js", ".jsx", ".ts", ".tsx", ".mjs", ".cjs":
+		return []string{
+			dir + name + ".test" + ext,
+			dir + name + ".spec" + ext,
+			dir + "__tests__/" + name + ".test" + ext,
+		}
+	case ".py":
+		return []string{
+			dir + "test_" + name + ext,
+			dir + name + "_test" + ext,
+			"tests/" + dir + "test_" + name + ext,
+		}
+	case "":
+		return nil
+	default:
+		return []string{dir + name + "_test" + ext, dir + name + ".test" + ext}
+	}
+}
+
+// contentAtHead returns the contents of a file, relative to the repository
+// root, as of the head of the range.
+func (rev *review) contentAtHead(repoPath string) (string, error) {
+	out, err := runGit(rev.repo.root, "show", rev.rng.head+":"+repoPath)
+	return string(out), err
+}
+
+// reviewNodePaths resolves the files of the review pack to FileNode paths
+// below m.workDir.
+func (m *model) reviewNodePaths() ([]string, error) {
+	rev, err := m.loadReview()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, repoPath := range append(append([]string{}, rev.touched...), rev.tests...) {
+		if path, ok := rev.repo.toNodePath(m.workDir, repoPath); ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// loadReview reads the review of m.review from git.
+func (m *model) loadReview() (*review, error) {
+	repo, err := openGitRepo(m.workDir)
+	if err != nil {
+		return nil, err
+	}
+	return repo.loadReview(*m.review)
+}
+
+// selectReview selects the files touched by the review range, and their
+// tests when requested, and returns a notice describing the result.
+func (m *model) selectReview() string {
+	paths, err := m.reviewNodePaths()
+	if err != nil {
+		return fmt.Sprintf("git: %v", err)
+	}
+	n := m.selectPaths(paths)
+	return fmt.Sprintf("selected %d files touched by %s", n, m.review)
+}
+
+// reviewEntries turns the selected files into a review pack from git: their
+// contents are replaced with the version at head, and the commit log and
+// the diff of the range are returned separately so that they can lead the
+// bundle. Selected files that do not exist at head keep their contents on
+// disk. Files of the review missing from the working tree, as when another
+// commit than head is checked out, are read from head and follow the
+// selected files.
+func (m *model) reviewEntries(files []bundleFile, git *gitContent) (header, body []bundleFile) {
+	rev := git.review
+	for _, file := range files {
+		if content, ok := git.atHead[git.repo.repoPath(file.AbsPath)]; ok {
+			file.Content, file.Encoding = m.exportText([]byte(content))
+		}
+		body = append(body, m.recount(file))
+	}
+
+	for _, repoPath := range append(append([]string{}, rev.touched...), rev.tests...) {
+		path, ok := rev.repo.toNodePath(m.workDir, repoPath)
+		if !ok {
+			continue
+		}
+		if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+			// On disk, the file is in the tree and exported when selected
+			continue
+		}
+		content, ok := git.atHead[repoPath]
+		if !ok || !m.headOnlySelected(path) {
+			continue
+		}
+		sample := content[:min(len(content), sampleSize)]
+		node := &FileNode{name: filepath.Base(path), path: path, meta: &fileMeta{Class: sampleClass(filepath.Base(path), []byte(sample))}}
+		if !include(node, m.filters()...) {
+			continue
+		}
+		rel, _ := filepath.Rel(m.workDir, path)
+		file := bundleFile{AbsPath: path, Path: filepath.ToSlash(rel), Language: languageFor(path)}
+		file.Content, file.Encoding = m.exportText([]byte(content))
+		body = append(body, m.recount(file))
+	}
+
+	header = []bundleFile{
+		m.recount(bundleFile{Path: "git log " + rev.rng.String(), Language: "text", Content: rev.log}),
+		m.recount(bundleFile{Path: "git diff " + rev.rng.base + "..." + rev.rng.head, Language: "diff", Content: rev.diff}),
+	}
+	return header, body
+}
+
+// headOnlySelected reports whether a file of the review missing from the
+// working tree belongs in the pack. It goes through the checks files on disk
+// go through: the include and exclude globs of a headless export, ignore
+// rules, and hidden files and directories. In the TUI it also follows the
+// selection around it: its nearest directory in the tree, or a file in that
+// directory, must be selected. The class filters need its contents and are
+// left to the caller.
+func (m *model) headOnlySelected(path string) bool {
+	rel, err := filepath.Rel(m.workDir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if matchesAny(m.excludeGlobs, rel) || (len(m.includeGlobs) > 0 && !matchesAny(m.includeGlobs, rel)) {
+		return false
+	}
+
+	// The file and the directories missing between it and the tree
+	var nearest *FileNode
+	isDir := false
+	for p := path; p != m.workDir && p != "." && p != filepath.Dir(p); p = filepath.Dir(p) {
+		if node, ok := m.nodeLookup[p]; ok {
+			nearest = node
+			break
+		}
+		if m.ignore != nil && !m.showIgnored && m.ignore.ignored(p, isDir) {
+			return false
+		}
+		if m.removeHidden && FilterHidden(&FileNode{name: filepath.Base(p)}) {
+			return false
+		}
+		isDir = true
+	}
+	if nearest == nil {
+		nearest = m.rootNode
+	}
+	if nearest == nil || !include(nearest, m.filters()...) {
+		return false
+	}
+	if len(m.includeGlobs) > 0 || nearest.selected {
+		return true
+	}
+	for _, child := range nearest.children {
+		if child.selected && !child.isDir {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseReviewRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		expect  reviewRange
+		wantErr bool
+	}{
+		{input: "main..feature", expect: reviewRange{base: "main", head: "feature"}},
+		{input: "main...feature", expect: reviewRange{base: "main", head: "feature"}},
+		{input: "main", expect: reviewRange{base: "main", head: "HEAD"}},
+		{input: "main..", expect: reviewRange{base: "main", head: "HEAD"}},
+		{input: "..feature", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseReviewRange(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func Test_pairedTestFiles(t *testing.T) {
+	require.Equal(t, []string{"lib/a_test.go"}, pairedTestFiles("lib/a.go"))
+	require.Nil(t, pairedTestFiles("lib/a_test.go"))
+	require.Contains(t, pairedTestFiles("src/app.ts"), "src/__tests__/app.test.ts")
+	require.Contains(t, pairedTestFiles("pkg/util.py"), "pkg/test_util.py")
+	require.Nil(t, pairedTestFiles("Makefile"))
+}
+
+func Test_runPackReview(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"lib/a.go":      "package lib\n",
+		"lib/a_test.go": "package lib\n\n// tests for a\n",
+		"lib/c.go":      "package lib\n\nfunc C() {}\n",
+		"docs/x.md":     "# x\n",
+	})
+	gitCmd(t, dir, "checkout", "-q", "-b", "feature")
+	writeFiles(t, dir, map[string]string{
+		"lib/a.go": "package lib\n\nfunc A() {}\n",
+		"lib/b.go": "package lib\n\nfunc B() {}\n",
+	})
+	gitCmd(t, dir, "rm", "-q", "lib/c.go")
+	gitCmd(t, dir, "add", "-A")
+	gitCmd(t, dir, "commit", "-q", "-m", "Add A and B", "-m", "Removes C.")
+	// Uncommitted work is not part of the range
+	writeFiles(t, dir, map[string]string{"lib/a.go": "package lib\n\nfunc Uncommitted() {}\n"})
+
+	tests := []struct {
+		name     string
+		tests    bool
+		contains []string
+		excludes []string
+	}{
+		{
+			name: "touched files",
+			contains: []string{
+				"# git log main..feature\n",
+				"Removes C.",
+				"# git diff main...feature\n",
+				"-func C() {}",
+				"# lib/a.go\npackage lib\n\nfunc A() {}\n",
+				"# lib/b.go\n",
+			},
+			excludes: []string{"Uncommitted", "# lib/c.go", "# lib/a_test.go", "# docs/x.md"},
+		},
+		{
+			name:     "with tests",
+			tests:    true,
+			contains: []string{"# lib/a_test.go\npackage lib\n\n// tests for a\n"},
+			excludes: []string{"# lib/b_test.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			opts := packOptions{
+				workDir:  dir,
+				format:   FormatPlain,
+				noHidden: true,
+				review:   &reviewRange{base: "main", head: "feature", tests: tt.tests},
+			}
+			require.NoError(t, runPack(opts, &out))
+			for _, s := range tt.contains {
+				require.Contains(t, out.String(), s)
+			}
+			for _, s := range tt.excludes {
+				require.NotContains(t, out.String(), s)
+			}
+			require.True(t, strings.HasPrefix(out.String(), "# git log main..feature\n"))
+		})
+	}
+}
+
+func Test_runPackReviewOtherCheckout(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"lib/a.go": "package lib\n"})
+	gitCmd(t, dir, "checkout", "-q", "-b", "feature")
+	writeFiles(t, dir, map[string]string{
+		"lib/a.go":      "package lib\n\nfunc A() {}\n",
+		"lib/b.go":      "package lib\n\nfunc B() {}\n",
+		"lib/b_test.go": "package lib\n\n// tests for b\n",
+	})
+	gitCmd(t, dir, "add", "-A")
+	gitCmd(t, dir, "commit", "-q", "-m", "Add B")
+	// Files added in the range are not in the checked-out tree
+	gitCmd(t, dir, "checkout", "-q", "main")
+
+	var out strings.Builder
+	opts := packOptions{
+		workDir: dir,
+		format:  FormatPlain,
+		review:  &reviewRange{base: "main", head: "feature"},
+	}
+	require.NoError(t, runPack(opts, &out))
+	require.Contains(t, out.String(), "# lib/a.go\npackage lib\n\nfunc A() {}\n")
+	require.Contains(t, out.String(), "# lib/b.go\npackage lib\n\nfunc B() {}\n")
+	require.Contains(t, out.String(), "# lib/b_test.go\npackage lib\n\n// tests for b\n")
+}
+
+func Test_runPackReviewFiltersHeadOnly(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"lib/a.go": "package lib\n", ".gitignore": "secret.txt\n"})
+	gitCmd(t, dir, "checkout", "-q", "-b", "feature")
+	writeFiles(t, dir, map[string]string{
+		"lib/a.go":       "package lib\n\nfunc A() {}\n",
+		"lib/b.go":       "package lib\n\nfunc B() {}\n",
+		"docs/x.md":      "# x\n",
+		"lib/secret.txt": "hunter2\n",
+		".hidden/h.go":   "package hidden\n",
+	})
+	gitCmd(t, dir, "add", "-A", "-f")
+	gitCmd(t, dir, "commit", "-q", "-m", "Add files")
+	// Gone from disk, so only head has them
+	for _, rel := range []string{"lib/b.go", "docs/x.md", "lib/secret.txt", ".hidden/h.go"} {
+		require.NoError(t, os.Remove(filepath.Join(dir, filepath.FromSlash(rel))))
+	}
+
+	var out strings.Builder
+	opts := packOptions{
+		workDir:  dir,
+		format:   FormatPlain,
+		noHidden: true,
+		exclude:  []string{"docs/**"},
+		review:   &reviewRange{base: "main", head: "feature"},
+	}
+	require.NoError(t, runPack(opts, &out))
+	require.Contains(t, out.String(), "# lib/b.go\npackage lib\n\nfunc B() {}\n")
+	require.NotContains(t, out.String(), "# docs/x.md")
+	require.NotContains(t, out.String(), "# lib/secret.txt")
+	require.NotContains(t, out.String(), "# .hidden/h.go")
+
+	// In the TUI they follow the selection around them
+	m := &model{workDir: dir}
+	require.NoError(t, m.buildFileTree())
+	b := filepath.Join(dir, "lib", "b.go")
+	require.False(t, m.headOnlySelected(b))
+	m.selectPaths([]string{filepath.Join(dir, "lib", "a.go")})
+	require.True(t, m.headOnlySelected(b))
+}
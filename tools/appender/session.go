@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// session is the UI state of a working directory that survives restarts.
+// Paths are relative to the working directory and slash separated; those
+// that no longer exist are skipped when the session is restored.
+type session struct {
+	Expanded    []string `json:"expanded,omitempty"`
+	Selected    []string `json:"selected,omitempty"`
+	Cursor      string   `json:"cursor,omitempty"`
+	ShowHidden  bool     `json:"show_hidden,omitempty"`
+	FindPattern string   `json:"find_pattern,omitempty"`
+}
+
+// stateDir returns the XDG state directory for appender.
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, "appender"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating state directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "appender"), nil
+}
+
+// dirKey names per-directory files: the base name of dir, for people
+// browsing the directory, and a hash of its absolute path to tell apart
+// directories with the same name.
+func dirKey(absDir string) string {
+	sum := sha256.Sum256([]byte(absDir))
+	return fmt.Sprintf("%s-%s", filepath.Base(absDir), hex.EncodeToString(sum[:4]))
+}
+
+// sessionPath returns where the session of workDir is stored.
+func sessionPath(workDir string) (string, error) {
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return "", err
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions", dirKey(absWorkDir)+".json"), nil
+}
+
+// readSession loads the session of workDir, nil if there is none.
+func readSession(workDir string) (*session, error) {
+	path, err := sessionPath(workDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading session: %w", err)
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("reading session %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// writeSession stores s as the session of workDir.
+func writeSession(workDir string, s session) error {
+	path, err := sessionPath(workDir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeStateFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing session: %w", err)
+	}
+	return nil
+}
+
+// writeStateFile replaces the file at path with data, creating its
+// directory. The file is replaced atomically so that a crash cannot leave a
+// truncated file behind.
+func writeStateFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// relToWorkDir converts a FileNode path to a session path.
+func (m *model) relToWorkDir(path string) (string, bool) {
+	rel, err := filepath.Rel(m.workDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// captureSession records the current UI state.
+func (m *model) captureSession() session {
+	s := session{
+		ShowHidden:  !m.removeHidden,
+		FindPattern: m.findPattern.Value(),
+	}
+	for path, node := range m.nodeLookup {
+		rel, ok := m.relToWorkDir(path)
+		if !ok {
+			continue
+		}
+		if node.isDir && node.expanded {
+			s.Expanded = append(s.Expanded, rel)
+		}
+		if node.selected {
+			s.Selected = append(s.Selected, lineSpec(rel, node.ranges))
+		}
+	}
+	sort.Strings(s.Expanded)
+	sort.Strings(s.Selected)
+	if m.cursor >= 0 && m.cursor < len(m.flatNodes) {
+		s.Cursor, _ = m.relToWorkDir(m.flatNodes[m.cursor].path)
+	}
+	return s
+}
+
+// restoreSession applies a session to a freshly built tree, except for the
+// cursor, which placeCursor sets once the tree is flattened. Paths that no
+// longer exist are dropped.
+func (m *model) restoreSession(s session) {
+	m.removeHidden = !s.ShowHidden
+
+	if s.FindPattern != "" {
+		// Finding expands the directories of the matches, which the saved
+		// expansions below override
+		m.findPattern.SetValue(s.FindPattern)
+		m.performFind()
+		m.currentMatchIdx = -1
+	}
+
+	// Directories the background walk has not reached yet are read now
+	selected := make(map[string][]lineRange, len(s.Selected))
+	for _, spec := range s.Selected {
+		if rel, ranges, err := splitLineSpec(spec); err == nil {
+			selected[rel] = ranges
+		}
+	}
+	for _, rel := range slices.Concat(s.Expanded, slices.Collect(maps.Keys(selected)), []string{s.Cursor}) {
+		m.ensureLoaded(filepath.Join(m.workDir, filepath.FromSlash(rel)))
+	}
+	expanded := make(map[string]bool, len(s.Expanded))
+	for _, rel := range s.Expanded {
+		expanded[filepath.Join(m.workDir, filepath.FromSlash(rel))] = true
+	}
+	for path, node := range m.nodeLookup {
+		if node.isDir && !node.isRoot {
+			node.expanded = expanded[path]
+		}
+	}
+	for rel, ranges := range selected {
+		if node, ok := m.nodeLookup[filepath.Join(m.workDir, filepath.FromSlash(rel))]; ok && checkReadable(node) {
+			node.selected = true
+			m.setRanges(node, ranges)
+		}
+	}
+}
+
+// placeCursor moves the cursor to a session path. A cursor on a removed file
+// lands on its closest remaining parent directory.
+func (m *model) placeCursor(rel string) {
+	if rel == "" {
+		return
+	}
+	target := filepath.Join(m.workDir, filepath.FromSlash(rel))
+	for {
+		for i, node := range m.flatNodes {
+			if node.path == target {
+				m.cursor = i
+				return
+			}
+		}
+		parent := filepath.Dir(target)
+		if parent == target || len(parent) < len(m.workDir) {
+			return
+		}
+		target = parent
+	}
+}
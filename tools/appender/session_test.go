@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_session(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/one.go":       "package a\n",
+		"a/two.go":       "package a\n",
+		"a/deep/gone.go": "package deep\n",
+		"b/three.go":     "package b\n",
+		".env":           "X=1\n",
+	})
+
+	newModel := func(t *testing.T) *model {
+		t.Helper()
+		m := &model{workDir: dir, removeHidden: true, findPattern: initFindInput()}
+		require.NoError(t, m.buildFileTree())
+		m.flattenTree()
+		return m
+	}
+	node := func(m *model, rel string) *FileNode {
+		return m.nodeLookup[filepath.Join(dir, filepath.FromSlash(rel))]
+	}
+
+	m := newModel(t)
+	node(m, "a").expanded = true
+	node(m, "a/deep").expanded = true
+	node(m, "a/one.go").selected = true
+	node(m, "a/deep/gone.go").selected = true
+	node(m, "b/three.go").selected = true
+	m.removeHidden = false
+	m.flattenTree()
+	for i, n := range m.flatNodes {
+		if n == node(m, "a/deep/gone.go") {
+			m.cursor = i
+		}
+	}
+	saved := m.captureSession()
+	require.Equal(t, session{
+		Expanded:   []string{"a", "a/deep"},
+		Selected:   []string{"a/deep/gone.go", "a/one.go", "b/three.go"},
+		Cursor:     "a/deep/gone.go",
+		ShowHidden: true,
+	}, saved)
+	require.NoError(t, writeSession(dir, saved))
+
+	t.Run("no session", func(t *testing.T) {
+		s, err := readSession(t.TempDir())
+		require.NoError(t, err)
+		require.Nil(t, s)
+	})
+
+	t.Run("restore after files changed", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, "a", "deep")))
+		writeFiles(t, dir, map[string]string{"a/new.go": "package a\n"})
+
+		s, err := readSession(dir)
+		require.NoError(t, err)
+		require.NotNil(t, s)
+
+		m := newModel(t)
+		m.restoreSession(*s)
+		m.flattenTree()
+		m.placeCursor(s.Cursor)
+
+		require.False(t, m.removeHidden)
+		require.True(t, node(m, "a").expanded)
+		require.False(t, node(m, "b").expanded)
+		require.True(t, node(m, "a/one.go").selected)
+		require.True(t, node(m, "b/three.go").selected)
+		require.False(t, node(m, "a/new.go").selected)
+		// The cursor file and its directory are gone, so it lands on a
+		require.Equal(t, node(m, "a"), m.flatNodes[m.cursor])
+	})
+
+	t.Run("find pattern", func(t *testing.T) {
+		m := newModel(t)
+		m.restoreSession(session{FindPattern: "b/*.go"})
+		m.flattenTree()
+
+		require.Equal(t, "b/*.go", m.findPattern.Value())
+		require.Equal(t, []*FileNode{node(m, "b/three.go")}, m.matchedNodes)
+		// Saved expansions win over those made to show the matches
+		require.False(t, node(m, "b").expanded)
+	})
+}
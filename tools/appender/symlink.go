@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// symlinkMode controls what is exported for a selected symlink.
+type symlinkMode string
+
+const (
+	symlinkTarget symlinkMode = "target" // the content of the file the link points to
+	symlinkLink   symlinkMode = "link"   // the link itself, as a path and its target
+)
+
+// parseSymlinkMode validates a --symlinks value.
+func parseSymlinkMode(s string) (symlinkMode, error) {
+	switch symlinkMode(s) {
+	case "", symlinkTarget:
+		return symlinkTarget, nil
+	case symlinkLink:
+		return symlinkLink, nil
+	}
+	return "", fmt.Errorf("unknown symlink mode %q (available: target, link)", s)
+}
+
+// readEntry describes the directory entry name of dir. Symlinks are
+// resolved so that links to directories are listed as directories, and
+// entries that cannot be read get an error: a symlink whose target is
+// missing, or a link or special file that cannot be opened. Directories are
+// checked when they are read, and regular files by checkReadable when they
+// are selected, so that listing a directory does not open all its files.
+func readEntry(dir string, entry fs.DirEntry) dirEntry {
+	path := filepath.Join(dir, entry.Name())
+	listed := dirEntry{name: entry.Name(), isDir: entry.IsDir()}
+	if entry.Type()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			listed.err = err
+			return listed
+		}
+		listed.link = target
+		info, err := os.Stat(path)
+		if err != nil {
+			listed.err = err
+			if errors.Is(err, fs.ErrNotExist) {
+				listed.err = fmt.Errorf("broken symlink to %s", target)
+			}
+			return listed
+		}
+		listed.target = info
+		listed.isDir = info.IsDir()
+	}
+	if listed.isDir || entry.Type().IsRegular() {
+		return listed
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		listed.err = err
+		return listed
+	}
+	listed.err = file.Close()
+	return listed
+}
+
+// checkReadable reports whether node can be exported, opening a file to
+// find out and recording the error on node when it cannot.
+func checkReadable(node *FileNode) bool {
+	if node.err != nil || node.isDir {
+		return node.err == nil
+	}
+	file, err := os.Open(node.path)
+	if err == nil {
+		err = file.Close()
+	}
+	node.err = err
+	return err == nil
+}
+
+// linkedAncestor returns the directory among node and its ancestors that
+// target is, comparing device and inode, or nil. Entering a symlink to one
+// of them would loop forever.
+func linkedAncestor(node *FileNode, target os.FileInfo, nodeMap map[string]*FileNode) *FileNode {
+	for node != nil {
+		if node.info != nil && os.SameFile(node.info, target) {
+			return node
+		}
+		if node.isRoot {
+			return nil
+		}
+		node = nodeMap[filepath.Dir(node.path)]
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_symlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"real/a.go":     "package real\n",
+		"real/sub/b.go": "package sub\n",
+	})
+	for link, target := range map[string]string{
+		"linked":      "real",
+		"file.go":     "real/a.go",
+		"real/sub/up": "..",
+	} {
+		require.NoError(t, os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))))
+	}
+
+	tests := []struct {
+		name   string
+		follow bool
+		expect func(t *testing.T, node func(string) *FileNode)
+	}{
+		{
+			name:   "not followed",
+			follow: false,
+			expect: func(t *testing.T, node func(string) *FileNode) {
+				t.Helper()
+				linked := node("linked")
+				require.True(t, linked.isDir)
+				require.Equal(t, "real", linked.link)
+				require.True(t, linked.loaded)
+				require.Empty(t, linked.children)
+				require.NoError(t, linked.err)
+				require.Contains(t, linked.String(), "linked → real")
+
+				require.False(t, node("file.go").isDir)
+				require.Equal(t, "real/a.go", node("file.go").link)
+				require.NoError(t, node("real/sub/up").err)
+			},
+		},
+		{
+			name:   "followed",
+			follow: true,
+			expect: func(t *testing.T, node func(string) *FileNode) {
+				t.Helper()
+				require.NotNil(t, node("linked/a.go"))
+				require.NotNil(t, node("linked/sub/b.go"))
+				// Both up links lead back to a directory being walked
+				require.ErrorContains(t, node("real/sub/up").err, "symlink loop")
+				require.Empty(t, node("real/sub/up").children)
+				require.ErrorContains(t, node("linked/sub/up").err, "symlink loop")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &model{workDir: dir, followLinks: tt.follow}
+			require.NoError(t, m.buildFileTree())
+			tt.expect(t, func(rel string) *FileNode {
+				n := m.nodeLookup[filepath.Join(dir, filepath.FromSlash(rel))]
+				require.NotNil(t, n, rel)
+				return n
+			})
+		})
+	}
+
+	t.Run("export", func(t *testing.T) {
+		for mode, expect := range map[symlinkMode]string{
+			symlinkTarget: "# file.go\npackage real\n\n",
+			symlinkLink:   "# file.go (symlink to real/a.go)\n",
+		} {
+			var buf bytes.Buffer
+			opts := packOptions{workDir: dir, include: []string{"file.go"}, symlinks: mode}
+			require.NoError(t, runPack(opts, &buf))
+			require.Equal(t, expect, buf.String(), mode)
+		}
+	})
+}
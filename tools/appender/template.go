@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// templateExt is the extension of user-defined output templates.
+const templateExt = ".tmpl"
+
+// outputTemplate is a user-defined bundle layout. A template file may define
+// three named templates:
+//
+//	{{define "header"}}...{{end}}  rendered once before the files (optional)
+//	{{define "file"}}...{{end}}    rendered once per file (required)
+//	{{define "footer"}}...{{end}}  rendered once after the files (optional)
+//
+// header and footer receive a templateBundle, file receives a templateFile.
+type outputTemplate struct {
+	name   string
+	source string // path of the file the template was loaded from
+	tmpl   *template.Template
+}
+
+// templateBundle is the data passed to the header and footer templates.
+type templateBundle struct {
+	Files  []templateFile
+	Count  int
+	Size   int64
+	Tokens int
+	Tree   string
+}
+
+// templateFile is the data passed to the file template.
+type templateFile struct {
+	Index     int    // 1-based position in the bundle
+	Path      string // path as found on disk
+	RelPath   string // path relative to the working directory
+	Language  string
+	Content   string
+	Lines     int
+	Size      int64
+	Tokens    int
+	Status    string // change status when exporting diffs, e.g. "modified"
+	OldPath   string // previous path of a renamed file
+	Diff      string // unified diff against the diff ref
+	Link      string // target of a symlink exported as a link
+	Encoding  string // original encoding of a file not stored in UTF-8, e.g. "utf-16le"
+	Ranges    string // line ranges of a partially selected file, e.g. "lines 120-180"
+	Inclusion string // "outline" or "path" for a file not exported in full
+}
+
+// templateDirs returns the directories searched for templates, lowest
+// precedence first: the user config directory, then the repository.
+func templateDirs(workDir string) []string {
+	dirs := make([]string, 0, 2)
+	if configDir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(configDir, "appender", "templates"))
+	}
+	return append(dirs, filepath.Join(workDir, ".appender", "templates"))
+}
+
+// loadTemplates parses and validates every template found in dirs. Templates
+// in later directories replace earlier ones with the same name. All problems
+// are reported together so they can be fixed in one pass.
+func loadTemplates(dirs []string) (map[string]*outputTemplate, error) {
+	templates := make(map[string]*outputTemplate)
+	var errs []error
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading template directory %s: %w", dir, err))
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != templateExt {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			tmpl, err := parseTemplate(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			templates[tmpl.name] = tmpl
+		}
+	}
+
+	return templates, errors.Join(errs...)
+}
+
+// parseTemplate reads a template file and checks that it is usable by
+// rendering it against sample data, so that references to unknown fields
+// are caught at startup rather than on export.
+func parseTemplate(path string) (*outputTemplate, error) {
+	name := strings.TrimSuffix(filepath.Base(path), templateExt)
+	if _, ok := formatters[Format(name)]; ok {
+		return nil, fmt.Errorf("template %s: name %q is reserved for a built-in format", path, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", path, err)
+	}
+
+	tmpl, err := template.New(name).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", path, err)
+	}
+	if tmpl.Lookup("file") == nil {
+		return nil, fmt.Errorf(`template %s: missing {{define "file"}} block`, path)
+	}
+
+	t := &outputTemplate{name: name, source: path, tmpl: tmpl}
+	sample := []bundleFile{{
+		Path:     "example/main.go",
+		AbsPath:  "/example/main.go",
+		Language: "go",
+		Size:     13,
+		Content:  "package main\n",
+	}}
+	if err := t.write(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("template %s: %w", path, err)
+	}
+
+	return t, nil
+}
+
+// write renders files using the template.
+func (t *outputTemplate) write(w io.Writer, files []bundleFile) error {
+	bundle := templateBundle{
+		Files: make([]templateFile, 0, len(files)),
+		Count: len(files),
+	}
+	paths := make([]string, 0, len(files))
+	for i, file := range files {
+		tf := templateFile{
+			Index:     i + 1,
+			Path:      file.AbsPath,
+			RelPath:   file.Path,
+			Language:  file.Language,
+			Content:   file.Content,
+			Lines:     countLines(file.Content),
+			Size:      file.Size,
+			Tokens:    file.Tokens,
+			Status:    file.Status,
+			OldPath:   file.OldPath,
+			Diff:      file.Diff,
+			Link:      file.Link,
+			Encoding:  file.Encoding,
+			Ranges:    file.Lines,
+			Inclusion: file.Inclusion,
+		}
+		bundle.Files = append(bundle.Files, tf)
+		bundle.Size += tf.Size
+		bundle.Tokens += tf.Tokens
+		paths = append(paths, file.Path)
+	}
+	bundle.Tree = renderPathTree(paths)
+
+	if header := t.tmpl.Lookup("header"); header != nil {
+		if err := header.Execute(w, bundle); err != nil {
+			return err
+		}
+	}
+	fileTmpl := t.tmpl.Lookup("file")
+	for _, file := range bundle.Files {
+		if err := fileTmpl.Execute(w, file); err != nil {
+			return err
+		}
+	}
+	if footer := t.tmpl.Lookup("footer"); footer != nil {
+		if err := footer.Execute(w, bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerTemplates makes templates selectable as formats, in name order
+// after the built-in formats.
+func registerTemplates(templates map[string]*outputTemplate) {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		formatters[Format(name)] = templates[name].write
+		formatOrder = append(formatOrder, Format(name))
+	}
+}
+
+// countLines returns the number of lines in s, counting a final line that
+// lacks a trailing newline.
+func countLines(s string) int {
+	if s == "" {
+		return 0
+	}
+	n := strings.Count(s, "\n")
+	if !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// renderPathTree draws slash-separated paths as a tree using the same
+// connectors as the file tree in the UI.
+func renderPathTree(paths []string) string {
+	type dir struct {
+		names    []string
+		children map[string]*dir
+	}
+	newDir := func() *dir { return &dir{children: make(map[string]*dir)} }
+
+	root := newDir()
+	for _, path := range paths {
+		current := root
+		parts := strings.Split(path, "/")
+		for i, part := range parts {
+			if i == len(parts)-1 {
+				current.names = append(current.names, part)
+				break
+			}
+			child, ok := current.children[part]
+			if !ok {
+				child = newDir()
+				current.children[part] = child
+				current.names = append(current.names, part+"/")
+			}
+			current = child
+		}
+	}
+
+	var b strings.Builder
+	var walk func(d *dir, prefix string)
+	walk = func(d *dir, prefix string) {
+		names := append([]string{}, d.names...)
+		sort.Strings(names)
+		for i, name := range names {
+			isLast := i == len(names)-1
+			b.WriteString(buildPrefix(prefix, isLast) + name + "\n")
+			if child, ok := d.children[strings.TrimSuffix(name, "/")]; ok && strings.HasSuffix(name, "/") {
+				if isLast {
+					walk(child, prefix+"    ")
+				} else {
+					walk(child, prefix+"│   ")
+				}
+			}
+		}
+	}
+	walk(root, "")
+	return b.String()
+}
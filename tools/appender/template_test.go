@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadTemplates(t *testing.T) {
+	writeTemplate := func(t *testing.T, dir, name, body string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600))
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, userDir, repoDir string)
+		expect func(t *testing.T, templates map[string]*outputTemplate, err error)
+	}{
+		{
+			name: "repo template overrides user template",
+			setup: func(t *testing.T, userDir, repoDir string) {
+				t.Helper()
+				writeTemplate(t, userDir, "brief.tmpl", `{{define "file"}}user {{.RelPath}}{{end}}`)
+				writeTemplate(t, repoDir, "brief.tmpl", `{{define "header"}}{{.Count}} files
+{{.Tree}}{{end}}{{define "file"}}{{.Index}}: {{.RelPath}} ({{.Language}}, {{.Lines}} lines)
+{{end}}`)
+				writeTemplate(t, repoDir, "README.md", "not a template")
+			},
+			expect: func(t *testing.T, templates map[string]*outputTemplate, err error) {
+				t.Helper()
+				require.NoError(t, err)
+				require.Len(t, templates, 1)
+
+				var buf bytes.Buffer
+				require.NoError(t, templates["brief"].write(&buf, []bundleFile{
+					{Path: "a/b.go", Language: "go", Content: "package a\n"},
+					{Path: "c.txt", Language: "text", Content: "one\ntwo"},
+				}))
+				require.Equal(t, "2 files\n├── a/\n│   └── b.go\n└── c.txt\n1: a/b.go (go, 1 lines)\n2: c.txt (text, 2 lines)\n", buf.String())
+			},
+		},
+		{
+			name: "invalid templates are all reported",
+			setup: func(t *testing.T, userDir, repoDir string) {
+				t.Helper()
+				writeTemplate(t, repoDir, "nofile.tmpl", `{{define "header"}}hi{{end}}`)
+				writeTemplate(t, repoDir, "field.tmpl", `{{define "file"}}{{.Missing}}{{end}}`)
+				writeTemplate(t, repoDir, "xml.tmpl", `{{define "file"}}{{end}}`)
+			},
+			expect: func(t *testing.T, templates map[string]*outputTemplate, err error) {
+				t.Helper()
+				require.ErrorContains(t, err, `nofile.tmpl: missing {{define "file"}} block`)
+				require.ErrorContains(t, err, "can't evaluate field Missing")
+				require.ErrorContains(t, err, `name "xml" is reserved`)
+				require.Empty(t, templates)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userDir := filepath.Join(t.TempDir(), "user")
+			repoDir := filepath.Join(t.TempDir(), "repo")
+			tt.setup(t, userDir, repoDir)
+			templates, err := loadTemplates([]string{userDir, repoDir, filepath.Join(t.TempDir(), "missing")})
+			tt.expect(t, templates, err)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrVocabularyNotFound is returned when a BPE vocabulary file is neither
+// embedded in the binary nor present in the user config directory.
+var ErrVocabularyNotFound = errors.New("vocabulary not found")
+
+// vocabFS holds vocabularies bundled at build time. Drop tiktoken files
+// named <encoding>.tiktoken (for example cl100k_base.tiktoken) into the
+// vocab directory before building to embed them.
+//
+//go:embed vocab
+var vocabFS embed.FS
+
+// The pre-tokenization patterns of the tiktoken encodings. RE2 has no
+// lookahead, so the `\s+(?!\S)` alternative of the originals is emulated in
+// split.
+const (
+	cl100kPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`
+	o200kPattern  = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+`
+)
+
+var (
+	vocabMu    sync.Mutex
+	vocabCache = make(map[string]map[string]int)
+)
+
+// bpe is a byte-pair encoder driven by a tiktoken rank table.
+type bpe struct {
+	name    string
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+func newBPE(name, encoding, pattern string) (*bpe, error) {
+	ranks, err := LoadVocabulary(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &bpe{
+		name:    name,
+		ranks:   ranks,
+		pattern: regexp.MustCompile(`^(?:` + pattern + `)`),
+	}, nil
+}
+
+// LoadVocabulary returns the rank table for a tiktoken encoding such as
+// "cl100k_base". Embedded vocabularies are preferred; otherwise the file is
+// read from $XDG_CONFIG_HOME/appender/tokenizers/<encoding>.tiktoken.
+// Tables are parsed once and shared.
+func LoadVocabulary(encoding string) (map[string]int, error) {
+	vocabMu.Lock()
+	defer vocabMu.Unlock()
+	if ranks, ok := vocabCache[encoding]; ok {
+		return ranks, nil
+	}
+
+	fileName := encoding + ".tiktoken"
+	data, err := vocabFS.ReadFile("vocab/" + fileName)
+	if err != nil {
+		configDir, cfgErr := os.UserConfigDir()
+		if cfgErr != nil {
+			return nil, fmt.Errorf("%s: %w", encoding, ErrVocabularyNotFound)
+		}
+		data, err = os.ReadFile(filepath.Join(configDir, "appender", "tokenizers", fileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", encoding, ErrVocabularyNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s vocabulary: %w", encoding, err)
+		}
+	}
+
+	ranks, err := parseVocabulary(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s vocabulary: %w", encoding, err)
+	}
+	vocabCache[encoding] = ranks
+	return ranks, nil
+}
+
+// parseVocabulary reads the tiktoken format: one "<base64 token> <rank>"
+// pair per line.
+func parseVocabulary(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		token, rank, ok := bytes.Cut(line, []byte{' '})
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"<token> <rank>\"", lineNo)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(token))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		n, err := strconv.Atoi(string(rank))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		ranks[string(decoded)] = n
+	}
+	return ranks, scanner.Err()
+}
+
+// Name implements Tokenizer.
+func (t *bpe) Name() string { return t.name }
+
+// Count implements Tokenizer.
+func (t *bpe) Count(text string) int {
+	count := 0
+	for _, piece := range t.split(text) {
+		if _, ok := t.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += t.mergeCount(piece)
+	}
+	return count
+}
+
+// split breaks text into the pieces that are encoded independently.
+func (t *bpe) split(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := t.pattern.FindStringIndex(text)
+		end := 1
+		if loc != nil && loc[1] > 0 {
+			end = loc[1]
+		}
+		piece := text[:end]
+
+		// Emulate `\s+(?!\S)`: a run of spaces followed by a word leaves its
+		// last space to be joined with that word.
+		if end < len(text) && isSpaceRun(piece) {
+			if _, size := utf8.DecodeLastRuneInString(piece); size < len(piece) {
+				end -= size
+				piece = text[:end]
+			}
+		}
+
+		pieces = append(pieces, piece)
+		text = text[end:]
+	}
+	return pieces
+}
+
+func isSpaceRun(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) || r == '\n' || r == '\r' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// mergeCount applies byte-pair merges to piece, always merging the adjacent
+// pair with the lowest rank, and returns the number of resulting tokens.
+func (t *bpe) mergeCount(piece string) int {
+	// bounds[i] is the start offset of the i-th part; the final element
+	// marks the end of the piece.
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(bounds)-2; i++ {
+			rank, ok := t.ranks[piece[bounds[i]:bounds[i+2]]]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+
+	return len(bounds) - 1
+}
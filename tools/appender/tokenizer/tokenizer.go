@@ -0,0 +1,75 @@
+// Package tokenizer counts LLM tokens in text. It provides byte-pair
+// encoders compatible with the tiktoken cl100k_base and o200k_base
+// vocabularies and a cheap length-based estimator that is used when no
+// vocabulary is available.
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tokenizer counts the tokens a model would see for a piece of text.
+type Tokenizer interface {
+	// Name identifies the tokenizer in the UI.
+	Name() string
+	// Count returns the number of tokens in text.
+	Count(text string) int
+}
+
+const (
+	// Estimate selects the length-based Estimator.
+	Estimate = "estimate"
+	// CL100K selects the cl100k_base encoding used by GPT-4 era models.
+	CL100K = "cl100k"
+	// O200K selects the o200k_base encoding used by GPT-4o era models.
+	O200K = "o200k"
+)
+
+// Names lists the tokenizers accepted by New.
+var Names = []string{CL100K, O200K, Estimate}
+
+// New returns the tokenizer called name. BPE tokenizers need their
+// vocabulary, which is looked up with LoadVocabulary; if it cannot be found
+// the error wraps ErrVocabularyNotFound so callers can fall back to the
+// Estimator.
+func New(name string) (Tokenizer, error) {
+	switch strings.ToLower(name) {
+	case Estimate:
+		return Estimator{}, nil
+	case CL100K:
+		return newBPE(CL100K, "cl100k_base", cl100kPattern)
+	case O200K:
+		return newBPE(O200K, "o200k_base", o200kPattern)
+	default:
+		return nil, fmt.Errorf("unknown tokenizer %q (available: %s)", name, strings.Join(Names, ", "))
+	}
+}
+
+// Estimator approximates token counts at four bytes per token, which is
+// close to the average for English prose and source code.
+type Estimator struct{}
+
+// Name implements Tokenizer.
+func (Estimator) Name() string { return Estimate }
+
+// Count implements Tokenizer.
+func (Estimator) Count(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// Format renders a token count compactly, e.g. 950, 3.4k or 1.2M.
+func Format(tokens int) string {
+	switch {
+	case tokens < 1000:
+		return fmt.Sprintf("%d", tokens)
+	case tokens < 1_000_000:
+		return trimZero(fmt.Sprintf("%.1f", float64(tokens)/1000)) + "k"
+	default:
+		return trimZero(fmt.Sprintf("%.1f", float64(tokens)/1_000_000)) + "M"
+	}
+}
+
+func trimZero(s string) string {
+	return strings.TrimSuffix(s, ".0")
+}
@@ -0,0 +1,88 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBPE(t *testing.T, tokens ...string) *bpe {
+	t.Helper()
+	var vocab strings.Builder
+	rank := 0
+	for b := range 256 {
+		fmt.Fprintf(&vocab, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), rank)
+		rank++
+	}
+	for _, tok := range tokens {
+		fmt.Fprintf(&vocab, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(tok)), rank)
+		rank++
+	}
+	ranks, err := parseVocabulary([]byte(vocab.String()))
+	require.NoError(t, err)
+	return &bpe{name: "test", ranks: ranks, pattern: regexp.MustCompile(`^(?:` + cl100kPattern + `)`)}
+}
+
+func Test_bpe_split(t *testing.T) {
+	tok := testBPE(t)
+	tests := []struct {
+		text   string
+		expect []string
+	}{
+		{text: "hello world", expect: []string{"hello", " world"}},
+		{text: "a   b", expect: []string{"a", "  ", " b"}},
+		{text: "x := 12345\n", expect: []string{"x", " :=", " ", "123", "45", "\n"}},
+		{text: "it's", expect: []string{"it", "'s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			require.Equal(t, tt.expect, tok.split(tt.text))
+		})
+	}
+}
+
+func Test_bpe_Count(t *testing.T) {
+	tok := testBPE(t, "he", "ll", "hell", "hello", " w", " wo")
+	tests := []struct {
+		text   string
+		expect int
+	}{
+		{text: "", expect: 0},
+		{text: "hello", expect: 1},
+		{text: "hell", expect: 1},
+		{text: "help", expect: 3},        // he + l + p
+		{text: "hello world", expect: 5}, // hello + " wo" + r + l + d
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			require.Equal(t, tt.expect, tok.Count(tt.text))
+		})
+	}
+}
+
+func Test_Format(t *testing.T) {
+	tests := map[int]string{
+		0:         "0",
+		999:       "999",
+		1000:      "1k",
+		3450:      "3.5k",
+		180_000:   "180k",
+		1_200_000: "1.2M",
+	}
+	for tokens, expect := range tests {
+		require.Equal(t, expect, Format(tokens))
+	}
+}
+
+func Test_New(t *testing.T) {
+	tok, err := New("estimate")
+	require.NoError(t, err)
+	require.Equal(t, 3, tok.Count("0123456789"))
+
+	_, err = New("nope")
+	require.ErrorContains(t, err, "unknown tokenizer")
+}
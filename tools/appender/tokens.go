@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
+)
+
+// tokensCountedMsg carries per-file token counts computed in the background.
+type tokensCountedMsg struct {
+	counts map[string]int
+	files  []string // the files counted when not the whole tree was
+}
+
+// newTokenizer returns the tokenizer called name. An empty name asks for the
+// default: cl100k when its vocabulary is installed, the estimator otherwise.
+// A BPE tokenizer asked for by name fails without its vocabulary.
+func newTokenizer(name string) (tokenizer.Tokenizer, error) {
+	if name == "" {
+		tok, err := tokenizer.New(tokenizer.CL100K)
+		if errors.Is(err, tokenizer.ErrVocabularyNotFound) {
+			slog.Warn("tokenizer vocabulary unavailable, estimating tokens", "tokenizer", tokenizer.CL100K, "error", err)
+			return estimateFallback{missing: tokenizer.CL100K}, nil
+		}
+		return tok, err
+	}
+	tok, err := tokenizer.New(name)
+	if errors.Is(err, tokenizer.ErrVocabularyNotFound) {
+		return nil, fmt.Errorf("%w: add it or pick --tokenizer %s", err, tokenizer.Estimate)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w (available: %v)", err, tokenizer.Names)
+	}
+	return tok, nil
+}
+
+// estimateFallback is the estimator standing in for the default tokenizer
+// when its vocabulary is missing, so the status line can say so.
+type estimateFallback struct {
+	tokenizer.Estimator
+	missing string // the tokenizer whose vocabulary was not found
+}
+
+// countTokens returns the number of tokens in content using the model's
+// tokenizer.
+func (m *model) countTokens(content string) int {
+	if m.tokenizer == nil {
+		return tokenizer.Estimator{}.Count(content)
+	}
+	return m.tokenizer.Count(content)
+}
+
+// countTokensCmd counts the tokens of every text file in the tree off the
+// UI goroutine. Paths are gathered up front so the command never touches
+// the tree itself.
+func (m *model) countTokensCmd() tea.Cmd {
+	var paths []string
+	var gather func(node *FileNode)
+	gather = func(node *FileNode) {
+		if !node.isDir {
+			paths = append(paths, node.path)
+			return
+		}
+		for _, child := range node.children {
+			gather(child)
+		}
+	}
+	gather(m.rootNode)
+	return m.countPathsCmd(paths, false)
+}
+
+// countFileTokensCmd recounts the tokens of the files at paths, leaving the
+// counts of the other files as they are.
+func (m *model) countFileTokensCmd(paths []string) tea.Cmd {
+	if len(paths) == 0 {
+		return nil
+	}
+	return m.countPathsCmd(paths, true)
+}
+
+func (m *model) countPathsCmd(paths []string, partial bool) tea.Cmd {
+	return func() tea.Msg {
+		counts := make(map[string]int, len(paths))
+		for _, path := range paths {
+			meta, err := m.index.lookup(path)
+			if err != nil || m.classifier.classOf(path, meta) == classBinary {
+				continue
+			}
+			if meta.Counted {
+				counts[path] = meta.Tokens
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			content, _ := decodeText(data)
+			counts[path] = m.countTokens(content)
+			m.index.setCounts(path, meta, countLines(content), counts[path])
+		}
+		msg := tokensCountedMsg{counts: counts}
+		if partial {
+			msg.files = paths
+		}
+		return msg
+	}
+}
+
+// applyTokenCounts stores counts on the file nodes and sums them into their
+// parent directories.
+func (m *model) applyTokenCounts(counts map[string]int) {
+	var apply func(node *FileNode)
+	apply = func(node *FileNode) {
+		if !node.isDir {
+			node.tokens = counts[node.path]
+			node.outlineTokens = 0
+			return
+		}
+		for _, child := range node.children {
+			apply(child)
+		}
+	}
+	apply(m.rootNode)
+	m.tokensCounted = true
+	m.aggregateTokens()
+}
+
+// applyFileTokenCounts stores the counts of some files and updates the
+// directory totals.
+func (m *model) applyFileTokenCounts(counts map[string]int, files []string) {
+	for _, path := range files {
+		if node, ok := m.nodeLookup[path]; ok && !node.isDir {
+			node.tokens = counts[path]
+			node.outlineTokens = 0
+		}
+	}
+	m.aggregateTokens()
+}
+
+// aggregateTokens recomputes directory token totals from their files,
+// counting only children that the hidden, ignore and class settings would
+// show so that a directory's total matches what selecting it exports.
+func (m *model) aggregateTokens() {
+	var filters []FilterFunc
+	if m.removeHidden {
+		filters = append(filters, FilterHidden)
+	}
+	if !m.showIgnored {
+		filters = append(filters, FilterIgnored)
+	}
+	if len(m.hiddenClasses) > 0 {
+		filters = append(filters, m.classFilter())
+	}
+
+	var sum func(node *FileNode) int
+	sum = func(node *FileNode) int {
+		if !node.isDir {
+			return node.tokens
+		}
+		total := 0
+		for _, child := range node.children {
+			if include(child, filters...) {
+				total += sum(child)
+			}
+		}
+		node.tokens = total
+		return total
+	}
+	sum(m.rootNode)
+}
+
+// selectionStats returns the number of selected files and their total
+// token count.
+func (m *model) selectionStats() (files, tokens int) {
+	var walk func(node *FileNode)
+	walk = func(node *FileNode) {
+		if node.selected && !node.isDir {
+			files++
+			tokens += m.exportTokens(node)
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(m.rootNode)
+	return files, tokens
+}
+
+// statusLine summarizes the current selection for display above the help,
+// followed by the notice from the last command if there is one.
+func (m *model) statusLine() string {
+	status := m.selectionLine()
+	if n := len(m.unreadableNodes()); n > 0 {
+		status += fmt.Sprintf(" · %d unreadable (%s to review)", n, m.keys.Diagnose.Help().Key)
+	}
+	if m.loadsPending > 0 {
+		status += fmt.Sprintf(" · %s loading %d directories…", m.spinner.View(), m.loadsPending)
+	}
+	if m.notice != "" {
+		status += " · " + m.notice
+	}
+	return status
+}
+
+func (m *model) selectionLine() string {
+	files, tokens := m.selectionStats()
+	name := tokenizer.Estimate
+	if m.tokenizer != nil {
+		name = m.tokenizer.Name()
+	}
+	if fallback, ok := m.tokenizer.(estimateFallback); ok {
+		name = fmt.Sprintf("%s, no %s vocabulary", name, fallback.missing)
+	}
+	if !m.tokensCounted {
+		return fmt.Sprintf("%d files selected · counting tokens…", files)
+	}
+	if m.budget > 0 {
+		return fmt.Sprintf("%d files selected · %s (%s)", files, budgetSummary(tokens, m.budget), name)
+	}
+	return fmt.Sprintf("%d files selected · %s tokens (%s)", files, tokenizer.Format(tokens), name)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newTokenizerFallback(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if _, err := tokenizer.New(tokenizer.CL100K); err == nil {
+		t.Skip("the cl100k vocabulary is embedded")
+	}
+
+	// Asking for a BPE tokenizer by name needs its vocabulary
+	_, err := newTokenizer(tokenizer.CL100K)
+	require.ErrorIs(t, err, tokenizer.ErrVocabularyNotFound)
+	_, err = newTokenizer(tokenizer.O200K)
+	require.ErrorIs(t, err, tokenizer.ErrVocabularyNotFound)
+
+	// The default estimates instead, and the status line says so
+	tok, err := newTokenizer("")
+	require.NoError(t, err)
+	require.Equal(t, tokenizer.Estimate, tok.Name())
+	m := &model{workDir: t.TempDir(), tokenizer: tok, tokensCounted: true}
+	require.NoError(t, m.buildFileTree())
+	require.Equal(t, "0 files selected · 0 tokens (estimate, no cl100k vocabulary)", m.selectionLine())
+
+	tok, err = newTokenizer(tokenizer.Estimate)
+	require.NoError(t, err)
+	m.tokenizer = tok
+	require.Equal(t, "0 files selected · 0 tokens (estimate)", m.selectionLine())
+}
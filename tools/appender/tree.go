@@ -2,21 +2,35 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
 )
 
 type FileNode struct {
-	name     string // name represents the name of the file or directory
-	path     string // path represents the full path of the file or directory
-	isDir    bool   // isDir is used to identify directories
-	isRoot   bool   // isRoot is only used to identify the root node.
-	expanded bool   // expanded is used to show/hide the children of a directory
-	selected bool
-	prefix   string      // prefix is used in the View method to draw the tree structure
-	children []*FileNode // includes directories and files
+	name          string // name represents the name of the file or directory
+	path          string // path represents the full path of the file or directory
+	isDir         bool   // isDir is used to identify directories
+	isRoot        bool   // isRoot is only used to identify the root node.
+	expanded      bool   // expanded is used to show/hide the children of a directory
+	selected      bool
+	ignored       bool        // ignored is set when an ignore file excludes the node
+	loaded        bool        // loaded is set once the children of a directory have been read
+	err           error       // err is set when the entry could not be read; it cannot be selected
+	deleted       bool        // deleted marks a placeholder for a file git reports as deleted; it cannot be selected
+	link          string      // link is the target of a symlink, as written in the link
+	info          os.FileInfo // info identifies a directory that has been read, to detect symlink loops
+	meta          *fileMeta   // meta caches what is known about a file until the tree is refreshed
+	tokens        int         // tokens is the token count of a file, or the sum over a directory's files
+	ranges        []lineRange // ranges are the only lines of a selected file that are exported
+	rangeTokens   int         // rangeTokens is the token count of the lines in ranges
+	inclusion     inclusion   // inclusion is how much of the file, or of the files below, is exported
+	outlineTokens int         // outlineTokens is the token count of a Go file's outline, once counted
+	prefix        string      // prefix is used in the View method to draw the tree structure
+	children      []*FileNode // includes directories and files
 }
 
 func (node *FileNode) String() string {
@@ -34,64 +48,131 @@ func (node *FileNode) String() string {
 		selected = "  "
 	}
 
-	return fmt.Sprintf("%s%s%s%s", node.prefix, dirIndicator, node.name, selected)
+	tokens := ""
+	if node.tokens > 0 {
+		tokens = " (" + tokenizer.Format(node.tokens) + ")"
+	}
+
+	link := ""
+	if node.link != "" {
+		link = " → " + node.link
+	}
+
+	return fmt.Sprintf("%s%s%s%s%s%s", node.prefix, dirIndicator, node.name, link, tokens, selected)
 }
 
+// visitNode reads the directory at node.path and recursively builds its
+// children. When ignore is non-nil, entries it matches are marked ignored
+// and ignored directories are only entered if ignore.descendIgnored is set.
+// Directories and files that cannot be read keep the error in node.err and
+// the walk goes on. Symlinks to directories are entered if followLinks is
+// set and they do not lead back to a directory being walked.
 func visitNode(
 	node *FileNode,
 	prefix string,
 	removeHidden bool,
+	followLinks bool,
 	nodeMap map[string]*FileNode,
-) error {
-	// Read directory contents
-	entries, err := os.ReadDir(node.path)
-	if err != nil {
-		log.Printf("Error reading directory %s: %v", node.path, err)
-		return err
+	ignore *ignoreMatcher,
+) {
+	listing := readListing(node.path, false)
+	node.info = listing.info
+	node.err = listing.err
+	if listing.err != nil {
+		slog.Warn("reading directory", "path", node.path, "error", listing.err)
+		node.children = nil
+		node.loaded = true
+		return
 	}
 
-	// Process each entry in the directory
-	for i, entry := range entries {
-		// if removeHidden && strings.HasPrefix(entry.Name(), ".") {
-		// 	continue
-		// }
+	if ignore != nil {
+		ignore.setRules(node.path, listing.rules)
+	}
+	for _, childNode := range addChildren(node, listing.entries, prefix, followLinks, nodeMap, ignore) {
+		visitNode(childNode, childIndent(childNode), removeHidden, followLinks, nodeMap, ignore)
+	}
+}
 
+// addChildren replaces the children of node with entries, reusing the nodes
+// already in nodeMap so that their state survives, and marks node loaded. It
+// returns the child directories the walk should enter: ignored directories
+// are skipped unless ignore.descendIgnored is set, and symlinks to
+// directories unless followLinks is set and they do not loop.
+func addChildren(
+	node *FileNode,
+	entries []dirEntry,
+	prefix string,
+	followLinks bool,
+	nodeMap map[string]*FileNode,
+	ignore *ignoreMatcher,
+) []*FileNode {
+	// Children are rebuilt from scratch so that revisiting a node reused
+	// from nodeMap does not duplicate them.
+	node.children = nil
+	node.loaded = true
+
+	var descend []*FileNode
+	for i, entry := range entries {
 		isLast := i == len(entries)-1
 
 		// Create child node
-		childPath := filepath.Join(node.path, entry.Name())
+		childPath := filepath.Join(node.path, entry.name)
 		childNode, found := nodeMap[childPath]
-		if !found {
+		if !found || childNode.isDir != entry.isDir {
 			childNode = &FileNode{
-				name:     entry.Name(),
+				name:     entry.name,
 				path:     childPath,
-				isDir:    entry.IsDir(),
-				prefix:   buildPrefix(prefix, isLast),
+				isDir:    entry.isDir,
 				expanded: false,
 			}
 		}
+		childNode.prefix = buildPrefix(prefix, isLast)
+		childNode.link = entry.link
+		if !childNode.isDir || entry.link != "" {
+			// Directories record their own error when they are read
+			childNode.err = entry.err
+		}
+		childNode.ignored = ignore != nil && ignore.ignored(childPath, childNode.isDir)
 
 		// Add child to parent's children
 		node.children = append(node.children, childNode)
 		nodeMap[childPath] = childNode
-		// If it's a directory, recursively visit it
-		if childNode.isDir {
-			// Calculate new prefix for children of this directory
-			newPrefix := prefix
-			if isLast {
-				newPrefix += "    " // 4 spaces for alignment when last item
-			} else {
-				newPrefix += "│   " // vertical line + 3 spaces for non-last items
+		if !childNode.isDir {
+			continue
+		}
+		if childNode.ignored && !ignore.descendIgnored {
+			childNode.children = nil
+			childNode.loaded = false
+			continue
+		}
+		if entry.link != "" {
+			if followLinks {
+				if ancestor := linkedAncestor(node, entry.target, nodeMap); ancestor != nil {
+					childNode.err = fmt.Errorf("symlink loop: links back to %s", ancestor.path)
+				}
 			}
-
-			if err := visitNode(childNode, newPrefix, removeHidden, nodeMap); err != nil {
-				log.Printf("Error visiting directory %s: %v", childPath, err)
-				return err
+			if !followLinks || childNode.err != nil {
+				// Shown with its target but never entered
+				childNode.children = nil
+				childNode.loaded = true
+				continue
 			}
 		}
+		descend = append(descend, childNode)
 	}
+	return descend
+}
 
-	return nil
+// childIndent returns the prefix the children of node are drawn with,
+// continuing the vertical line of node unless it is the last entry.
+func childIndent(node *FileNode) string {
+	switch {
+	case strings.HasSuffix(node.prefix, "└── "):
+		return strings.TrimSuffix(node.prefix, "└── ") + "    "
+	case strings.HasSuffix(node.prefix, "├── "):
+		return strings.TrimSuffix(node.prefix, "├── ") + "│   "
+	}
+	return ""
 }
 
 // buildPrefix creates the proper prefix for tree visualization.
@@ -109,6 +190,12 @@ func FilterHidden(node *FileNode) bool {
 	return isHidden
 }
 
+// FilterIgnored excludes nodes matched by .gitignore, .ignore or
+// .appenderignore files.
+func FilterIgnored(node *FileNode) bool {
+	return node.ignored
+}
+
 func include(node *FileNode, filters ...FilterFunc) bool {
 	for _, filter := range filters {
 		if filter(node) {
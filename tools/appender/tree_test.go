@@ -2,17 +2,23 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
 func Test_visitNode(t *testing.T) {
+	broken := t.TempDir()
+	writeFiles(t, broken, map[string]string{"ok.go": "package ok\n", "sub/fine.go": "package sub\n"})
+	require.NoError(t, os.Symlink("missing.go", filepath.Join(broken, "link.go")))
+
 	tests := []struct {
 		name   string
 		node   *FileNode
 		prefix string
-		expect func(t *testing.T, node *FileNode, err error)
+		expect func(t *testing.T, node *FileNode, nm map[string]*FileNode)
 	}{
 		{
 			name: "./testdata",
@@ -24,17 +30,41 @@ func Test_visitNode(t *testing.T) {
 				expanded: true,
 			},
 			prefix: "",
-			expect: func(t *testing.T, node *FileNode, err error) {
+			expect: func(t *testing.T, node *FileNode, _ map[string]*FileNode) {
+				t.Helper()
+				require.NoError(t, node.err)
+			},
+		},
+		{
+			name:   "broken symlink",
+			node:   &FileNode{name: "broken", path: broken, isDir: true, isRoot: true, expanded: true},
+			prefix: "",
+			expect: func(t *testing.T, node *FileNode, nm map[string]*FileNode) {
+				t.Helper()
+				require.NoError(t, node.err)
+				require.EqualError(t, nm[filepath.Join(broken, "link.go")].err, "broken symlink to missing.go")
+				require.NoError(t, nm[filepath.Join(broken, "ok.go")].err)
+				require.NotNil(t, nm[filepath.Join(broken, "sub", "fine.go")])
+			},
+		},
+		{
+			name:   "unreadable directory",
+			node:   &FileNode{name: "gone", path: filepath.Join(broken, "gone"), isDir: true, isRoot: true, expanded: true},
+			prefix: "",
+			expect: func(t *testing.T, node *FileNode, _ map[string]*FileNode) {
 				t.Helper()
-				require.NoError(t, err)
+				require.ErrorIs(t, node.err, os.ErrNotExist)
+				require.Equal(t, "no such file or directory", readError(node.err))
+				require.True(t, node.loaded)
+				require.Empty(t, node.children)
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			nm := make(map[string]*FileNode)
-			err := visitNode(tt.node, tt.prefix, true, nm)
-			tt.expect(t, tt.node, err)
+			visitNode(tt.node, tt.prefix, true, false, nm, nil)
+			tt.expect(t, tt.node, nm)
 
 			nodes := tt.node.flatten(nm)
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/jongschneider/ai-toolbox/tools/appender/tokenizer"
 )
 
 func (m *model) View() string {
@@ -15,11 +16,18 @@ func (m *model) View() string {
 			BorderForeground(lipgloss.Color("62")).
 			Padding(1)
 
-		modal := modalStyle.Render(
-			"Save output to file\n\n" +
-				m.outputPath.View() + "\n\n" +
-				"[enter to save, esc to cancel]",
-		)
+		dialog := "Save output to file\n\n" +
+			m.outputPath.View() + "\n\n" +
+			fmt.Sprintf("Format: %s · Content: %s\n\n", m.outputFormat(), m.contentLabel()) +
+			"[enter to save, tab: format, shift+tab: content, esc to cancel]"
+		if m.budgetConfirmed {
+			dialog += "\n\n" + m.overBudgetWarning("enter")
+		}
+		if m.saveError != nil {
+			dialog += fmt.Sprintf("\n\nError saving output:\n%v", m.saveError)
+		}
+
+		modal := modalStyle.Render(dialog)
 
 		return lipgloss.Place(
 			m.windowSize.width,
@@ -39,9 +47,16 @@ func (m *model) View() string {
 			Padding(1)
 
 		dialog := "Copy selected files to clipboard?\n\n" +
+			fmt.Sprintf("Format: %s\nContent: %s\n\n", m.outputFormat(), m.contentLabel()) +
 			"y - copy\n" +
+			"tab - change format\n" +
+			"shift+tab - change content\n" +
 			"n - cancel"
 
+		if m.budgetConfirmed {
+			dialog += "\n\n" + m.overBudgetWarning("y")
+		}
+
 		if m.clipboardError != nil {
 			dialog = fmt.Sprintf(
 				"Error copying to clipboard:\n%v\n\n[press any key to continue]",
@@ -82,6 +97,52 @@ func (m *model) View() string {
 		contentStyle.Render(m.rightViewport.View()),
 	)
 
-	// Add help view at the bottom
-	return fmt.Sprintf("%s\n%s", mainView, m.help.View(m.keys))
+	// Add selection status and help view at the bottom
+	statusColor := lipgloss.Color("62")
+	if _, tokens := m.selectionStats(); overBudget(tokens, m.budget) {
+		statusColor = lipgloss.Color("196")
+	}
+	if m.profiles != nil {
+		status := lipgloss.NewStyle().Foreground(statusColor).Render(m.selectionLine())
+		return fmt.Sprintf("%s\n%s  %s", mainView, status, m.help.View(profileKeys))
+	}
+	if m.diagnostics != nil {
+		status := lipgloss.NewStyle().Foreground(statusColor).Render(fmt.Sprintf("%d unreadable", len(m.diagnostics.nodes)))
+		return fmt.Sprintf("%s\n%s  %s", mainView, status, m.help.View(diagnosticsKeys))
+	}
+	if m.rangeSelect != nil {
+		status := lipgloss.NewStyle().Foreground(statusColor).Render(m.rangeSelect.rangeStatus())
+		return fmt.Sprintf("%s\n%s  %s", mainView, status, m.help.View(rangeKeys))
+	}
+	if m.apply != nil {
+		status := lipgloss.NewStyle().Foreground(statusColor).Render(fmt.Sprintf("%d files in response", len(m.apply.results)))
+		return fmt.Sprintf("%s\n%s  %s", mainView, status, m.help.View(applyKeys))
+	}
+	status := lipgloss.NewStyle().Foreground(statusColor).Render(m.statusLine())
+	return fmt.Sprintf("%s\n%s  %s", mainView, status, m.help.View(m.keys))
+}
+
+// overBudgetWarning asks the user to press confirmKey again to export a
+// selection that exceeds the token budget.
+func (m *model) overBudgetWarning(confirmKey string) string {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(
+		fmt.Sprintf("Selection is %s tokens over the %s budget.\nPress %s again to export anyway.",
+			tokenizer.Format(m.exportTotal-m.budget), tokenizer.Format(m.budget), confirmKey),
+	)
+}
+
+// contentLabel describes the content mode for the export dialogs.
+func (m *model) contentLabel() string {
+	switch m.contentMode {
+	case contentDiff, contentBoth:
+		ref := m.diffRef
+		if ref == "" && m.sinceRef != "" {
+			ref = "merge base with " + m.sinceRef
+		} else if ref == "" {
+			ref = "HEAD"
+		}
+		return fmt.Sprintf("%s vs %s", m.contentMode, ref)
+	default:
+		return string(contentFull)
+	}
 }
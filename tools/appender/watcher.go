@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long the watcher waits for events to stop before
+// reporting them, so that a save or a checkout is handled once.
+const watchDebounce = 200 * time.Millisecond
+
+// fsChangedMsg reports the changes seen by the watcher since the last
+// message.
+type fsChangedMsg struct {
+	dirs  map[string]bool // directories whose entries were created, removed or renamed
+	files map[string]bool // files whose content or mode changed
+}
+
+// fsWatcher watches the directories of the tree that have been read.
+// fsnotify watches are not recursive, so every directory is added as it is
+// loaded.
+type fsWatcher struct {
+	watcher *fsnotify.Watcher
+	changes chan fsChangedMsg
+	done    chan struct{}
+	full    bool // adding a watch failed, most likely because of the system limit
+}
+
+// newFSWatcher starts a watcher that reports changes debounced by delay.
+func newFSWatcher(delay time.Duration) (*fsWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &fsWatcher{
+		watcher: watcher,
+		changes: make(chan fsChangedMsg),
+		done:    make(chan struct{}),
+	}
+	go w.run(delay)
+	return w, nil
+}
+
+func (w *fsWatcher) run(delay time.Duration) {
+	pending := fsChangedMsg{dirs: map[string]bool{}, files: map[string]bool{}}
+	var timer <-chan time.Time
+	var out chan fsChangedMsg // set once events have settled
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				pending.dirs[filepath.Dir(event.Name)] = true
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Chmod) {
+				pending.files[event.Name] = true
+			}
+			timer, out = time.After(delay), nil
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("watching files", "error", err)
+		case <-timer:
+			timer, out = nil, w.changes
+		case out <- pending:
+			pending = fsChangedMsg{dirs: map[string]bool{}, files: map[string]bool{}}
+			out = nil
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// add watches dir. Once the system limit on watches is reached, the
+// remaining directories are not watched.
+func (w *fsWatcher) add(dir string) {
+	if w.full {
+		return
+	}
+	if err := w.watcher.Add(dir); err != nil {
+		slog.Warn("not watching any more directories", "path", dir, "error", err)
+		w.full = true
+	}
+}
+
+// stop ends the watcher.
+func (w *fsWatcher) stop() {
+	close(w.done)
+	w.watcher.Close()
+}
+
+// wait returns a command delivering the next changes as an fsChangedMsg.
+func (w *fsWatcher) wait() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case msg := <-w.changes:
+			return msg
+		case <-w.done:
+			return nil
+		}
+	}
+}
+
+// startWatcher watches the directories read so far; the others are added
+// by applyListing as they are read. Without a watcher the tree simply does
+// not follow changes.
+func (m *model) startWatcher() {
+	watcher, err := newFSWatcher(watchDebounce)
+	if err != nil {
+		slog.Warn("not watching for changes", "error", err)
+		return
+	}
+	m.watcher = watcher
+	for path, node := range m.nodeLookup {
+		if node.isDir && node.loaded && node.err == nil {
+			watcher.add(path)
+		}
+	}
+}
+
+// handleFSChanges reads the changed directories again, keeping the state of
+// the entries that are still there, recounts the tokens of the files
+// involved and refreshes the preview when the selection or the content of a
+// selected file changed.
+func (m *model) handleFSChanges(msg fsChangedMsg) tea.Cmd {
+	selectedBefore := len(m.selectedFilePaths())
+	refresh := false
+	var recount []string
+	// A file saved through a temporary file and a rename only shows up as a
+	// change to its directory, so the files of reloaded directories count as
+	// changed too.
+	changed := make(map[string]bool, len(msg.files))
+	for path := range msg.files {
+		changed[path] = true
+	}
+	for dir := range msg.dirs {
+		node, ok := m.nodeLookup[dir]
+		if !ok || !node.isDir || !node.loaded {
+			// Not read yet: the loader will see the new entries
+			continue
+		}
+		m.reloadDir(dir)
+		for _, child := range node.children {
+			if !child.isDir {
+				changed[child.path] = true
+			}
+		}
+	}
+	for path := range changed {
+		node, ok := m.nodeLookup[path]
+		if !ok || node.isDir {
+			continue
+		}
+		node.meta = nil
+		node.outlineTokens = 0
+		recount = append(recount, path)
+		if node.selected {
+			refresh = true
+		}
+		if len(node.ranges) > 0 {
+			m.setRanges(node, node.ranges)
+		}
+	}
+	if len(m.selectedFilePaths()) != selectedBefore {
+		refresh = true
+	}
+
+	if m.findPattern.Value() != "" {
+		m.refreshMatches()
+	}
+	m.flattenTree()
+	if m.cursor >= len(m.flatNodes) {
+		m.cursor = max(len(m.flatNodes)-1, 0)
+	}
+
+	cmds := []tea.Cmd{m.watcher.wait(), m.updateTree(), m.countFileTokensCmd(recount), m.loadGitStatusCmd()}
+	if refresh {
+		cmds = append(cmds, m.updateContent())
+	}
+	return tea.Batch(cmds...)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fsWatcher(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/keep.go":   "package a\n",
+		"a/remove.go": "package a\n",
+		"b/edit.go":   "package b\n",
+	})
+
+	renderer, err := glamour.NewTermRenderer(glamour.WithStandardStyle("notty"))
+	require.NoError(t, err)
+	m := &model{workDir: dir, findPattern: initFindInput(), renderer: renderer}
+	require.NoError(t, m.buildFileTree())
+	m.flattenTree()
+	m.startWatcher()
+	require.NotNil(t, m.watcher)
+	t.Cleanup(m.watcher.stop)
+
+	node := func(rel string) *FileNode {
+		return m.nodeLookup[filepath.Join(dir, filepath.FromSlash(rel))]
+	}
+	// next waits for the watcher to report the changes made by change.
+	next := func(t *testing.T, change func()) fsChangedMsg {
+		t.Helper()
+		change()
+		msgs := make(chan fsChangedMsg, 1)
+		go func() { msgs <- m.watcher.wait()().(fsChangedMsg) }()
+		select {
+		case msg := <-msgs:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change reported")
+			return fsChangedMsg{}
+		}
+	}
+
+	node("a/keep.go").selected = true
+	node("a/remove.go").selected = true
+
+	msg := next(t, func() {
+		require.NoError(t, os.Remove(filepath.Join(dir, "a", "remove.go")))
+		writeFiles(t, dir, map[string]string{"a/new.go": "package a\n", "c/deep.go": "package c\n"})
+	})
+	require.True(t, msg.dirs[filepath.Join(dir, "a")])
+	m.handleFSChanges(msg)
+
+	require.Nil(t, node("a/remove.go"))
+	require.NotNil(t, node("a/new.go"))
+	require.NotNil(t, node("c/deep.go"))
+	require.True(t, node("a/keep.go").selected)
+	require.False(t, node("a/new.go").selected)
+
+	// Bursts of writes are reported once
+	msg = next(t, func() {
+		for i := range 5 {
+			writeFiles(t, dir, map[string]string{"b/edit.go": "package b\n" + string(rune('a'+i))})
+		}
+	})
+	require.Equal(t, map[string]bool{filepath.Join(dir, "b", "edit.go"): true}, msg.files)
+	m.handleFSChanges(msg)
+	require.NotNil(t, node("b/edit.go"))
+
+	// Directories created after the start are watched too
+	msg = next(t, func() {
+		writeFiles(t, dir, map[string]string{"c/more.go": "package c\n"})
+	})
+	require.True(t, msg.dirs[filepath.Join(dir, "c")])
+}
+
+func Test_handleFSChangesRename(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/big.go": numberedLines(10)})
+
+	renderer, err := glamour.NewTermRenderer(glamour.WithStandardStyle("notty"))
+	require.NoError(t, err)
+	m := &model{workDir: dir, findPattern: initFindInput(), renderer: renderer}
+	m.windowSize.width, m.windowSize.height = 200, 100
+	require.NoError(t, m.buildFileTree())
+	m.flattenTree()
+	big := m.nodeLookup[filepath.Join(dir, "a", "big.go")]
+	m.setRanges(big, []lineRange{{2, 3}})
+	m.updateContent()
+	require.Contains(t, m.rightViewport.View(), "line 2")
+	before := big.rangeTokens
+
+	// Editors that save through a rename only report the directory
+	tmp := filepath.Join(dir, "a", ".big.go.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("line 1\nchanged line two, now rather longer\nline 3\n"), 0o644))
+	require.NoError(t, os.Rename(tmp, big.path))
+	m.handleFSChanges(fsChangedMsg{dirs: map[string]bool{filepath.Join(dir, "a"): true}})
+
+	big = m.nodeLookup[filepath.Join(dir, "a", "big.go")]
+	require.True(t, big.selected)
+	require.Greater(t, big.rangeTokens, before)
+	require.Contains(t, m.rightViewport.View(), "changed line two")
+}